@@ -23,6 +23,11 @@ own systems when initiated from HCP Waypoint.
 
 	cmd.AddChild(NewCmdRun(ctx))
 	cmd.AddChild(NewCmdQueue(ctx))
+	cmd.AddChild(NewCmdExec(ctx))
+	cmd.AddChild(NewCmdValidate(ctx))
+	cmd.AddChild(NewCmdHistory(ctx))
+	cmd.AddChild(NewCmdInstallService(ctx))
+	cmd.AddChild(NewCmdExecLimited(ctx))
 	cmd.AddChild(NewCmdGroup(ctx))
 	return cmd
 }
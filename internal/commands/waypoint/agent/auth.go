@@ -0,0 +1,106 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	hcpconf "github.com/hashicorp/hcp-sdk-go/config"
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/oauth2"
+
+	"github.com/hashicorp/hcp/internal/pkg/auth"
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+)
+
+// newAuthClient returns a Waypoint client that authenticates as the auth
+// block of the agent's config says, rather than with the CLI's credentials.
+// Failures to get a token are logged and recorded in metrics.
+func newAuthClient(log hclog.Logger, a *agent.Auth, geography string, metrics *agentMetrics) (waypoint_service.ClientService, error) {
+	// The environment and the CLI's credential file are deliberately not
+	// consulted, so logging in on the host doesn't change the agent's
+	// identity.
+	options := []hcpconf.HCPConfigOption{
+		hcpconf.WithoutLogging(),
+		hcpconf.WithoutBrowserLogin(),
+	}
+
+	if a.CredFile != "" {
+		path, err := homedir.Expand(a.CredFile)
+		if err != nil {
+			return nil, fmt.Errorf("error expanding credential file path: %w", err)
+		}
+
+		options = append(options, hcpconf.WithCredentialFilePath(path))
+	} else {
+		options = append(options, hcpconf.WithClientCredentials(a.ClientID, a.ClientSecret))
+	}
+
+	if geography != "" {
+		options = append(options, hcpconf.WithGeography(geography))
+	}
+
+	hcpCfg, err := hcpconf.NewHCPConfig(options...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure agent auth: %w", err)
+	}
+
+	return newWaypointClient(&watchedHCPConfig{
+		HCPConfig: hcpCfg,
+		log:       log,
+		metrics:   metrics,
+	})
+}
+
+// watchedHCPConfig records whether tokens can be got from the HCPConfig it
+// wraps. Tokens are cached until they expire, so a failure means the token
+// couldn't be refreshed.
+type watchedHCPConfig struct {
+	hcpconf.HCPConfig
+
+	log     hclog.Logger
+	metrics *agentMetrics
+}
+
+func (c *watchedHCPConfig) Token() (*oauth2.Token, error) {
+	tok, err := c.HCPConfig.Token()
+
+	// Only log when the state changes, as every request asks for a token.
+	if changed := c.metrics.ObserveToken(err); changed {
+		if err != nil {
+			c.log.Error("unable to refresh HCP token", "error", err)
+		} else {
+			c.log.Info("HCP token refreshed")
+		}
+	}
+
+	return tok, err
+}
+
+// requireLogin checks that the CLI is logged in, for commands that call HCP
+// with its credentials.
+func requireLogin(ctx *cmd.Context) error {
+	options := []hcpconf.HCPConfigOption{hcpconf.WithoutBrowserLogin()}
+	if ctx.Profile != nil {
+		if geography := ctx.Profile.GetGeography(); geography != "" {
+			options = append(options, hcpconf.WithGeography(geography))
+		}
+	}
+
+	hcpCfg, err := auth.GetHCPConfig(options...)
+	if err != nil {
+		return fmt.Errorf("failed to instantiate HCP config: %w", err)
+	}
+
+	if tkn, err := hcpCfg.Token(); err != nil || !tkn.Expiry.After(time.Now()) {
+		return errors.New("no authentication detected: run \"hcp auth login\"")
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	hcpconf "github.com/hashicorp/hcp-sdk-go/config"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+)
+
+// tokenConfig is an HCPConfig whose tokens come from token.
+type tokenConfig struct {
+	hcpconf.HCPConfig
+
+	token func() (*oauth2.Token, error)
+}
+
+func (c *tokenConfig) Token() (*oauth2.Token, error) {
+	return c.token()
+}
+
+func TestAgentAuth(t *testing.T) {
+	t.Parallel()
+
+	t.Run("records and logs failures to refresh the token", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var tokenErr error
+
+		var logs bytes.Buffer
+		metrics := newAgentMetrics()
+
+		c := &watchedHCPConfig{
+			HCPConfig: &tokenConfig{
+				token: func() (*oauth2.Token, error) {
+					if tokenErr != nil {
+						return nil, tokenErr
+					}
+
+					return &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}, nil
+				},
+			},
+			log:     hclog.New(&hclog.LoggerOptions{Output: &logs}),
+			metrics: metrics,
+		}
+
+		_, err := c.Token()
+		r.NoError(err)
+		r.Empty(logs.String())
+
+		tokenErr = errors.New("invalid_client")
+
+		for range 3 {
+			_, err = c.Token()
+			r.ErrorIs(err, tokenErr)
+		}
+
+		r.ErrorIs(metrics.TokenError(), tokenErr)
+
+		// The failure is only logged once.
+		r.Equal(1, bytes.Count(logs.Bytes(), []byte("unable to refresh HCP token")))
+
+		tokenErr = nil
+
+		_, err = c.Token()
+		r.NoError(err)
+		r.NoError(metrics.TokenError())
+		r.Contains(logs.String(), "HCP token refreshed")
+
+		var out bytes.Buffer
+		_, err = metrics.WriteTo(&out)
+		r.NoError(err)
+		r.Contains(out.String(), "hcp_waypoint_agent_token_errors_total 3\n")
+	})
+
+	t.Run("builds a client from the auth block", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		client, err := newAuthClient(hclog.NewNullLogger(), &agent.Auth{
+			ClientID:     "id",
+			ClientSecret: "secret",
+		}, "", newAgentMetrics())
+		r.NoError(err)
+		r.NotNil(client)
+
+		_, err = newAuthClient(hclog.NewNullLogger(), &agent.Auth{
+			CredFile: filepath.Join(t.TempDir(), "missing.json"),
+		}, "", newAgentMetrics())
+		r.ErrorContains(err, "unable to configure agent auth")
+	})
+
+	t.Run("detects changes to the auth block", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		r.True(sameAuth(nil, nil))
+		r.False(sameAuth(nil, &agent.Auth{CredFile: "a.json"}))
+		r.True(sameAuth(&agent.Auth{CredFile: "a.json"}, &agent.Auth{CredFile: "a.json"}))
+		r.False(sameAuth(&agent.Auth{CredFile: "a.json"}, &agent.Auth{CredFile: "b.json"}))
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultPollMinInterval = time.Second
+	defaultPollMaxInterval = 60 * time.Second
+
+	// defaultPollErrorMaxInterval is the ceiling on the wait between polls
+	// while retrieving operations is failing.
+	defaultPollErrorMaxInterval = 5 * time.Minute
+)
+
+// pollBackoff calculates how long the agent waits between polls for new
+// operations. When an operation was found, the agent polls again right away.
+// When the queue is empty the wait doubles from min up to max, and when
+// retrieving fails the wait doubles separately up to errorMax.
+type pollBackoff struct {
+	min      time.Duration
+	max      time.Duration
+	errorMax time.Duration
+
+	idle   time.Duration
+	failed time.Duration
+
+	// jitter randomizes a wait so that many agents don't poll in lockstep.
+	jitter func(time.Duration) time.Duration
+}
+
+func newPollBackoff(minInterval, maxInterval time.Duration) *pollBackoff {
+	if minInterval <= 0 {
+		minInterval = defaultPollMinInterval
+	}
+
+	if maxInterval <= 0 {
+		maxInterval = defaultPollMaxInterval
+	}
+
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+
+	return &pollBackoff{
+		min:      minInterval,
+		max:      maxInterval,
+		errorMax: max(defaultPollErrorMaxInterval, maxInterval),
+		jitter:   equalJitter,
+	}
+}
+
+// Next returns how long to wait before the next poll, given the result of the
+// previous one.
+func (b *pollBackoff) Next(found bool, err error) time.Duration {
+	switch {
+	case err != nil:
+		b.failed = b.grow(b.failed, b.errorMax)
+		return b.jitter(b.failed)
+	case found:
+		b.idle = 0
+		b.failed = 0
+		return 0
+	default:
+		b.failed = 0
+		b.idle = b.grow(b.idle, b.max)
+		return b.jitter(b.idle)
+	}
+}
+
+func (b *pollBackoff) grow(cur, ceiling time.Duration) time.Duration {
+	if cur == 0 {
+		return b.min
+	}
+
+	return min(cur*2, ceiling)
+}
+
+// equalJitter returns a random duration between d/2 and d.
+func equalJitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+
+	return half + rand.N(half)
+}
@@ -0,0 +1,261 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/flagvalue"
+	"github.com/hashicorp/hcp/internal/pkg/heredoc"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+)
+
+type ExecOpts struct {
+	opts.WaypointOpts
+
+	ConfigPath string
+	Group      string
+	ID         string
+	Body       string
+	DryRun     bool
+}
+
+func NewCmdExec(ctx *cmd.Context) *cmd.Command {
+	opts := &ExecOpts{
+		WaypointOpts: opts.New(ctx),
+	}
+
+	cmd := &cmd.Command{
+		Name:      "exec",
+		ShortHelp: "Run an action from the agent configuration locally.",
+		LongHelp: heredoc.New(ctx.IO).Must(`
+		The {{ template "mdCodeOrBold" "hcp waypoint agent exec" }} command runs an action from an
+agent configuration file immediately, without queueing it through HCP Waypoint. The
+result of each step is printed and the command exits with the operation's status code.
+
+Status operations are printed rather than sent, since there is no action run to send them to.
+No HCP login is needed, and operations that start other actions fail, since they need HCP.
+
+Use {{ template "mdCodeOrBold" "--dry-run" }} to print the operations the action resolves to
+without running them.
+		`),
+		Examples: []cmd.Example{
+			{
+				Preamble: "Run the deploy action of the prod group:",
+				Command:  `$ hcp waypoint agent exec -g prod -i deploy -d '{"var": {"version": "1.2.3"}}'`,
+			},
+			{
+				Preamble: "Show what the deploy action would run, reading the body from a file:",
+				Command:  "$ hcp waypoint agent exec -g prod -i deploy -d @body.json --dry-run",
+			},
+		},
+		Flags: cmd.Flags{
+			Local: []*cmd.Flag{
+				{
+					Name:         "config",
+					Shorthand:    "c",
+					DisplayValue: "PATH",
+					Description:  "Path to configuration file for agent.",
+					Value:        flagvalue.Simple("agent.hcl", &opts.ConfigPath),
+				},
+				{
+					Name:         "group",
+					Shorthand:    "g",
+					DisplayValue: "NAME",
+					Description:  "Agent group the action belongs to.",
+					Value:        flagvalue.Simple("", &opts.Group),
+					Required:     true,
+				},
+				{
+					Name:         "id",
+					Shorthand:    "i",
+					DisplayValue: "ID",
+					Description:  "Id of the action to run.",
+					Value:        flagvalue.Simple("", &opts.ID),
+					Required:     true,
+				},
+				{
+					Name:         "body",
+					Shorthand:    "d",
+					DisplayValue: "JSON",
+					Description:  "JSON to pass to operation. Use @filename to read json from a file.",
+					Value:        flagvalue.Simple("", &opts.Body),
+				},
+				{
+					Name:          "dry-run",
+					Description:   "Print the resolved operations without running them.",
+					Value:         flagvalue.Simple(false, &opts.DryRun),
+					IsBooleanFlag: true,
+				},
+			},
+		},
+		// Actions are run locally, so HCP is never called.
+		NoAuthRequired: true,
+		RunF: func(c *cmd.Command, args []string) error {
+			return agentExec(c.Logger(), opts)
+		},
+	}
+
+	return cmd
+}
+
+func agentExec(log hclog.Logger, opts *ExecOpts) error {
+	body, err := readBody(opts.Body)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := agent.ParseConfigFile(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+
+	ok, err := cfg.IsAvailable(opts.Group, opts.ID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("action %q is not defined in group %q", opts.ID, opts.Group)
+	}
+
+	opInfo := &models.HashicorpCloudWaypointV20241122AgentOperation{
+		Group: opts.Group,
+		ID:    opts.ID,
+		Body:  body,
+	}
+
+	exec := &agent.Executor{
+		Log:    log,
+		Config: cfg,
+	}
+
+	out := opts.IO.Out()
+
+	if opts.DryRun {
+		cfg.WrapOperations(agent.NoopOperations)
+
+		op, m, err := exec.Resolve(opInfo)
+		if err != nil {
+			return m.MaskError(err)
+		}
+
+		// The tree shows evaluated arguments, so keep secrets and sensitive
+		// inputs out of it.
+		var buf bytes.Buffer
+		if err := agent.WriteTree(&buf, op); err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(out, m.Mask(buf.String()))
+		return err
+	}
+
+	if err := cfg.LoadSecrets(); err != nil {
+		return err
+	}
+
+	// Steps in parallel blocks print as they finish, so share one lock.
+	lockedOut := &lockedWriter{w: out}
+
+	cfg.WrapOperations(func(op agent.Operation) agent.Operation {
+		if !agent.IsLeaf(op) {
+			return op
+		}
+
+		return &localStep{Operation: op, out: lockedOut}
+	})
+
+	status, err := exec.Execute(opts.Ctx, nil, nil, opInfo)
+	if err != nil {
+		return err
+	}
+
+	if status.Code != 0 {
+		return cmd.NewExitError(status.Code, errors.New(status.Status))
+	}
+
+	_, _ = fmt.Fprintf(opts.IO.Err(), "Action '%s' finished: %s\n", opts.ID, status.Status)
+	return nil
+}
+
+// errExecRunAction is returned by operations that start other actions, which
+// need HCP, when run locally.
+var errExecRunAction = errors.New("starting other actions is not available when running locally, queue the action through HCP instead")
+
+// localStep prints the result of an operation as it finishes. Status
+// operations are printed instead of being sent, since a local run has no
+// action run to send them to.
+type localStep struct {
+	Operation agent.Operation
+	out       io.Writer
+}
+
+func (s *localStep) Run(
+	ctx context.Context,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (agent.OperationStatus, error) {
+	m := agent.MaskerFrom(ctx)
+
+	if so, ok := s.Operation.(*agent.StatusOperation); ok {
+		_, _ = fmt.Fprintf(s.out, "status: %s\n", m.Mask(so.Message))
+		return agent.OperationStatus{}, nil
+	}
+
+	if _, ok := s.Operation.(*agent.RunActionOperation); ok {
+		err := errExecRunAction
+		_, _ = fmt.Fprintln(s.out, m.Mask(fmt.Sprintf("%s: error: %s", agent.Describe(s.Operation), err)))
+		return agent.OperationStatus{Code: 1, Status: err.Error()}, err
+	}
+
+	status, err := s.Operation.Run(ctx, log, api, profile, opInfo)
+	if err != nil {
+		_, _ = fmt.Fprintln(s.out, m.Mask(fmt.Sprintf("%s: error: %s", agent.Describe(s.Operation), err)))
+		return status, err
+	}
+
+	var buf strings.Builder
+
+	fmt.Fprintf(&buf, "%s: code %d: %s\n", agent.Describe(s.Operation), status.Code, status.Status)
+
+	// Values such as the outputs of commands are sent to HCP as status logs,
+	// so show them here instead.
+	for _, k := range slices.Sorted(maps.Keys(status.Values)) {
+		fmt.Fprintf(&buf, "  %s = %s\n", k, status.Values[k])
+	}
+
+	_, _ = io.WriteString(s.out, m.Mask(buf.String()))
+	return status, nil
+}
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(p)
+}
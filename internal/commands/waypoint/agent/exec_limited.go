@@ -0,0 +1,70 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/flagvalue"
+	"github.com/hashicorp/hcp/internal/pkg/heredoc"
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+)
+
+type ExecLimitedOpts struct {
+	Limits []string
+	User   string
+}
+
+// NewCmdExecLimited returns the hidden command the agent runs itself with to
+// apply the resource limits of a process block before running its command.
+func NewCmdExecLimited(ctx *cmd.Context) *cmd.Command {
+	opts := &ExecLimitedOpts{}
+
+	cmd := &cmd.Command{
+		Name:      "exec-limited",
+		ShortHelp: "Run a command with resource limits applied.",
+		LongHelp: heredoc.New(ctx.IO).Must(`
+		The {{ template "mdCodeOrBold" "hcp waypoint agent exec-limited" }} command applies resource
+limits and then runs a command in its place. It is run by the agent for operations whose
+{{ template "mdCodeOrBold" "process" }} block sets {{ template "mdCodeOrBold" "limits" }}, and is not meant to be run directly.
+		`),
+		Args: cmd.PositionalArguments{
+			Args: []cmd.PositionalArgument{
+				{
+					Name:          "PATH",
+					Documentation: "Path of the command to run.",
+				},
+				{
+					Name:          "ARGS",
+					Documentation: "Arguments of the command, starting with its name.",
+					Repeatable:    true,
+				},
+			},
+		},
+		Flags: cmd.Flags{
+			Local: []*cmd.Flag{
+				{
+					Name:         "limit",
+					DisplayValue: "NAME=VALUE",
+					Description:  "Resource limit to apply, both soft and hard.",
+					Value:        flagvalue.SimpleSlice(nil, &opts.Limits),
+					Repeatable:   true,
+				},
+				{
+					Name:         "user",
+					DisplayValue: "UID:GID",
+					Description:  "User and group to run the command as.",
+					Value:        flagvalue.Simple("", &opts.User),
+				},
+			},
+		},
+		Hidden:         true,
+		NoAuthRequired: true,
+		RunF: func(c *cmd.Command, args []string) error {
+			err := agent.ExecLimited(opts.Limits, opts.User, args[0], args[1:])
+			return cmd.NewExitError(agent.LimitExitCode, err)
+		},
+	}
+
+	return cmd
+}
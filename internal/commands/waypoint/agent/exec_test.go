@@ -0,0 +1,238 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/iostreams"
+)
+
+func TestAgentExec(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.hcl")
+
+	err := os.WriteFile(path, []byte(`
+group "test" {
+  action "deploy" {
+    input "version" {
+      type     = string
+      required = true
+    }
+
+    operation {
+      name = "build"
+      run {
+        command = ["echo", "building ${var.version}"]
+      }
+    }
+
+    operation {
+      name = "announce"
+      status {
+        message = "deployed ${var.version}"
+      }
+    }
+  }
+
+  action "release" {
+    operation {
+      action {
+        name        = "deploy"
+        application = "web"
+      }
+    }
+  }
+
+  action "empty" {
+    run {
+      command = []
+    }
+  }
+
+  action "fail" {
+    operation {
+      run {
+        command = ["sh", "-c", "exit 3"]
+      }
+    }
+
+    on_failure {
+      status {
+        message = "it broke"
+      }
+    }
+  }
+}
+`), 0o600)
+	require.NoError(t, err)
+
+	execOpts := func(io *iostreams.Testing, id, body string) *ExecOpts {
+		return &ExecOpts{
+			WaypointOpts: opts.WaypointOpts{
+				Ctx: context.Background(),
+				IO:  io,
+			},
+			ConfigPath: path,
+			Group:      "test",
+			ID:         id,
+			Body:       body,
+		}
+	}
+
+	t.Run("runs the action", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		io := iostreams.Test()
+		err := agentExec(hclog.NewNullLogger(), execOpts(io, "deploy", `{"var": {"version": "1.2.3"}}`))
+		r.NoError(err)
+
+		r.Contains(io.Output.String(), "run echo building 1.2.3: code 0: output: building 1.2.3\n")
+		r.Contains(io.Output.String(), "status: deployed 1.2.3\n")
+	})
+
+	t.Run("exits with the operation's code", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		io := iostreams.Test()
+		err := agentExec(hclog.NewNullLogger(), execOpts(io, "fail", ""))
+
+		var exitErr *cmd.ExitCodeError
+		r.True(errors.As(err, &exitErr))
+		r.Equal(3, exitErr.Code)
+		r.Contains(io.Output.String(), "status: it broke\n")
+	})
+
+	t.Run("reports invalid input", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		io := iostreams.Test()
+		err := agentExec(hclog.NewNullLogger(), execOpts(io, "deploy", ""))
+
+		var exitErr *cmd.ExitCodeError
+		r.True(errors.As(err, &exitErr))
+		r.Equal(64, exitErr.Code)
+		r.ErrorContains(err, "version is required")
+	})
+
+	t.Run("dry run prints the operation tree", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		io := iostreams.Test()
+		o := execOpts(io, "deploy", `{"var": {"version": "1.2.3"}}`)
+		o.DryRun = true
+
+		r.NoError(agentExec(hclog.NewNullLogger(), o))
+		r.Equal(`2 steps
+  step build: run echo building 1.2.3
+  step announce: status "deployed 1.2.3"
+`, io.Output.String())
+	})
+
+	t.Run("dry run does not read secrets", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		path := filepath.Join(t.TempDir(), "agent.hcl")
+		r.NoError(os.WriteFile(path, []byte(`
+secret "token" {
+  env = "HCP_WAYPOINT_AGENT_TEST_UNSET"
+}
+
+group "test" {
+  action "deploy" {
+    run {
+      command = ["./deploy.sh", secret.token]
+    }
+  }
+}
+`), 0o600))
+
+		io := iostreams.Test()
+		o := execOpts(io, "deploy", "")
+		o.ConfigPath = path
+		o.DryRun = true
+
+		r.NoError(agentExec(hclog.NewNullLogger(), o))
+		r.Equal("run ./deploy.sh (sensitive)\n", io.Output.String())
+
+		o = execOpts(iostreams.Test(), "deploy", "")
+		o.ConfigPath = path
+		r.ErrorContains(agentExec(hclog.NewNullLogger(), o), "HCP_WAYPOINT_AGENT_TEST_UNSET is not set")
+	})
+
+	t.Run("dry run masks sensitive inputs", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		path := filepath.Join(t.TempDir(), "agent.hcl")
+		r.NoError(os.WriteFile(path, []byte(`
+group "test" {
+  action "deploy" {
+    input "password" {
+      sensitive = true
+    }
+
+    run {
+      command = ["./deploy.sh", "--password=${var.password}"]
+    }
+  }
+}
+`), 0o600))
+
+		io := iostreams.Test()
+		o := execOpts(io, "deploy", `{"var": {"password": "hunter2"}}`)
+		o.ConfigPath = path
+		o.DryRun = true
+
+		r.NoError(agentExec(hclog.NewNullLogger(), o))
+		r.Equal("run ./deploy.sh --password=(sensitive)\n", io.Output.String())
+	})
+
+	t.Run("rejects operations that start other actions", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		io := iostreams.Test()
+		err := agentExec(hclog.NewNullLogger(), execOpts(io, "release", ""))
+		r.ErrorIs(err, errExecRunAction)
+		r.Contains(io.Output.String(), "error: starting other actions is not available when running locally")
+	})
+
+	t.Run("reports commands without a program", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		io := iostreams.Test()
+		err := agentExec(hclog.NewNullLogger(), execOpts(io, "empty", ""))
+		r.ErrorContains(err, "Invalid command")
+
+		o := execOpts(iostreams.Test(), "empty", "")
+		o.DryRun = true
+		r.ErrorContains(agentExec(hclog.NewNullLogger(), o), "Invalid command")
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		t.Parallel()
+
+		io := iostreams.Test()
+		err := agentExec(hclog.NewNullLogger(), execOpts(io, "nope", ""))
+		require.ErrorContains(t, err, `action "nope" is not defined in group "test"`)
+	})
+}
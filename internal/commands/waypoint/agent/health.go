@@ -0,0 +1,108 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// readyPollIntervals is how many of the longest poll intervals may pass
+	// without a successful poll before the agent reports it is not ready.
+	// Time spent with every worker busy, when the agent doesn't poll, doesn't
+	// count.
+	readyPollIntervals = 3
+
+	// healthShutdownTimeout bounds how long the health listener waits for
+	// open requests when the agent exits.
+	healthShutdownTimeout = 5 * time.Second
+)
+
+// healthHandler serves the agent's health checks and metrics.
+type healthHandler struct {
+	metrics *agentMetrics
+
+	// readyWithin is how recently the agent must have polled successfully to
+	// be ready.
+	readyWithin time.Duration
+}
+
+func (h *healthHandler) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := h.metrics.TokenError(); err != nil {
+			http.Error(w, fmt.Sprintf("not ready: unable to refresh HCP token: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		// An agent busy with long operations is healthy, it just has no room
+		// to poll for more.
+		busy, lastBusy := h.metrics.Busy()
+		if busy {
+			_, _ = fmt.Fprintln(w, "ok")
+			return
+		}
+
+		last := h.metrics.LastPoll()
+
+		if last.IsZero() {
+			http.Error(w, "not ready: no successful poll yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		if since := time.Since(last); since > h.readyWithin && time.Since(lastBusy) > h.readyWithin {
+			http.Error(w, fmt.Sprintf("not ready: last successful poll was %s ago", since.Round(time.Second)), http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = h.metrics.WriteTo(w)
+	})
+
+	return mux
+}
+
+// serveHealth serves h on addr until the returned function is called.
+func serveHealth(log hclog.Logger, addr string, h *healthHandler) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for health checks on %s: %w", addr, err)
+	}
+
+	srv := &http.Server{
+		Handler:           h.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("health listener failed", "error", err)
+		}
+	}()
+
+	log.Info("serving health checks and metrics", "addr", ln.Addr().String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+		defer cancel()
+
+		_ = srv.Shutdown(ctx)
+	}, nil
+}
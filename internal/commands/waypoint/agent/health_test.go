@@ -0,0 +1,142 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+
+	get := func(h http.Handler, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	t.Run("healthz", func(t *testing.T) {
+		t.Parallel()
+
+		h := (&healthHandler{metrics: newAgentMetrics(), readyWithin: time.Minute}).Handler()
+		require.Equal(t, http.StatusOK, get(h, "/healthz").Code)
+	})
+
+	t.Run("readyz follows the last successful poll", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		m := newAgentMetrics()
+		h := (&healthHandler{metrics: m, readyWithin: time.Minute}).Handler()
+
+		r.Equal(http.StatusServiceUnavailable, get(h, "/readyz").Code)
+
+		m.ObservePoll(errors.New("nope"))
+		r.Equal(http.StatusServiceUnavailable, get(h, "/readyz").Code)
+
+		m.ObservePoll(nil)
+		r.Equal(http.StatusOK, get(h, "/readyz").Code)
+
+		m.mu.Lock()
+		m.lastPoll = time.Now().Add(-2 * time.Minute)
+		m.mu.Unlock()
+
+		w := get(h, "/readyz")
+		r.Equal(http.StatusServiceUnavailable, w.Code)
+		r.Contains(w.Body.String(), "last successful poll was 2m0s ago")
+	})
+
+	t.Run("readyz stays ready while every worker is busy", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		m := newAgentMetrics()
+		h := (&healthHandler{metrics: m, readyWithin: time.Minute}).Handler()
+
+		m.ObservePoll(nil)
+		m.SetBusy(true)
+
+		// Long operations keep the agent from polling.
+		m.mu.Lock()
+		m.lastPoll = time.Now().Add(-time.Hour)
+		m.mu.Unlock()
+
+		r.Equal(http.StatusOK, get(h, "/readyz").Code)
+
+		// Once a worker is free the agent has a while to poll again.
+		m.SetBusy(false)
+		r.Equal(http.StatusOK, get(h, "/readyz").Code)
+
+		m.mu.Lock()
+		m.lastBusy = time.Now().Add(-2 * time.Minute)
+		m.mu.Unlock()
+
+		r.Equal(http.StatusServiceUnavailable, get(h, "/readyz").Code)
+	})
+
+	t.Run("readyz reports failures to refresh the token", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		m := newAgentMetrics()
+		m.ObservePoll(nil)
+		h := (&healthHandler{metrics: m, readyWithin: time.Minute}).Handler()
+
+		m.ObserveToken(errors.New("invalid_client"))
+
+		w := get(h, "/readyz")
+		r.Equal(http.StatusServiceUnavailable, w.Code)
+		r.Contains(w.Body.String(), "unable to refresh HCP token: invalid_client")
+
+		m.ObserveToken(nil)
+		r.Equal(http.StatusOK, get(h, "/readyz").Code)
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		m := newAgentMetrics()
+		m.ObservePoll(nil)
+		m.ObservePoll(errors.New("nope"))
+
+		m.OperationStarted()
+		m.OperationStarted()
+		m.OperationFinished("test", "deploy", 0, 2*time.Second)
+		m.OperationStarted()
+		m.OperationFinished("test", "deploy", 2, 20*time.Second)
+
+		h := (&healthHandler{metrics: m, readyWithin: time.Minute}).Handler()
+
+		w := get(h, "/metrics")
+		r.Equal(http.StatusOK, w.Code)
+		r.Equal("text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+
+		body := w.Body.String()
+		r.Contains(body, "hcp_waypoint_agent_polls_total 2\n")
+		r.Contains(body, "hcp_waypoint_agent_poll_errors_total 1\n")
+		r.Contains(body, "hcp_waypoint_agent_token_errors_total 0\n")
+		r.Contains(body, "hcp_waypoint_agent_operations_in_flight 1\n")
+		r.Contains(body, `hcp_waypoint_agent_operations_total{group="test",action="deploy",code="0"} 1`+"\n")
+		r.Contains(body, `hcp_waypoint_agent_operations_total{group="test",action="deploy",code="2"} 1`+"\n")
+		r.Contains(body, `hcp_waypoint_agent_operation_duration_seconds_bucket{group="test",action="deploy",le="1"} 0`+"\n")
+		r.Contains(body, `hcp_waypoint_agent_operation_duration_seconds_bucket{group="test",action="deploy",le="5"} 1`+"\n")
+		r.Contains(body, `hcp_waypoint_agent_operation_duration_seconds_bucket{group="test",action="deploy",le="30"} 2`+"\n")
+		r.Contains(body, `hcp_waypoint_agent_operation_duration_seconds_bucket{group="test",action="deploy",le="+Inf"} 2`+"\n")
+		r.Contains(body, `hcp_waypoint_agent_operation_duration_seconds_sum{group="test",action="deploy"} 22`+"\n")
+		r.Contains(body, `hcp_waypoint_agent_operation_duration_seconds_count{group="test",action="deploy"} 2`+"\n")
+	})
+
+	t.Run("escapes label values", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, `"a\"b\\c\nd"`, quoteLabel("a\"b\\c\nd"))
+	})
+}
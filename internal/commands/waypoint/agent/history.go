@@ -0,0 +1,208 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"slices"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/flagvalue"
+	"github.com/hashicorp/hcp/internal/pkg/format"
+	"github.com/hashicorp/hcp/internal/pkg/heredoc"
+)
+
+type HistoryOpts struct {
+	opts.WaypointOpts
+
+	HistoryPath string
+	Group       string
+	Action      string
+	ActionRunID string
+	Failed      bool
+	Pending     bool
+	Limit       int
+}
+
+func NewCmdHistory(ctx *cmd.Context) *cmd.Command {
+	opts := &HistoryOpts{
+		WaypointOpts: opts.New(ctx),
+	}
+
+	cmd := &cmd.Command{
+		Name:      "history",
+		ShortHelp: "List the operations a local agent has run.",
+		LongHelp: heredoc.New(ctx.IO).Must(`
+		The {{ template "mdCodeOrBold" "hcp waypoint agent history" }} command lists the operations
+recorded in the history of an agent running on this machine, most recent first.
+
+Each entry records the group, action and action run, the inputs with sensitive inputs
+redacted, when the operation started and ended, its status code and the end of its output.
+Use {{ template "mdCodeOrBold" "--format=json" }} to see every field.
+
+If the agent was run with a custom {{ template "mdCodeOrBold" "--history-path" }}, pass the same path.
+		`),
+		Examples: []cmd.Example{
+			{
+				Preamble: "List the most recent operations:",
+				Command:  "$ hcp waypoint agent history",
+			},
+			{
+				Preamble: "List failed runs of the deploy action in the prod group:",
+				Command:  "$ hcp waypoint agent history -g prod --action deploy --failed",
+			},
+		},
+		Flags: cmd.Flags{
+			Local: []*cmd.Flag{
+				{
+					Name:         "history-path",
+					DisplayValue: "PATH",
+					Description:  "History file the agent records operations in.",
+					Value:        flagvalue.Simple(defaultHistoryPath, &opts.HistoryPath),
+				},
+				{
+					Name:         "group",
+					Shorthand:    "g",
+					DisplayValue: "NAME",
+					Description:  "Only list operations for this group.",
+					Value:        flagvalue.Simple("", &opts.Group),
+				},
+				{
+					Name:         "action",
+					DisplayValue: "ID",
+					Description:  "Only list operations for this action.",
+					Value:        flagvalue.Simple("", &opts.Action),
+				},
+				{
+					Name:         "action-run",
+					DisplayValue: "ID",
+					Description:  "Only list the operation for this action run.",
+					Value:        flagvalue.Simple("", &opts.ActionRunID),
+				},
+				{
+					Name:          "failed",
+					Description:   "Only list operations that ended with a non-zero status code.",
+					Value:         flagvalue.Simple(false, &opts.Failed),
+					IsBooleanFlag: true,
+				},
+				{
+					Name:          "pending",
+					Description:   "Only list operations whose ending has not yet been reported to HCP.",
+					Value:         flagvalue.Simple(false, &opts.Pending),
+					IsBooleanFlag: true,
+				},
+				{
+					Name:         "limit",
+					DisplayValue: "N",
+					Description:  "Maximum number of operations to list. Use 0 to list all of them.",
+					Value:        flagvalue.Simple(20, &opts.Limit),
+				},
+			},
+		},
+		// The history is read from a local file, so HCP is never called.
+		NoAuthRequired: true,
+		RunF: func(c *cmd.Command, args []string) error {
+			return agentHistory(c.Logger(), opts)
+		},
+	}
+
+	return cmd
+}
+
+func agentHistory(log hclog.Logger, opts *HistoryOpts) error {
+	history, err := openHistory(opts.HistoryPath)
+	if err != nil {
+		return err
+	}
+
+	entries, err := history.List()
+	if err != nil {
+		return err
+	}
+
+	// Most recent first.
+	slices.Reverse(entries)
+
+	var matched historyDisplayer
+
+	for _, e := range entries {
+		if opts.Limit > 0 && len(matched) >= opts.Limit {
+			break
+		}
+
+		if !opts.matches(e) {
+			continue
+		}
+
+		matched = append(matched, e)
+	}
+
+	if matched == nil {
+		matched = historyDisplayer{}
+	}
+
+	return opts.Output.Display(matched)
+}
+
+func (o *HistoryOpts) matches(e *historyEntry) bool {
+	switch {
+	case o.Group != "" && e.Group != o.Group:
+		return false
+	case o.Action != "" && e.Action != o.Action:
+		return false
+	case o.ActionRunID != "" && e.ActionRunID != o.ActionRunID:
+		return false
+	case o.Failed && e.Code == 0:
+		return false
+	case o.Pending && !e.ReportPending:
+		return false
+	default:
+		return true
+	}
+}
+
+type historyDisplayer []*historyEntry
+
+func (d historyDisplayer) DefaultFormat() format.Format {
+	return format.Table
+}
+
+func (d historyDisplayer) Payload() any {
+	return d
+}
+
+func (d historyDisplayer) FieldTemplates() []format.Field {
+	return []format.Field{
+		{
+			Name:        "Started At",
+			ValueFormat: "{{ .StartedAt }}",
+		},
+		{
+			Name:        "Group",
+			ValueFormat: "{{ .Group }}",
+		},
+		{
+			Name:        "Action",
+			ValueFormat: "{{ .Action }}",
+		},
+		{
+			Name:        "Action Run",
+			ValueFormat: "{{ .ActionRunID }}",
+		},
+		{
+			Name:        "Code",
+			ValueFormat: "{{ .Code }}",
+		},
+		{
+			Name:        "Duration",
+			ValueFormat: "{{ .Duration }}",
+		},
+		{
+			Name:        "Status",
+			ValueFormat: "{{ .Status }}",
+		},
+	}
+}
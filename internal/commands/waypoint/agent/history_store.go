@@ -0,0 +1,284 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+const (
+	// defaultHistoryPath is where the agent records the operations it runs if
+	// no other path is configured.
+	defaultHistoryPath = profile.ConfigDir + "waypoint-agent/history.jsonl"
+
+	// historyMaxEntries is how many entries the history keeps. Older entries
+	// are dropped when the agent starts, and as it appends entries once the
+	// history has grown by historyTrimSlack past it.
+	historyMaxEntries = 1000
+
+	// historyTrimSlack is how far past its limit the history grows before it
+	// is trimmed, so it isn't rewritten on every append.
+	historyTrimSlack = historyMaxEntries / 10
+)
+
+// historyEntry records one operation the agent ran.
+type historyEntry struct {
+	// Project is the name of the project block the operation came from, or
+	// empty for the project of the agent's profile.
+	Project string `json:"project,omitempty"`
+
+	Group       string         `json:"group"`
+	Action      string         `json:"action"`
+	ActionRunID string         `json:"action_run_id,omitempty"`
+	Inputs      map[string]any `json:"inputs,omitempty"`
+	StartedAt   time.Time      `json:"started_at"`
+	EndedAt     time.Time      `json:"ended_at"`
+	Status      string         `json:"status"`
+	Code        int            `json:"code"`
+	Output      string         `json:"output,omitempty"`
+
+	// ReportPending is set when the run's ending could not be reported to
+	// HCP. The report is retried the next time the agent starts.
+	ReportPending bool `json:"report_pending,omitempty"`
+}
+
+// Duration returns how long the operation ran.
+func (e *historyEntry) Duration() time.Duration {
+	return e.EndedAt.Sub(e.StartedAt).Round(time.Millisecond)
+}
+
+// historyStore keeps the agent's history as a file of JSON lines.
+type historyStore struct {
+	path string
+	mu   sync.Mutex
+
+	// maxEntries and trimSlack are historyMaxEntries and historyTrimSlack,
+	// unless changed by tests.
+	maxEntries int
+	trimSlack  int
+
+	// count is how many entries the file holds, once counted is set.
+	count   int
+	counted bool
+}
+
+// openHistory opens the history at path, creating its directory if needed.
+func openHistory(path string) (*historyStore, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, fmt.Errorf("error expanding history path %q: %w", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create history directory: %w", err)
+	}
+
+	return &historyStore{
+		path:       path,
+		maxEntries: historyMaxEntries,
+		trimSlack:  historyTrimSlack,
+	}, nil
+}
+
+// Append adds an entry to the end of the history, trimming the oldest ones
+// once it has grown past its limit.
+func (h *historyStore) Append(e *historyEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.counted {
+		entries, err := h.list()
+		if err != nil {
+			return err
+		}
+
+		h.count, h.counted = len(entries), true
+	}
+
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	if err := errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+
+	h.count++
+
+	if h.count > h.maxEntries+h.trimSlack {
+		return h.update(h.trim)
+	}
+
+	return nil
+}
+
+// trim returns the last maxEntries of entries.
+func (h *historyStore) trim(entries []*historyEntry) []*historyEntry {
+	if len(entries) > h.maxEntries {
+		entries = entries[len(entries)-h.maxEntries:]
+	}
+
+	return entries
+}
+
+// List returns the entries in the history, oldest first. Lines that can not
+// be read, such as one cut short by a crash, are skipped.
+func (h *historyStore) List() ([]*historyEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.list()
+}
+
+func (h *historyStore) list() ([]*historyEntry, error) {
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var entries []*historyEntry
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+
+	for sc.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+
+		entries = append(entries, &e)
+	}
+
+	return entries, nil
+}
+
+// Update calls f with every entry in the history and rewrites it with the
+// entries f returns.
+func (h *historyStore) Update(f func([]*historyEntry) []*historyEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.update(f)
+}
+
+func (h *historyStore) update(f func([]*historyEntry) []*historyEntry) error {
+	entries, err := h.list()
+	if err != nil {
+		return err
+	}
+
+	entries = f(entries)
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	// Write to a temporary file first so a crash can not lose the history.
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+
+	h.count, h.counted = len(entries), true
+
+	return nil
+}
+
+// record appends entry to the runner's history, if it keeps one.
+func (r *agentRunner) record(log hclog.Logger, entry *historyEntry) {
+	if r.history == nil {
+		return
+	}
+
+	if err := r.history.Append(entry); err != nil {
+		log.Error("unable to record operation in history", "error", err)
+	}
+}
+
+// retryPendingReports sends the ending reports that failed to send when their
+// operations finished. Reports that HCP rejects are not retried again. The
+// history is trimmed at the same time.
+func (r *agentRunner) retryPendingReports(ctx context.Context) {
+	if r.history == nil {
+		return
+	}
+
+	err := r.history.Update(func(entries []*historyEntry) []*historyEntry {
+		for _, e := range entries {
+			if !e.ReportPending {
+				continue
+			}
+
+			log := r.log.With("group", e.Group, "operation", e.Action, "action-run-id", e.ActionRunID)
+			if e.Project != "" {
+				log = log.With("project", e.Project)
+			}
+
+			t := r.target(e.Project)
+			if t == nil {
+				log.Warn("project of action run is no longer in the config, keeping its ending to send later")
+				continue
+			}
+
+			err := reportEnding(ctx, t, e.ActionRunID, e.Status, e.Code)
+
+			var respErr runtime.ClientResponseStatus
+
+			switch {
+			case err == nil:
+				log.Info("sent ending of action run that previously failed to send")
+				e.ReportPending = false
+			case errors.As(err, &respErr) && respErr.IsClientError():
+				log.Warn("HCP rejected ending of action run, not retrying", "error", err)
+				e.ReportPending = false
+			default:
+				log.Error("unable to send ending of action run, will retry on next start", "error", err)
+			}
+		}
+
+		return r.history.trim(entries)
+	})
+	if err != nil {
+		r.log.Error("unable to update history", "error", err)
+	}
+}
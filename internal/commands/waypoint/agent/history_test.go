@@ -0,0 +1,245 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	mock_waypoint_service "github.com/hashicorp/hcp/internal/pkg/api/mocks/github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp/internal/pkg/format"
+	"github.com/hashicorp/hcp/internal/pkg/iostreams"
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+)
+
+func TestAgentHistory(t *testing.T) {
+	t.Parallel()
+
+	t.Run("records operations and retries failed ending reports", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `
+		group "test" {
+			action "launch" {
+				input "token" {
+					sensitive = true
+				}
+
+				run {
+					command = ["echo", "launched"]
+				}
+			}
+		}
+`)
+
+		history, err := openHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+		r.NoError(err)
+		runner.history = history
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		expectActionRun(api, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "run-1",
+			Body:        []byte(`{"var.token": "hunter2", "var.version": "1.2.3"}`),
+		}, cancel)
+
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.Anything, mock.Anything).
+			Return(nil, errors.New("connection refused")).
+			Once()
+
+		r.NoError(runner.Run(ctx))
+
+		entries, err := history.List()
+		r.NoError(err)
+		r.Len(entries, 1)
+
+		e := entries[0]
+		r.Equal("test", e.Group)
+		r.Equal("launch", e.Action)
+		r.Equal("run-1", e.ActionRunID)
+		r.Equal(0, e.Code)
+		r.Equal("launched", e.Output)
+		r.True(e.ReportPending)
+		r.False(e.EndedAt.Before(e.StartedAt))
+		r.Equal(map[string]any{
+			"var": map[string]any{
+				"token":   agent.RedactedValue,
+				"version": "1.2.3",
+			},
+		}, e.Inputs)
+
+		// The next start sends the report again.
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-1" && params.Body.StatusCode == 0
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceEndingActionOK{}, nil).
+			Once()
+
+		runner.retryPendingReports(context.Background())
+
+		entries, err = history.List()
+		r.NoError(err)
+		r.Len(entries, 1)
+		r.False(entries[0].ReportPending)
+
+		// Nothing is pending now, so nothing is sent.
+		runner.retryPendingReports(context.Background())
+	})
+
+	t.Run("gives up on reports HCP rejects", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `group "test" {}`)
+
+		history, err := openHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+		r.NoError(err)
+		runner.history = history
+
+		r.NoError(history.Append(&historyEntry{Group: "test", Action: "a", ActionRunID: "run-1", ReportPending: true}))
+		r.NoError(history.Append(&historyEntry{Group: "test", Action: "a", ActionRunID: "run-2", ReportPending: true}))
+
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-1"
+			}), mock.Anything).
+			Return(nil, waypoint_service.NewWaypointServiceEndingActionDefault(404)).
+			Once()
+
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-2"
+			}), mock.Anything).
+			Return(nil, waypoint_service.NewWaypointServiceEndingActionDefault(503)).
+			Once()
+
+		runner.retryPendingReports(context.Background())
+
+		entries, err := history.List()
+		r.NoError(err)
+		r.Len(entries, 2)
+		r.False(entries[0].ReportPending)
+		r.True(entries[1].ReportPending)
+	})
+
+	t.Run("skips unreadable lines", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		r.NoError(os.WriteFile(path, []byte(`{"group":"test","action":"a"}
+{"group":"te`), 0o600))
+
+		history, err := openHistory(path)
+		r.NoError(err)
+
+		entries, err := history.List()
+		r.NoError(err)
+		r.Len(entries, 1)
+	})
+
+	t.Run("trims the history as entries are appended", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		r.NoError(os.WriteFile(path, []byte(`{"group":"test","action":"old"}
+`), 0o600))
+
+		history, err := openHistory(path)
+		r.NoError(err)
+
+		history.maxEntries = 3
+		history.trimSlack = 2
+
+		// The entry already in the file counts towards the limit.
+		for i := range 4 {
+			r.NoError(history.Append(&historyEntry{Group: "test", Action: fmt.Sprint(i)}))
+		}
+
+		entries, err := history.List()
+		r.NoError(err)
+		r.Len(entries, 5)
+
+		r.NoError(history.Append(&historyEntry{Group: "test", Action: "4"}))
+
+		entries, err = history.List()
+		r.NoError(err)
+		r.Len(entries, 3)
+		r.Equal("2", entries[0].Action)
+		r.Equal("4", entries[2].Action)
+	})
+
+	t.Run("lists and filters entries", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		history, err := openHistory(path)
+		r.NoError(err)
+
+		start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		r.NoError(history.Append(&historyEntry{Group: "prod", Action: "deploy", ActionRunID: "run-1", StartedAt: start, EndedAt: start.Add(time.Second)}))
+		r.NoError(history.Append(&historyEntry{Group: "prod", Action: "deploy", ActionRunID: "run-2", Code: 1, StartedAt: start, EndedAt: start.Add(time.Second)}))
+		r.NoError(history.Append(&historyEntry{Group: "dev", Action: "deploy", ActionRunID: "run-3", Code: 1, StartedAt: start, EndedAt: start.Add(time.Second)}))
+		r.NoError(history.Append(&historyEntry{Group: "prod", Action: "rollback", ActionRunID: "run-4", StartedAt: start, EndedAt: start.Add(time.Second)}))
+
+		list := func(o *HistoryOpts) []string {
+			io := iostreams.Test()
+
+			o.WaypointOpts = opts.WaypointOpts{
+				IO:     io,
+				Output: format.New(io),
+			}
+			o.HistoryPath = path
+			o.Output.SetFormat(format.JSON)
+
+			r.NoError(agentHistory(hclog.NewNullLogger(), o))
+
+			var entries []*historyEntry
+			r.NoError(json.Unmarshal(io.Output.Bytes(), &entries))
+
+			var ids []string
+			for _, e := range entries {
+				ids = append(ids, e.ActionRunID)
+			}
+
+			return ids
+		}
+
+		r.Equal([]string{"run-4", "run-3", "run-2", "run-1"}, list(&HistoryOpts{}))
+		r.Equal([]string{"run-4", "run-3"}, list(&HistoryOpts{Limit: 2}))
+		r.Equal([]string{"run-4", "run-2", "run-1"}, list(&HistoryOpts{Group: "prod"}))
+		r.Equal([]string{"run-2"}, list(&HistoryOpts{Group: "prod", Action: "deploy", Failed: true}))
+		r.Equal([]string{"run-3"}, list(&HistoryOpts{ActionRunID: "run-3"}))
+		r.Empty(list(&HistoryOpts{Pending: true}))
+	})
+}
@@ -0,0 +1,564 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/flagvalue"
+	"github.com/hashicorp/hcp/internal/pkg/heredoc"
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+	"github.com/hashicorp/hcp/version"
+)
+
+type InstallServiceOpts struct {
+	opts.WaypointOpts
+
+	Name          string
+	ConfigPath    string
+	CredFile      string
+	User          string
+	Restart       string
+	ShutdownGrace time.Duration
+	UnitDir       string
+	OutputDir     string
+	Kubernetes    bool
+	Dockerfile    bool
+	Image         string
+	Print         bool
+	Force         bool
+
+	// executable is the hcp binary the systemd unit runs. It defaults to the
+	// running binary.
+	executable string
+}
+
+const (
+	defaultServiceName = "hcp-waypoint-agent"
+	defaultUnitDir     = "/etc/systemd/system"
+
+	// serviceStopMargin is added to the agent's shutdown grace to get the
+	// time a service manager waits before killing it, so the agent has time
+	// to report the operations it cancelled.
+	serviceStopMargin = 10 * time.Second
+
+	// Where the config and credential file are found in containers.
+	containerConfigDir = "/etc/waypoint-agent"
+	containerCredFile  = "/etc/hcp/cred_file.json"
+)
+
+var (
+	serviceRestartPolicies = []string{"always", "on-failure", "no"}
+	serviceNameRegexp      = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+func NewCmdInstallService(ctx *cmd.Context) *cmd.Command {
+	opts := &InstallServiceOpts{
+		WaypointOpts: opts.New(ctx),
+	}
+
+	cmd := &cmd.Command{
+		Name:           "install-service",
+		ShortHelp:      "Generate service definitions to run the agent.",
+		NoAuthRequired: true,
+		LongHelp: heredoc.New(ctx.IO).Must(`
+		The {{ template "mdCodeOrBold" "hcp waypoint agent install-service" }} command generates a systemd unit
+that runs {{ template "mdCodeOrBold" "hcp waypoint agent run" }} with the given configuration file, and writes it to the
+systemd unit directory. With {{ template "mdCodeOrBold" "--kubernetes" }} and {{ template "mdCodeOrBold" "--dockerfile" }}, a Kubernetes Deployment
+manifest and a Dockerfile are also written to the output directory.
+
+The agent authenticates with the credential file given by {{ template "mdCodeOrBold" "--cred-file" }}, such as one
+created for a service principal with {{ template "mdCodeOrBold" "hcp iam service-principals keys create --output-cred-file" }}.
+The organization and project of the active profile are passed to the agent.
+
+On shutdown the agent waits for running operations to finish for its shutdown grace period.
+The generated files give it that long, plus a few seconds to report the operations it
+cancelled, before it is killed.
+
+Use {{ template "mdCodeOrBold" "--print" }} to write the files to stdout for review instead. Existing files are only
+replaced with {{ template "mdCodeOrBold" "--force" }}.
+		`),
+		Examples: []cmd.Example{
+			{
+				Preamble: "Review the systemd unit for an agent:",
+				Command:  "$ hcp waypoint agent install-service -c /etc/waypoint/agent.hcl --cred-file /etc/waypoint/cred_file.json --print",
+			},
+			{
+				Preamble: "Write a Kubernetes manifest and a Dockerfile to the current directory:",
+				Command:  "$ hcp waypoint agent install-service -c agent.hcl --cred-file cred_file.json --kubernetes --dockerfile --output-dir .",
+			},
+		},
+		Flags: cmd.Flags{
+			Local: []*cmd.Flag{
+				{
+					Name:         "config",
+					Shorthand:    "c",
+					DisplayValue: "PATH",
+					Description:  "Path to configuration file for agent.",
+					Value:        flagvalue.Simple("agent.hcl", &opts.ConfigPath),
+				},
+				{
+					Name:         "cred-file",
+					DisplayValue: "PATH",
+					Description:  "Credential file the agent authenticates with.",
+					Value:        flagvalue.Simple("", &opts.CredFile),
+				},
+				{
+					Name:         "name",
+					DisplayValue: "NAME",
+					Description:  "Name of the service.",
+					Value:        flagvalue.Simple(defaultServiceName, &opts.Name),
+				},
+				{
+					Name:         "user",
+					DisplayValue: "USER",
+					Description:  "User the systemd service runs the agent as.",
+					Value:        flagvalue.Simple("", &opts.User),
+				},
+				{
+					Name:         "restart",
+					DisplayValue: "POLICY",
+					Description:  fmt.Sprintf("When to restart the agent. One of %q.", serviceRestartPolicies),
+					Value:        flagvalue.Enum(serviceRestartPolicies, "on-failure", &opts.Restart),
+				},
+				{
+					Name:         "shutdown-grace",
+					DisplayValue: "DURATION",
+					Description:  "How long the agent waits for running operations to finish when stopped. Defaults to the shutdown_grace set in the configuration file, or 20s.",
+					Value:        flagvalue.Duration(0, &opts.ShutdownGrace),
+				},
+				{
+					Name:         "unit-dir",
+					DisplayValue: "DIR",
+					Description:  "Directory to write the systemd unit to.",
+					Value:        flagvalue.Simple(defaultUnitDir, &opts.UnitDir),
+				},
+				{
+					Name:         "output-dir",
+					DisplayValue: "DIR",
+					Description:  "Directory to write the Kubernetes manifest and Dockerfile to.",
+					Value:        flagvalue.Simple(".", &opts.OutputDir),
+				},
+				{
+					Name:          "kubernetes",
+					Description:   "Also generate a Kubernetes Deployment manifest.",
+					Value:         flagvalue.Simple(false, &opts.Kubernetes),
+					IsBooleanFlag: true,
+				},
+				{
+					Name:          "dockerfile",
+					Description:   "Also generate a Dockerfile.",
+					Value:         flagvalue.Simple(false, &opts.Dockerfile),
+					IsBooleanFlag: true,
+				},
+				{
+					Name:         "image",
+					DisplayValue: "IMAGE",
+					Description:  "Image with the hcp CLI that the Kubernetes manifest runs and the Dockerfile builds on. Defaults to the hashicorp/hcp image of this version.",
+					Value:        flagvalue.Simple("", &opts.Image),
+				},
+				{
+					Name:          "print",
+					Description:   "Write the generated files to stdout instead of installing them.",
+					Value:         flagvalue.Simple(false, &opts.Print),
+					IsBooleanFlag: true,
+				},
+				{
+					Name:          "force",
+					Description:   "Replace files that already exist.",
+					Value:         flagvalue.Simple(false, &opts.Force),
+					IsBooleanFlag: true,
+				},
+			},
+		},
+		RunF: func(c *cmd.Command, args []string) error {
+			return agentInstallService(c.Logger(), opts)
+		},
+	}
+
+	return cmd
+}
+
+// serviceSpec is what the service templates are rendered with.
+type serviceSpec struct {
+	Name       string
+	Executable string
+	ConfigPath string
+	CredFile   string
+	User       string
+	Restart    string
+	Image      string
+
+	OrganizationID string
+	ProjectID      string
+
+	// ShutdownGrace is passed to the agent if set on the command line.
+	// Otherwise the agent uses the one in its config.
+	ShutdownGrace time.Duration
+
+	// StopTimeout is how long a service manager waits for the agent to stop
+	// before killing it.
+	StopTimeout time.Duration
+}
+
+// Args returns the arguments the agent is run with, given the path of its
+// config.
+func (s *serviceSpec) Args(configPath string) []string {
+	args := []string{"waypoint", "agent", "run", "--config=" + configPath}
+	if s.ShutdownGrace > 0 {
+		args = append(args, "--shutdown-grace="+s.ShutdownGrace.String())
+	}
+
+	return args
+}
+
+// ConfigFileName is the name of the agent's config file.
+func (s *serviceSpec) ConfigFileName() string {
+	return filepath.Base(s.ConfigPath)
+}
+
+// ContainerConfigPath is the path of the agent's config in containers.
+func (s *serviceSpec) ContainerConfigPath() string {
+	return containerConfigDir + "/" + s.ConfigFileName()
+}
+
+// ContainerCommand is the command that runs the agent in containers.
+func (s *serviceSpec) ContainerCommand() []string {
+	return append([]string{"hcp"}, s.Args(s.ContainerConfigPath())...)
+}
+
+// serviceFile is a generated file.
+type serviceFile struct {
+	path    string
+	content []byte
+}
+
+func agentInstallService(log hclog.Logger, opts *InstallServiceOpts) error {
+	if !serviceNameRegexp.MatchString(opts.Name) {
+		return fmt.Errorf("invalid service name %q", opts.Name)
+	}
+
+	if !slices.Contains(serviceRestartPolicies, opts.Restart) {
+		return fmt.Errorf("invalid restart policy %q, expected one of %q", opts.Restart, serviceRestartPolicies)
+	}
+
+	spec, err := newServiceSpec(opts)
+	if err != nil {
+		return err
+	}
+
+	files := []*serviceFile{{
+		path: filepath.Join(opts.UnitDir, opts.Name+".service"),
+	}}
+	templates := []*template.Template{systemdTemplate}
+
+	if opts.Kubernetes {
+		files = append(files, &serviceFile{path: filepath.Join(opts.OutputDir, opts.Name+".yaml")})
+		templates = append(templates, kubernetesTemplate)
+	}
+
+	if opts.Dockerfile {
+		files = append(files, &serviceFile{path: filepath.Join(opts.OutputDir, "Dockerfile")})
+		templates = append(templates, dockerfileTemplate)
+	}
+
+	for i, f := range files {
+		var buf bytes.Buffer
+		if err := templates[i].Execute(&buf, spec); err != nil {
+			return fmt.Errorf("unable to generate %s: %w", f.path, err)
+		}
+
+		f.content = buf.Bytes()
+	}
+
+	if opts.Print {
+		for i, f := range files {
+			if i > 0 {
+				_, _ = fmt.Fprintln(opts.IO.Out())
+			}
+
+			_, _ = fmt.Fprintf(opts.IO.Out(), "# %s\n", f.path)
+			_, _ = opts.IO.Out().Write(f.content)
+		}
+
+		return nil
+	}
+
+	if !opts.Force {
+		for _, f := range files {
+			if _, err := os.Stat(f.path); err == nil {
+				return fmt.Errorf("%s already exists, use --force to replace it", f.path)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	for _, f := range files {
+		if err := os.WriteFile(f.path, f.content, 0o644); err != nil {
+			return fmt.Errorf("unable to write %s: %w", f.path, err)
+		}
+
+		log.Debug("wrote service file", "path", f.path)
+		_, _ = fmt.Fprintf(opts.IO.Err(), "Wrote %s\n", f.path)
+	}
+
+	_, _ = fmt.Fprintf(opts.IO.Err(), "\nStart the agent with:\n  systemctl daemon-reload\n  systemctl enable --now %s\n", opts.Name)
+
+	return nil
+}
+
+// newServiceSpec checks the config and credential file of opts and returns
+// the spec to render the service files with.
+func newServiceSpec(opts *InstallServiceOpts) (*serviceSpec, error) {
+	configPath, err := absPath(opts.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Catch mistakes in the config now, rather than when the service starts.
+	cfg, err := agent.ParseConfigFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &serviceSpec{
+		Name:          opts.Name,
+		Executable:    opts.executable,
+		ConfigPath:    configPath,
+		User:          opts.User,
+		Restart:       opts.Restart,
+		Image:         opts.Image,
+		ShutdownGrace: opts.ShutdownGrace,
+	}
+
+	if opts.Profile != nil {
+		spec.OrganizationID = opts.Profile.OrganizationID
+		spec.ProjectID = opts.Profile.ProjectID
+	}
+
+	if opts.CredFile != "" {
+		spec.CredFile, err = absPath(opts.CredFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(spec.CredFile); err != nil {
+			return nil, fmt.Errorf("unable to read credential file: %w", err)
+		}
+	}
+
+	if spec.Executable == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the hcp binary: %w", err)
+		}
+
+		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+			exe = resolved
+		}
+
+		spec.Executable = exe
+	}
+
+	if spec.Image == "" {
+		spec.Image = "hashicorp/hcp:" + version.FullVersion()
+	}
+
+	grace := opts.ShutdownGrace
+	if grace <= 0 {
+		grace = cfg.ShutdownGrace()
+	}
+	if grace <= 0 {
+		grace = defaultShutdownGrace
+	}
+
+	spec.StopTimeout = grace + serviceStopMargin
+
+	return spec, nil
+}
+
+// absPath expands ~ in path and makes it absolute.
+func absPath(path string) (string, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return "", fmt.Errorf("error expanding path %q: %w", path, err)
+	}
+
+	return filepath.Abs(path)
+}
+
+var serviceFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"seconds": func(d time.Duration) int64 {
+		return int64(d.Round(time.Second) / time.Second)
+	},
+	"systemdQuote": systemdQuote,
+	"systemdArgs": func(args []string) string {
+		quoted := make([]string, len(args))
+		for i, a := range args {
+			quoted[i] = systemdQuote(a)
+		}
+
+		return strings.Join(quoted, " ")
+	},
+}
+
+// systemdQuote quotes s for a systemd unit file, where % starts a specifier.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;$") {
+		return s
+	}
+
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$").Replace(s)
+
+	return `"` + s + `"`
+}
+
+// The agent is sent SIGTERM alone, with KillMode=mixed, so that it decides
+// when the commands of running operations are stopped.
+var systemdTemplate = template.Must(template.New("systemd").Funcs(serviceFuncs).Parse(`# Generated by "hcp waypoint agent install-service".
+[Unit]
+Description=HCP Waypoint agent
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=simple
+{{- if .User }}
+User={{ .User }}
+{{- end }}
+{{- if .CredFile }}
+Environment={{ systemdQuote (printf "HCP_CRED_FILE=%s" .CredFile) }}
+{{- end }}
+{{- if .OrganizationID }}
+Environment=HCP_ORGANIZATION_ID={{ .OrganizationID }}
+{{- end }}
+{{- if .ProjectID }}
+Environment=HCP_PROJECT_ID={{ .ProjectID }}
+{{- end }}
+ExecStart={{ systemdQuote .Executable }} {{ systemdArgs (.Args .ConfigPath) }}
+ExecReload=/bin/kill -HUP $MAINPID
+KillMode=mixed
+KillSignal=SIGTERM
+TimeoutStopSec={{ seconds .StopTimeout }}
+Restart={{ .Restart }}
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var kubernetesTemplate = template.Must(template.New("kubernetes").Funcs(serviceFuncs).Parse(`# Generated by "hcp waypoint agent install-service".
+#
+# Create the config map and credential secret the agent reads with:
+#   kubectl create configmap {{ .Name }}-config --from-file={{ json .ConfigPath }}
+{{- if .CredFile }}
+#   kubectl create secret generic {{ .Name }}-credentials --from-file=cred_file.json={{ json .CredFile }}
+{{- end }}
+{{- if ne .Restart "always" }}
+#
+# Pods of a Deployment are always restarted, whatever the restart policy.
+{{- end }}
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Name }}
+  labels:
+    app.kubernetes.io/name: {{ .Name }}
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: {{ .Name }}
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: {{ .Name }}
+    spec:
+      terminationGracePeriodSeconds: {{ seconds .StopTimeout }}
+      containers:
+        - name: agent
+          image: {{ json .Image }}
+          command: {{ json .ContainerCommand }}
+{{- if or .CredFile .OrganizationID .ProjectID }}
+          env:
+{{- end }}
+{{- if .CredFile }}
+            - name: HCP_CRED_FILE
+              value: {{ json "` + containerCredFile + `" }}
+{{- end }}
+{{- if .OrganizationID }}
+            - name: HCP_ORGANIZATION_ID
+              value: {{ json .OrganizationID }}
+{{- end }}
+{{- if .ProjectID }}
+            - name: HCP_PROJECT_ID
+              value: {{ json .ProjectID }}
+{{- end }}
+          volumeMounts:
+            - name: config
+              mountPath: ` + containerConfigDir + `
+              readOnly: true
+{{- if .CredFile }}
+            - name: credentials
+              mountPath: /etc/hcp
+              readOnly: true
+{{- end }}
+      volumes:
+        - name: config
+          configMap:
+            name: {{ .Name }}-config
+{{- if .CredFile }}
+        - name: credentials
+          secret:
+            secretName: {{ .Name }}-credentials
+{{- end }}
+`))
+
+var dockerfileTemplate = template.Must(template.New("dockerfile").Funcs(serviceFuncs).Parse(`# Generated by "hcp waypoint agent install-service".
+#
+# Copy {{ json .ConfigPath }} to the build context, then build and run the agent with:
+#   docker build -t {{ .Name }} .
+#   docker run -d --name {{ .Name }} --restart={{ .Restart }} --stop-timeout={{ seconds .StopTimeout }}
+{{- if .CredFile }} \
+#     --volume {{ json (printf "%s:` + containerCredFile + `:ro" .CredFile) }}
+{{- end }} {{ .Name }}
+FROM {{ .Image }}
+
+COPY [{{ json .ConfigFileName }}, {{ json .ContainerConfigPath }}]
+{{- if .CredFile }}
+ENV HCP_CRED_FILE=` + containerCredFile + `
+{{- end }}
+{{- if .OrganizationID }}
+ENV HCP_ORGANIZATION_ID={{ .OrganizationID }}
+{{- end }}
+{{- if .ProjectID }}
+ENV HCP_PROJECT_ID={{ .ProjectID }}
+{{- end }}
+
+STOPSIGNAL SIGTERM
+ENTRYPOINT {{ json .ContainerCommand }}
+`))
@@ -0,0 +1,173 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	"github.com/hashicorp/hcp/internal/pkg/iostreams"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+func TestInstallService(t *testing.T) {
+	t.Parallel()
+
+	newOpts := func(t *testing.T, io *iostreams.Testing) *InstallServiceOpts {
+		t.Helper()
+
+		dir := t.TempDir()
+
+		configPath := filepath.Join(dir, "agent.hcl")
+		require.NoError(t, os.WriteFile(configPath, []byte("shutdown_grace = \"45s\"\ngroup \"prod\" {}\n"), 0o600))
+
+		credFile := filepath.Join(dir, "cred file.json")
+		require.NoError(t, os.WriteFile(credFile, []byte("{}"), 0o600))
+
+		return &InstallServiceOpts{
+			WaypointOpts: opts.WaypointOpts{
+				Ctx:     context.Background(),
+				IO:      io,
+				Profile: &profile.Profile{OrganizationID: "org", ProjectID: "proj"},
+			},
+			Name:       defaultServiceName,
+			ConfigPath: configPath,
+			CredFile:   credFile,
+			Restart:    "on-failure",
+			UnitDir:    filepath.Join(dir, "units"),
+			OutputDir:  dir,
+			Image:      "hashicorp/hcp:test",
+			executable: "/usr/bin/hcp",
+		}
+	}
+
+	t.Run("prints a systemd unit", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		io := iostreams.Test()
+		o := newOpts(t, io)
+		o.Print = true
+		o.User = "waypoint"
+
+		r.NoError(agentInstallService(hclog.NewNullLogger(), o))
+
+		out := io.Output.String()
+		r.Contains(out, "User=waypoint\n")
+		r.Contains(out, `Environment="HCP_CRED_FILE=`+o.CredFile+`"`)
+		r.Contains(out, "Environment=HCP_ORGANIZATION_ID=org\n")
+		r.Contains(out, "Environment=HCP_PROJECT_ID=proj\n")
+		r.Contains(out, "ExecStart=/usr/bin/hcp waypoint agent run --config="+o.ConfigPath+"\n")
+		r.Contains(out, "Restart=on-failure\n")
+
+		// The shutdown grace comes from the config.
+		r.Contains(out, "TimeoutStopSec=55\n")
+
+		r.NotContains(out, "kind: Deployment")
+		r.NotContains(out, "FROM ")
+
+		// Nothing is installed.
+		r.NoDirExists(o.UnitDir)
+	})
+
+	t.Run("prints a Kubernetes manifest and a Dockerfile", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		io := iostreams.Test()
+		o := newOpts(t, io)
+		o.Print = true
+		o.Kubernetes = true
+		o.Dockerfile = true
+		o.Restart = "always"
+		o.ShutdownGrace = 30 * time.Second
+
+		r.NoError(agentInstallService(hclog.NewNullLogger(), o))
+
+		out := io.Output.String()
+		r.Contains(out, "ExecStart=/usr/bin/hcp waypoint agent run --config="+o.ConfigPath+" --shutdown-grace=30s\n")
+		r.Contains(out, "TimeoutStopSec=40\n")
+
+		r.Contains(out, "# "+filepath.Join(o.OutputDir, "hcp-waypoint-agent.yaml")+"\n")
+		r.Contains(out, "terminationGracePeriodSeconds: 40\n")
+		r.Contains(out, `image: "hashicorp/hcp:test"`)
+		r.Contains(out, `command: ["hcp","waypoint","agent","run","--config=/etc/waypoint-agent/agent.hcl","--shutdown-grace=30s"]`)
+		r.Contains(out, "secretName: hcp-waypoint-agent-credentials\n")
+		r.NotContains(out, "Pods of a Deployment are always restarted")
+
+		r.Contains(out, "# "+filepath.Join(o.OutputDir, "Dockerfile")+"\n")
+		r.Contains(out, "--restart=always --stop-timeout=40")
+		r.Contains(out, "FROM hashicorp/hcp:test\n")
+		r.Contains(out, `COPY ["agent.hcl", "/etc/waypoint-agent/agent.hcl"]`)
+		r.Contains(out, "ENV HCP_CRED_FILE=/etc/hcp/cred_file.json\n")
+		r.Contains(out, "STOPSIGNAL SIGTERM\n")
+	})
+
+	t.Run("installs the files without replacing existing ones", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		io := iostreams.Test()
+		o := newOpts(t, io)
+		o.Kubernetes = true
+		r.NoError(os.Mkdir(o.UnitDir, 0o755))
+
+		r.NoError(agentInstallService(hclog.NewNullLogger(), o))
+
+		unit := filepath.Join(o.UnitDir, "hcp-waypoint-agent.service")
+		r.FileExists(unit)
+		r.FileExists(filepath.Join(o.OutputDir, "hcp-waypoint-agent.yaml"))
+		r.Contains(io.Error.String(), "systemctl enable --now hcp-waypoint-agent")
+
+		r.ErrorContains(agentInstallService(hclog.NewNullLogger(), o), "already exists")
+
+		o.Force = true
+		r.NoError(agentInstallService(hclog.NewNullLogger(), o))
+	})
+
+	t.Run("checks its inputs", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		o := newOpts(t, iostreams.Test())
+		o.Name = "../agent"
+		r.ErrorContains(agentInstallService(hclog.NewNullLogger(), o), "invalid service name")
+
+		o = newOpts(t, iostreams.Test())
+		o.CredFile = filepath.Join(t.TempDir(), "missing.json")
+		r.ErrorContains(agentInstallService(hclog.NewNullLogger(), o), "unable to read credential file")
+
+		o = newOpts(t, iostreams.Test())
+		r.NoError(os.WriteFile(o.ConfigPath, []byte("group {"), 0o600))
+		r.Error(agentInstallService(hclog.NewNullLogger(), o))
+	})
+}
+
+func TestSystemdQuote(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"/usr/bin/hcp":     "/usr/bin/hcp",
+		"/opt/my hcp/hcp":  `"/opt/my hcp/hcp"`,
+		"100%":             "100%%",
+		`say "hi" $USER`:   `"say \"hi\" $$USER"`,
+		"":                 `""`,
+		`C:\path with dir`: `"C:\\path with dir"`,
+	}
+
+	for in, want := range cases {
+		require.Equal(t, want, systemdQuote(in), in)
+	}
+}
@@ -0,0 +1,488 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+)
+
+const (
+	// journalMaxFinished is how many finished action runs the journal
+	// remembers. Older ones are forgotten as runs finish.
+	journalMaxFinished = 10000
+
+	// journalCompactSlack is how many lines past journalMaxFinished the
+	// journal grows before it is compacted while the agent runs, so it isn't
+	// rewritten as every run finishes.
+	journalCompactSlack = journalMaxFinished / 10
+
+	// statusCodeRestarted is reported for action runs the agent started but
+	// didn't finish before it stopped. It matches the exit code of a process
+	// killed by SIGKILL, as the agent most likely was.
+	statusCodeRestarted = 137
+
+	// statusRestarted is the final status of those action runs.
+	statusRestarted = "agent restarted: operation interrupted"
+
+	// statusDuplicate is reported, with status code 1, for action runs HCP
+	// hands out again after the agent has run them, if their ending is no
+	// longer in the history.
+	statusDuplicate = "action run already run by this agent"
+
+	journalClaimed  = "claimed"
+	journalFinished = "finished"
+)
+
+// journalEntry is a line in the journal.
+type journalEntry struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+
+	// Project is the name of the project block the action run came from,
+	// or empty for the project of the agent's profile.
+	Project string `json:"project,omitempty"`
+
+	Group       string `json:"group,omitempty"`
+	Action      string `json:"action,omitempty"`
+	ActionRunID string `json:"action_run_id"`
+}
+
+type journalKey struct {
+	project     string
+	actionRunID string
+}
+
+func (e *journalEntry) key() journalKey {
+	return journalKey{project: e.Project, actionRunID: e.ActionRunID}
+}
+
+// runJournal records the action runs the agent has claimed and finished, so
+// that an action run is never run twice, even across restarts, and runs cut
+// short by the agent stopping can be ended when it starts again. It is a file
+// of JSON lines, compacted when the agent starts and once it has grown by
+// compactSlack lines.
+type runJournal struct {
+	path string
+
+	// maxFinished and compactSlack are journalMaxFinished and
+	// journalCompactSlack, unless changed by tests.
+	maxFinished  int
+	compactSlack int
+
+	mu       sync.Mutex
+	claimed  map[journalKey]*journalEntry
+	finished map[journalKey]bool
+
+	// finishedOrder are the finished runs in finished, oldest first.
+	finishedOrder []*journalEntry
+
+	// lines is how many lines the file holds.
+	lines int
+
+	// orphans are the runs that were claimed but not finished when the
+	// journal was opened.
+	orphans []*journalEntry
+	orphan  map[journalKey]bool
+}
+
+// claimResult is the outcome of claiming an action run.
+type claimResult int
+
+const (
+	// claimed means the action run was claimed and can run.
+	claimed claimResult = iota
+
+	// claimRunning means this agent is already running the action run.
+	claimRunning
+
+	// claimOrphaned means the action run was claimed before the agent last
+	// stopped and hasn't been ended since.
+	claimOrphaned
+
+	// claimFinished means this agent has already run the action run.
+	claimFinished
+)
+
+// journalPath returns the path of the journal kept alongside the history at
+// historyPath.
+func journalPath(historyPath string) string {
+	return strings.TrimSuffix(historyPath, filepath.Ext(historyPath)) + "-journal.jsonl"
+}
+
+// openJournal reads the journal at path, creating its directory if needed,
+// and compacts it.
+func openJournal(path string) (*runJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create journal directory: %w", err)
+	}
+
+	j := &runJournal{
+		path:         path,
+		maxFinished:  journalMaxFinished,
+		compactSlack: journalCompactSlack,
+		claimed:      make(map[journalKey]*journalEntry),
+		finished:     make(map[journalKey]bool),
+		orphan:       make(map[journalKey]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unable to read journal: %w", err)
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+
+	for sc.Scan() {
+		var e journalEntry
+
+		// Skip lines that can't be read, such as one cut short by a crash.
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.ActionRunID == "" {
+			continue
+		}
+
+		switch e.Event {
+		case journalClaimed:
+			if !j.finished[e.key()] {
+				j.claimed[e.key()] = &e
+			}
+		case journalFinished:
+			delete(j.claimed, e.key())
+			j.addFinished(&e)
+		}
+	}
+
+	for k, e := range j.claimed {
+		j.orphans = append(j.orphans, e)
+		j.orphan[k] = true
+	}
+
+	slices.SortFunc(j.orphans, compareJournalTime)
+
+	if err := j.compact(); err != nil {
+		return nil, fmt.Errorf("unable to compact journal: %w", err)
+	}
+
+	return j, nil
+}
+
+func compareJournalTime(a, b *journalEntry) int {
+	return a.Time.Compare(b.Time)
+}
+
+// addFinished marks the run of e as finished, forgetting the oldest finished
+// run once there are more than maxFinished.
+func (j *runJournal) addFinished(e *journalEntry) {
+	if j.finished[e.key()] {
+		return
+	}
+
+	j.finished[e.key()] = true
+	j.finishedOrder = append(j.finishedOrder, e)
+
+	if len(j.finishedOrder) > j.maxFinished {
+		delete(j.finished, j.finishedOrder[0].key())
+		j.finishedOrder = slices.Delete(j.finishedOrder, 0, 1)
+	}
+}
+
+// compact rewrites the journal with only the finished runs it remembers and
+// the runs that are claimed but not finished.
+func (j *runJournal) compact() error {
+	entries := slices.Clone(j.finishedOrder)
+	entries = append(entries, slices.SortedFunc(maps.Values(j.claimed), compareJournalTime)...)
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	// Write to a temporary file first so a crash can not lose the journal.
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	j.lines = len(entries)
+
+	return nil
+}
+
+// append adds e to the journal, syncing it to disk so it survives a crash.
+func (j *runJournal) append(e *journalEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if err := errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+
+	j.lines++
+
+	return nil
+}
+
+// Claim records that the agent is about to run an action run. Unless it
+// returns claimed, the run must not be run.
+func (j *runJournal) Claim(e *journalEntry) (claimResult, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch k := e.key(); {
+	case j.finished[k]:
+		return claimFinished, nil
+	case j.orphan[k]:
+		return claimOrphaned, nil
+	case j.claimed[k] != nil:
+		return claimRunning, nil
+	}
+
+	e.Event = journalClaimed
+	if err := j.append(e); err != nil {
+		return 0, err
+	}
+
+	j.claimed[e.key()] = e
+
+	return claimed, nil
+}
+
+// Finish records that an action run has ended.
+func (j *runJournal) Finish(project, actionRunID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e := &journalEntry{
+		Event:       journalFinished,
+		Project:     project,
+		ActionRunID: actionRunID,
+	}
+
+	if err := j.append(e); err != nil {
+		return err
+	}
+
+	delete(j.claimed, e.key())
+	delete(j.orphan, e.key())
+	j.addFinished(e)
+
+	if j.lines > j.maxFinished+j.compactSlack {
+		if err := j.compact(); err != nil {
+			return fmt.Errorf("unable to compact journal: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Orphans returns the action runs that were claimed but not finished before
+// the journal was opened, oldest first.
+func (j *runJournal) Orphans() []*journalEntry {
+	return j.orphans
+}
+
+// claim records in the journal that the action run of ao, retrieved from
+// target t, is about to run, and reports whether it can. An action run the
+// agent has already run is ended again in HCP so it isn't handed out again.
+// If the journal can't be written, the run is skipped, since it couldn't be
+// kept from running twice.
+func (r *agentRunner) claim(ctx context.Context, t *agentTarget, ao *models.HashicorpCloudWaypointV20241122AgentOperation) bool {
+	if r.journal == nil || ao.ActionRunID == "" {
+		return true
+	}
+
+	log := targetLogger(r.log, t).With("group", ao.Group, "operation", ao.ID, "action-run-id", ao.ActionRunID)
+
+	res, err := r.journal.Claim(&journalEntry{
+		Project:     t.name,
+		Group:       ao.Group,
+		Action:      ao.ID,
+		ActionRunID: ao.ActionRunID,
+	})
+	if err != nil {
+		log.Error("unable to record action run in journal, not running it", "error", err)
+		return false
+	}
+
+	switch res {
+	case claimed:
+		return true
+	case claimRunning:
+		log.Warn("action run is already running, ignoring it")
+	case claimOrphaned:
+		log.Warn("action run was interrupted by an agent restart, not running it again")
+
+		i := slices.IndexFunc(r.journal.Orphans(), func(o *journalEntry) bool {
+			return o.Project == t.name && o.ActionRunID == ao.ActionRunID
+		})
+		if i >= 0 {
+			r.endOrphan(ctx, log, t, r.journal.Orphans()[i])
+		}
+	case claimFinished:
+		log.Warn("action run was already run by this agent, not running it again")
+		r.resendEnding(ctx, log, t, ao)
+	}
+
+	return false
+}
+
+// resendEnding reports the ending of an action run the agent has already run
+// again, using the status recorded in the history if it is still there.
+func (r *agentRunner) resendEnding(ctx context.Context, log hclog.Logger, t *agentTarget, ao *models.HashicorpCloudWaypointV20241122AgentOperation) {
+	status, code := statusDuplicate, 1
+
+	if r.history != nil {
+		entries, err := r.history.List()
+		if err != nil {
+			log.Error("unable to read history", "error", err)
+		}
+
+		for _, e := range slices.Backward(entries) {
+			if e.Project == t.name && e.ActionRunID == ao.ActionRunID {
+				status, code = e.Status, e.Code
+				break
+			}
+		}
+	}
+
+	if err := reportEnding(ctx, t, ao.ActionRunID, status, code); err != nil {
+		log.Error("unable to send ending of action run", "error", err)
+	}
+}
+
+// finish records in the journal that an action run has ended.
+func (r *agentRunner) finish(log hclog.Logger, t *agentTarget, actionRunID string) {
+	if r.journal == nil || actionRunID == "" {
+		return
+	}
+
+	if err := r.journal.Finish(t.name, actionRunID); err != nil {
+		log.Error("unable to record action run in journal", "error", err)
+	}
+}
+
+// recoverOrphans ends the action runs the agent started but didn't finish
+// before it last stopped, so they aren't left running in HCP. Runs that
+// can't be ended are tried again on the next start.
+func (r *agentRunner) recoverOrphans(ctx context.Context) {
+	if r.journal == nil {
+		return
+	}
+
+	orphans := r.journal.Orphans()
+	if len(orphans) == 0 {
+		return
+	}
+
+	// A run may have ended just before the agent stopped, without being
+	// marked finished.
+	var recorded []*historyEntry
+	if r.history != nil {
+		var err error
+
+		recorded, err = r.history.List()
+		if err != nil {
+			r.log.Error("unable to read history", "error", err)
+		}
+	}
+
+	for _, o := range orphans {
+		log := r.log.With("group", o.Group, "operation", o.Action, "action-run-id", o.ActionRunID)
+		if o.Project != "" {
+			log = log.With("project", o.Project)
+		}
+
+		if slices.ContainsFunc(recorded, func(e *historyEntry) bool {
+			return e.Project == o.Project && e.ActionRunID == o.ActionRunID
+		}) {
+			if err := r.journal.Finish(o.Project, o.ActionRunID); err != nil {
+				log.Error("unable to record action run in journal", "error", err)
+			}
+
+			continue
+		}
+
+		t := r.target(o.Project)
+		if t == nil {
+			log.Warn("project of interrupted action run is no longer in the config, keeping it to end later")
+			continue
+		}
+
+		r.endOrphan(ctx, log, t, o)
+	}
+}
+
+// endOrphan ends action run o of target t, which was interrupted by the agent
+// stopping. If HCP can't be reached, it is tried again on the next start.
+func (r *agentRunner) endOrphan(ctx context.Context, log hclog.Logger, t *agentTarget, o *journalEntry) {
+	err := reportEnding(ctx, t, o.ActionRunID, statusRestarted, statusCodeRestarted)
+
+	var respErr runtime.ClientResponseStatus
+
+	switch {
+	case err == nil:
+		log.Warn("ended action run interrupted by agent restart")
+	case errors.As(err, &respErr) && respErr.IsClientError():
+		log.Warn("HCP rejected ending of interrupted action run, not retrying", "error", err)
+	default:
+		log.Error("unable to end interrupted action run, will retry on next start", "error", err)
+		return
+	}
+
+	r.finish(log, t, o.ActionRunID)
+
+	r.record(log, &historyEntry{
+		Project:     o.Project,
+		Group:       o.Group,
+		Action:      o.Action,
+		ActionRunID: o.ActionRunID,
+		StartedAt:   o.Time,
+		EndedAt:     time.Now(),
+		Status:      statusRestarted,
+		Code:        statusCodeRestarted,
+	})
+}
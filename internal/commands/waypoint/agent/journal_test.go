@@ -0,0 +1,326 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	mock_waypoint_service "github.com/hashicorp/hcp/internal/pkg/api/mocks/github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+)
+
+func TestRunJournal(t *testing.T) {
+	t.Parallel()
+
+	t.Run("claims each action run once", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+		j, err := openJournal(path)
+		r.NoError(err)
+		r.Empty(j.Orphans())
+
+		res, err := j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "run-1"})
+		r.NoError(err)
+		r.Equal(claimed, res)
+
+		res, err = j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "run-1"})
+		r.NoError(err)
+		r.Equal(claimRunning, res)
+
+		// Action runs of other projects are kept apart.
+		res, err = j.Claim(&journalEntry{Project: "other", Group: "test", Action: "a", ActionRunID: "run-1"})
+		r.NoError(err)
+		r.Equal(claimed, res)
+
+		res, err = j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "run-2"})
+		r.NoError(err)
+		r.Equal(claimed, res)
+
+		r.NoError(j.Finish("", "run-1"))
+
+		res, err = j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "run-1"})
+		r.NoError(err)
+		r.Equal(claimFinished, res)
+
+		// A crash can leave a partial line behind.
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		r.NoError(err)
+		_, err = f.WriteString(`{"event":"claimed","action_run_id":"run-`)
+		r.NoError(err)
+		r.NoError(f.Close())
+
+		j, err = openJournal(path)
+		r.NoError(err)
+
+		var orphans []string
+		for _, o := range j.Orphans() {
+			orphans = append(orphans, o.Project+"/"+o.ActionRunID)
+		}
+		r.Equal([]string{"other/run-1", "/run-2"}, orphans)
+
+		// Both finished and orphaned runs are still refused after a restart.
+		res, err = j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "run-1"})
+		r.NoError(err)
+		r.Equal(claimFinished, res)
+
+		res, err = j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "run-2"})
+		r.NoError(err)
+		r.Equal(claimOrphaned, res)
+
+		// The journal was compacted to one line per action run.
+		data, err := os.ReadFile(path)
+		r.NoError(err)
+		r.Equal(3, strings.Count(string(data), "\n"))
+	})
+
+	t.Run("forgets old runs and compacts as runs finish", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+		j, err := openJournal(path)
+		r.NoError(err)
+
+		j.maxFinished = 3
+		j.compactSlack = 4
+
+		lines := func() int {
+			data, err := os.ReadFile(path)
+			r.NoError(err)
+
+			return strings.Count(string(data), "\n")
+		}
+
+		// A run that is still going is kept through compactions.
+		res, err := j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "running"})
+		r.NoError(err)
+		r.Equal(claimed, res)
+
+		for i := range 3 {
+			id := fmt.Sprintf("run-%d", i)
+
+			res, err := j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: id})
+			r.NoError(err)
+			r.Equal(claimed, res)
+			r.NoError(j.Finish("", id))
+		}
+
+		r.Equal(7, lines())
+
+		// The fourth finished run pushes out the first and the file past
+		// its limit, so it is compacted.
+		res, err = j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "run-3"})
+		r.NoError(err)
+		r.Equal(claimed, res)
+		r.NoError(j.Finish("", "run-3"))
+
+		r.Equal(4, lines())
+
+		res, err = j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "run-0"})
+		r.NoError(err)
+		r.Equal(claimed, res)
+
+		res, err = j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "run-1"})
+		r.NoError(err)
+		r.Equal(claimFinished, res)
+
+		res, err = j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: "running"})
+		r.NoError(err)
+		r.Equal(claimRunning, res)
+	})
+
+	t.Run("is kept alongside the history", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, "/var/lib/agent/history-journal.jsonl", journalPath("/var/lib/agent/history.jsonl"))
+	})
+}
+
+func TestAgentRunnerJournal(t *testing.T) {
+	t.Parallel()
+
+	t.Run("does not run an action run twice", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `
+		group "test" {
+			action "launch" {
+				run {
+					command = ["true"]
+				}
+			}
+		}
+`)
+
+		dir := t.TempDir()
+
+		history, err := openHistory(filepath.Join(dir, "history.jsonl"))
+		r.NoError(err)
+		runner.history = history
+
+		j, err := openJournal(journalPath(history.path))
+		r.NoError(err)
+		runner.journal = j
+
+		ctx := context.Background()
+
+		ao := &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "run-1",
+		}
+
+		// HCP hands out the same action run twice.
+		api.EXPECT().
+			WaypointServiceRetrieveAgentOperation(mock.Anything, mock.Anything).
+			Return(retrieved(ao), nil).
+			Times(2)
+
+		api.EXPECT().
+			WaypointServiceStartingAction(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceStartingActionOK{
+				Payload: &models.HashicorpCloudWaypointV20241122StartingActionResponse{
+					ActionRunID: ao.ActionRunID,
+				},
+			}, nil).
+			Once()
+
+		// The second time, the ending recorded in the history is sent again.
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-1" && params.Body.StatusCode == 0
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceEndingActionOK{}, nil).
+			Times(2)
+
+		found, err := runner.poll(ctx, ctx, runner.targets[0], []string{"test"})
+		r.NoError(err)
+		r.True(found)
+
+		runner.pool.Wait()
+
+		// The duplicate isn't counted as work found, so the agent backs off.
+		found, err = runner.poll(ctx, ctx, runner.targets[0], []string{"test"})
+		r.NoError(err)
+		r.False(found)
+
+		runner.pool.Wait()
+		r.EqualValues(1, runner.metrics.operations[operationResultKey{operationKey{"test", "launch"}, 0}])
+
+		j, err = openJournal(j.path)
+		r.NoError(err)
+		r.Empty(j.Orphans())
+	})
+
+	t.Run("skips action runs it can't record", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		runner := testRunner(t, mock_waypoint_service.NewMockClientService(t), `group "test" {}`)
+
+		j, err := openJournal(filepath.Join(t.TempDir(), "journal.jsonl"))
+		r.NoError(err)
+		runner.journal = j
+
+		// The journal can't be appended to once it is a directory.
+		r.NoError(os.Remove(j.path))
+		r.NoError(os.Mkdir(j.path, 0o700))
+
+		r.False(runner.claim(context.Background(), runner.targets[0], &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "run-1",
+		}))
+	})
+
+	t.Run("ends action runs interrupted by a restart", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `group "test" {}`)
+
+		dir := t.TempDir()
+
+		history, err := openHistory(filepath.Join(dir, "history.jsonl"))
+		r.NoError(err)
+		runner.history = history
+
+		j, err := openJournal(journalPath(history.path))
+		r.NoError(err)
+
+		for _, id := range []string{"run-1", "run-2", "run-3", "run-4"} {
+			res, err := j.Claim(&journalEntry{Group: "test", Action: "a", ActionRunID: id})
+			r.NoError(err)
+			r.Equal(claimed, res)
+		}
+
+		// run-2 ended before the agent stopped, but wasn't marked finished.
+		r.NoError(history.Append(&historyEntry{Group: "test", Action: "a", ActionRunID: "run-2", Status: "done"}))
+
+		j, err = openJournal(j.path)
+		r.NoError(err)
+		r.Len(j.Orphans(), 4)
+		runner.journal = j
+
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-1" &&
+					params.Body.FinalStatus == statusRestarted &&
+					params.Body.StatusCode == statusCodeRestarted
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceEndingActionOK{}, nil).
+			Once()
+
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-3"
+			}), mock.Anything).
+			Return(nil, waypoint_service.NewWaypointServiceEndingActionDefault(404)).
+			Once()
+
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-4"
+			}), mock.Anything).
+			Return(nil, waypoint_service.NewWaypointServiceEndingActionDefault(503)).
+			Once()
+
+		runner.recoverOrphans(context.Background())
+
+		entries, err := history.List()
+		r.NoError(err)
+		r.Len(entries, 3)
+		r.Equal("run-1", entries[1].ActionRunID)
+		r.Equal(statusRestarted, entries[1].Status)
+		r.Equal(statusCodeRestarted, entries[1].Code)
+		r.Equal("run-3", entries[2].ActionRunID)
+
+		// Only the run that couldn't be ended is left for the next start.
+		j, err = openJournal(j.path)
+		r.NoError(err)
+		r.Len(j.Orphans(), 1)
+		r.Equal("run-4", j.Orphans()[0].ActionRunID)
+	})
+}
@@ -0,0 +1,378 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/strfmt"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/mitchellh/go-homedir"
+)
+
+// localQueue stands in for the Waypoint API when the agent runs with
+// --local-queue. Operations are files in the queue directory, claimed by
+// moving them to the claimed directory. Everything reported about an action
+// run is appended to a file of JSON lines named after it in the runs
+// directory.
+//
+// Only the calls the agent makes are implemented. Any other call panics, as
+// the embedded ClientService is nil.
+type localQueue struct {
+	waypoint_service.ClientService
+
+	dir string
+
+	// mu serializes appends to the run files.
+	mu sync.Mutex
+}
+
+const (
+	localQueueDir   = "queue"
+	localClaimedDir = "claimed"
+	localRunsDir    = "runs"
+
+	// localHistoryFile is the history of an agent running a local queue,
+	// kept in the queue's directory.
+	localHistoryFile = "history.jsonl"
+)
+
+// localOperation is an operation file. The body is kept as JSON, rather than
+// base64 as the API sends it, so files are easy to write by hand.
+type localOperation struct {
+	Group       string          `json:"group"`
+	ID          string          `json:"id"`
+	ActionRunID string          `json:"action_run_id,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// localRunEvent is a line in a run file.
+type localRunEvent struct {
+	Time     time.Time         `json:"time"`
+	Event    string            `json:"event"`
+	Group    string            `json:"group,omitempty"`
+	Log      string            `json:"log,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Status   string            `json:"status,omitempty"`
+	Code     *int32            `json:"code,omitempty"`
+}
+
+// openLocalQueue opens the local queue in dir, creating its directories if
+// needed.
+func openLocalQueue(dir string) (*localQueue, error) {
+	dir, err := homedir.Expand(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error expanding local queue path %q: %w", dir, err)
+	}
+
+	for _, sub := range []string{localQueueDir, localClaimedDir, localRunsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("unable to create local queue directory: %w", err)
+		}
+	}
+
+	return &localQueue{dir: dir}, nil
+}
+
+// newLocalID returns a random ID for queued operations and local action runs.
+func newLocalID() (string, error) {
+	var buf [8]byte
+
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// runPath returns the file the events of an action run are written to.
+func (q *localQueue) runPath(actionRunID string) (string, error) {
+	if actionRunID == "" || filepath.Base(actionRunID) != actionRunID || actionRunID == "." || actionRunID == ".." {
+		return "", fmt.Errorf("invalid action run ID %q", actionRunID)
+	}
+
+	return filepath.Join(q.dir, localRunsDir, actionRunID+".jsonl"), nil
+}
+
+func (q *localQueue) appendEvent(actionRunID string, ev *localRunEvent) error {
+	path, err := q.runPath(actionRunID)
+	if err != nil {
+		return err
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+
+	return errors.Join(err, f.Close())
+}
+
+// events returns the events recorded for an action run.
+func (q *localQueue) events(actionRunID string) ([]*localRunEvent, error) {
+	path, err := q.runPath(actionRunID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*localRunEvent
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var ev localRunEvent
+		if err := dec.Decode(&ev); err != nil {
+			return nil, err
+		}
+
+		events = append(events, &ev)
+	}
+
+	return events, nil
+}
+
+func (q *localQueue) WaypointServiceQueueAgentOperation(
+	params *waypoint_service.WaypointServiceQueueAgentOperationParams,
+	_ runtime.ClientAuthInfoWriter,
+	_ ...waypoint_service.ClientOption,
+) (*waypoint_service.WaypointServiceQueueAgentOperationOK, error) {
+	op := params.Body.Operation
+
+	data, err := json.MarshalIndent(&localOperation{
+		Group:       op.Group,
+		ID:          op.ID,
+		ActionRunID: op.ActionRunID,
+		Body:        json.RawMessage(op.Body),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newLocalID()
+	if err != nil {
+		return nil, err
+	}
+
+	// Name files by when they were queued so they are run in order.
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), id)
+
+	// Write to a temporary file first so an agent never reads part of one.
+	tmp := filepath.Join(q.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(q.dir, localQueueDir, name)); err != nil {
+		return nil, err
+	}
+
+	return &waypoint_service.WaypointServiceQueueAgentOperationOK{}, nil
+}
+
+func (q *localQueue) WaypointServiceRetrieveAgentOperation(
+	params *waypoint_service.WaypointServiceRetrieveAgentOperationParams,
+	_ runtime.ClientAuthInfoWriter,
+	_ ...waypoint_service.ClientOption,
+) (*waypoint_service.WaypointServiceRetrieveAgentOperationOK, error) {
+	entries, err := os.ReadDir(filepath.Join(q.dir, localQueueDir))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &waypoint_service.WaypointServiceRetrieveAgentOperationOK{
+		Payload: &models.HashicorpCloudWaypointV20241122RetrieveAgentOperationResponse{},
+	}
+
+	// ReadDir sorts by name, so the oldest operation comes first.
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(q.dir, localQueueDir, e.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Claimed by another agent.
+				continue
+			}
+
+			return nil, err
+		}
+
+		var op localOperation
+		if err := json.Unmarshal(data, &op); err != nil {
+			// Move the file aside so it doesn't block the rest of the queue.
+			bad := filepath.Join(q.dir, localClaimedDir, e.Name()+".invalid")
+			if rerr := os.Rename(path, bad); rerr != nil && !errors.Is(rerr, fs.ErrNotExist) {
+				return nil, rerr
+			}
+
+			return nil, fmt.Errorf("invalid operation file %s, moved to %s: %w", e.Name(), bad, err)
+		}
+
+		if !slices.Contains(params.Body.Groups, op.Group) {
+			continue
+		}
+
+		// Moving the file claims it. If it fails, another agent got there
+		// first.
+		if err := os.Rename(path, filepath.Join(q.dir, localClaimedDir, e.Name())); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		resp.Payload.Operation = &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       op.Group,
+			ID:          op.ID,
+			ActionRunID: op.ActionRunID,
+			Body:        strfmt.Base64(op.Body),
+		}
+
+		break
+	}
+
+	return resp, nil
+}
+
+func (q *localQueue) WaypointServiceValidateAgentGroups(
+	params *waypoint_service.WaypointServiceValidateAgentGroupsParams,
+	_ runtime.ClientAuthInfoWriter,
+	_ ...waypoint_service.ClientOption,
+) (*waypoint_service.WaypointServiceValidateAgentGroupsOK, error) {
+	// Groups only exist in the config when running locally.
+	return &waypoint_service.WaypointServiceValidateAgentGroupsOK{
+		Payload: &models.HashicorpCloudWaypointV20241122ValidateAgentGroupsResponse{},
+	}, nil
+}
+
+func (q *localQueue) WaypointServiceStartingAction(
+	params *waypoint_service.WaypointServiceStartingActionParams,
+	_ runtime.ClientAuthInfoWriter,
+	_ ...waypoint_service.ClientOption,
+) (*waypoint_service.WaypointServiceStartingActionOK, error) {
+	runID := params.Body.ActionRunID
+
+	// Each start of a run is another sequence, as it would be in HCP.
+	sequence := 1
+
+	events, err := q.events(runID)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, ev := range events {
+		if ev.Event == "starting" {
+			sequence++
+		}
+	}
+
+	err = q.appendEvent(runID, &localRunEvent{
+		Event: "starting",
+		Group: params.Body.GroupName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &waypoint_service.WaypointServiceStartingActionOK{
+		Payload: &models.HashicorpCloudWaypointV20241122StartingActionResponse{
+			ActionRunID: runID,
+			Sequence:    strconv.Itoa(sequence),
+		},
+	}, nil
+}
+
+func (q *localQueue) WaypointServiceEndingAction(
+	params *waypoint_service.WaypointServiceEndingActionParams,
+	_ runtime.ClientAuthInfoWriter,
+	_ ...waypoint_service.ClientOption,
+) (*waypoint_service.WaypointServiceEndingActionOK, error) {
+	code := params.Body.StatusCode
+
+	err := q.appendEvent(params.Body.ActionRunID, &localRunEvent{
+		Event:  "ending",
+		Status: params.Body.FinalStatus,
+		Code:   &code,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &waypoint_service.WaypointServiceEndingActionOK{}, nil
+}
+
+func (q *localQueue) WaypointServiceSendStatusLog2(
+	params *waypoint_service.WaypointServiceSendStatusLog2Params,
+	_ runtime.ClientAuthInfoWriter,
+	_ ...waypoint_service.ClientOption,
+) (*waypoint_service.WaypointServiceSendStatusLog2OK, error) {
+	ev := &localRunEvent{Event: "status_log"}
+
+	if sl := params.Body.StatusLog; sl != nil {
+		ev.Time = time.Time(sl.EmittedAt)
+		ev.Log = sl.Log
+		ev.Metadata = sl.Metadata
+	}
+
+	if err := q.appendEvent(params.ActionRunID, ev); err != nil {
+		return nil, err
+	}
+
+	return &waypoint_service.WaypointServiceSendStatusLog2OK{}, nil
+}
+
+var errLocalQueueUnsupported = errors.New("not available when running with a local queue")
+
+func (q *localQueue) WaypointServiceRunAction(
+	params *waypoint_service.WaypointServiceRunActionParams,
+	_ runtime.ClientAuthInfoWriter,
+	_ ...waypoint_service.ClientOption,
+) (*waypoint_service.WaypointServiceRunActionOK, error) {
+	return nil, fmt.Errorf("running other actions is %w", errLocalQueueUnsupported)
+}
+
+func (q *localQueue) WaypointServiceGetActionRun(
+	params *waypoint_service.WaypointServiceGetActionRunParams,
+	_ runtime.ClientAuthInfoWriter,
+	_ ...waypoint_service.ClientOption,
+) (*waypoint_service.WaypointServiceGetActionRunOK, error) {
+	return nil, fmt.Errorf("reading action runs is %w", errLocalQueueUnsupported)
+}
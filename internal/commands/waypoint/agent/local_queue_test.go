@@ -0,0 +1,231 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	"github.com/hashicorp/hcp/internal/pkg/iostreams"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+func TestLocalQueue(t *testing.T) {
+	t.Parallel()
+
+	queue := func(t *testing.T, dir, group, id, body, runID string) {
+		t.Helper()
+
+		io := iostreams.Test()
+
+		require.NoError(t, agentQueue(hclog.NewNullLogger(), &QueueOpts{
+			WaypointOpts: opts.WaypointOpts{
+				Ctx:     context.Background(),
+				IO:      io,
+				Profile: &profile.Profile{},
+			},
+			Group:       group,
+			ID:          id,
+			Body:        body,
+			ActionRunID: runID,
+			LocalQueue:  dir,
+		}))
+
+		require.Contains(t, io.Error.String(), "queued locally")
+	}
+
+	t.Run("runs queued operations and records their runs", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+
+		queue(t, dir, "test", "launch", `{"var.name": "web"}`, "run-1")
+		queue(t, dir, "other", "launch", "", "run-2")
+
+		q, err := openLocalQueue(dir)
+		r.NoError(err)
+
+		runner := testRunner(t, q, `
+		group "test" {
+			action "launch" {
+				operation {
+					status {
+						message = "launching ${var.name}"
+						values  = { app = var.name }
+					}
+				}
+
+				operation {
+					run {
+						command = ["echo", "launched"]
+					}
+				}
+			}
+		}
+`)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		done := make(chan error)
+		go func() { done <- runner.Run(ctx) }()
+
+		path := filepath.Join(dir, localRunsDir, "run-1.jsonl")
+
+		r.Eventually(func() bool {
+			events, err := q.events("run-1")
+			return err == nil && len(events) == 3
+		}, 10*time.Second, 10*time.Millisecond, "waiting for %s", path)
+
+		cancel()
+		r.NoError(<-done)
+
+		events, err := q.events("run-1")
+		r.NoError(err)
+
+		r.Equal("starting", events[0].Event)
+		r.Equal("test", events[0].Group)
+
+		r.Equal("status_log", events[1].Event)
+		r.Equal("launching web", events[1].Log)
+		r.Equal(map[string]string{"app": "web"}, events[1].Metadata)
+
+		r.Equal("ending", events[2].Event)
+		r.Equal("output: launched", events[2].Status)
+		r.Equal(int32(0), *events[2].Code)
+
+		// The operation for the other group is left for another agent.
+		queued, err := os.ReadDir(filepath.Join(dir, localQueueDir))
+		r.NoError(err)
+		r.Len(queued, 1)
+
+		claimed, err := os.ReadDir(filepath.Join(dir, localClaimedDir))
+		r.NoError(err)
+		r.Len(claimed, 1)
+	})
+
+	t.Run("leaves the history of an agent polling HCP alone", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+		hcpDir := t.TempDir()
+
+		// The history and journal of an agent running the same config against
+		// HCP, with an ending it failed to send and a run it was cut short in.
+		history, err := openHistory(filepath.Join(hcpDir, "history.jsonl"))
+		r.NoError(err)
+		r.NoError(history.Append(&historyEntry{Project: "prod", Group: "test", Action: "launch", ActionRunID: "run-1", ReportPending: true}))
+
+		journal, err := openJournal(journalPath(history.path))
+		r.NoError(err)
+		_, err = journal.Claim(&journalEntry{Project: "prod", Group: "test", Action: "launch", ActionRunID: "run-2"})
+		r.NoError(err)
+
+		configPath := filepath.Join(dir, "agent.hcl")
+		r.NoError(os.WriteFile(configPath, []byte(`
+history_path = "`+history.path+`"
+
+project "prod" {
+	organization_id = "org"
+	project_id      = "proj"
+	groups          = ["test"]
+}
+
+group "test" {}
+`), 0o600))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		r.NoError(agentRun(hclog.NewNullLogger(), &RunOpts{
+			WaypointOpts: opts.WaypointOpts{
+				Ctx:     ctx,
+				IO:      iostreams.Test(),
+				Profile: &profile.Profile{},
+			},
+			ConfigPath: configPath,
+			LocalQueue: dir,
+		}))
+
+		entries, err := history.List()
+		r.NoError(err)
+		r.Len(entries, 1)
+		r.True(entries[0].ReportPending)
+
+		journal, err = openJournal(journal.path)
+		r.NoError(err)
+		r.Len(journal.Orphans(), 1)
+
+		// The local run has a history of its own.
+		r.FileExists(filepath.Join(dir, localHistoryFile))
+
+		runs, err := os.ReadDir(filepath.Join(dir, localRunsDir))
+		r.NoError(err)
+		r.Empty(runs)
+	})
+
+	t.Run("generates an action run for queued operations", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+		queue(t, dir, "test", "launch", "", "")
+
+		q, err := openLocalQueue(dir)
+		r.NoError(err)
+
+		resp, err := q.WaypointServiceRetrieveAgentOperation(retrieveParams("test"), nil)
+		r.NoError(err)
+		r.NotNil(resp.Payload.Operation)
+		r.Regexp("^local-[0-9a-f]{16}$", resp.Payload.Operation.ActionRunID)
+
+		// It has been claimed, so it isn't handed out again.
+		resp, err = q.WaypointServiceRetrieveAgentOperation(retrieveParams("test"), nil)
+		r.NoError(err)
+		r.Nil(resp.Payload.Operation)
+	})
+
+	t.Run("moves invalid operation files aside", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+
+		q, err := openLocalQueue(dir)
+		r.NoError(err)
+
+		r.NoError(os.WriteFile(filepath.Join(dir, localQueueDir, "0-bad.json"), []byte("{"), 0o600))
+		queue(t, dir, "test", "launch", "", "run-1")
+
+		_, err = q.WaypointServiceRetrieveAgentOperation(retrieveParams("test"), nil)
+		r.ErrorContains(err, "invalid operation file 0-bad.json")
+
+		resp, err := q.WaypointServiceRetrieveAgentOperation(retrieveParams("test"), nil)
+		r.NoError(err)
+		r.Equal("run-1", resp.Payload.Operation.ActionRunID)
+	})
+}
+
+func retrieveParams(groups ...string) *waypoint_service.WaypointServiceRetrieveAgentOperationParams {
+	return &waypoint_service.WaypointServiceRetrieveAgentOperationParams{
+		Body: &models.HashicorpCloudWaypointV20241122WaypointServiceRetrieveAgentOperationBody{
+			Groups: groups,
+		},
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// operationDurationBuckets are the upper bounds, in seconds, of the operation
+// duration histogram.
+var operationDurationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600}
+
+type operationKey struct {
+	group  string
+	action string
+}
+
+type operationResultKey struct {
+	operationKey
+	code int
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// agentMetrics records what the agent is doing so it can be exposed in the
+// Prometheus text format.
+type agentMetrics struct {
+	mu sync.Mutex
+
+	polls      uint64
+	pollErrors uint64
+	lastPoll   time.Time
+
+	// busy is set while every worker is running an operation, so the agent
+	// isn't polling. lastBusy is when it last stopped being busy.
+	busy     bool
+	lastBusy time.Time
+
+	// tokenErr is the error from the last attempt to get an HCP token, when
+	// the agent authenticates with an auth block.
+	tokenErr    error
+	tokenErrors uint64
+
+	inFlight   int
+	operations map[operationResultKey]uint64
+	durations  map[operationKey]*histogram
+}
+
+func newAgentMetrics() *agentMetrics {
+	return &agentMetrics{
+		operations: make(map[operationResultKey]uint64),
+		durations:  make(map[operationKey]*histogram),
+	}
+}
+
+// ObservePoll records a poll for operations and whether it failed.
+func (m *agentMetrics) ObservePoll(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.polls++
+
+	if err != nil {
+		m.pollErrors++
+		return
+	}
+
+	m.lastPoll = time.Now()
+}
+
+// LastPoll returns when operations were last polled for successfully.
+func (m *agentMetrics) LastPoll() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lastPoll
+}
+
+// SetBusy records whether every worker is running an operation.
+func (m *agentMetrics) SetBusy(busy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.busy && !busy {
+		m.lastBusy = time.Now()
+	}
+
+	m.busy = busy
+}
+
+// Busy reports whether every worker is running an operation, and when that
+// last stopped being the case.
+func (m *agentMetrics) Busy() (bool, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.busy, m.lastBusy
+}
+
+// ObserveToken records an attempt to get an HCP token, and reports whether
+// it failed when the last one succeeded or the other way around.
+func (m *agentMetrics) ObserveToken(err error) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changed := (err == nil) != (m.tokenErr == nil)
+
+	if err != nil {
+		m.tokenErrors++
+	}
+
+	m.tokenErr = err
+
+	return changed
+}
+
+// TokenError returns the error from the last attempt to get an HCP token, or
+// nil if it succeeded.
+func (m *agentMetrics) TokenError() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.tokenErr
+}
+
+// OperationStarted records that an operation has started running.
+func (m *agentMetrics) OperationStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight++
+}
+
+// OperationFinished records the result of an operation started with
+// OperationStarted.
+func (m *agentMetrics) OperationFinished(group, action string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight--
+
+	key := operationKey{group: group, action: action}
+	m.operations[operationResultKey{operationKey: key, code: code}]++
+
+	h, ok := m.durations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(operationDurationBuckets))}
+		m.durations[key] = h
+	}
+
+	secs := d.Seconds()
+	for i, bound := range operationDurationBuckets {
+		if secs <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.count++
+	h.sum += secs
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *agentMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP hcp_waypoint_agent_polls_total Polls for operations.\n")
+	b.WriteString("# TYPE hcp_waypoint_agent_polls_total counter\n")
+	fmt.Fprintf(&b, "hcp_waypoint_agent_polls_total %d\n", m.polls)
+
+	b.WriteString("# HELP hcp_waypoint_agent_poll_errors_total Polls for operations that failed.\n")
+	b.WriteString("# TYPE hcp_waypoint_agent_poll_errors_total counter\n")
+	fmt.Fprintf(&b, "hcp_waypoint_agent_poll_errors_total %d\n", m.pollErrors)
+
+	b.WriteString("# HELP hcp_waypoint_agent_token_errors_total Attempts to refresh the HCP token of the agent's auth block that failed.\n")
+	b.WriteString("# TYPE hcp_waypoint_agent_token_errors_total counter\n")
+	fmt.Fprintf(&b, "hcp_waypoint_agent_token_errors_total %d\n", m.tokenErrors)
+
+	b.WriteString("# HELP hcp_waypoint_agent_operations_in_flight Operations currently running.\n")
+	b.WriteString("# TYPE hcp_waypoint_agent_operations_in_flight gauge\n")
+	fmt.Fprintf(&b, "hcp_waypoint_agent_operations_in_flight %d\n", m.inFlight)
+
+	b.WriteString("# HELP hcp_waypoint_agent_operations_total Operations run, by group, action and status code.\n")
+	b.WriteString("# TYPE hcp_waypoint_agent_operations_total counter\n")
+
+	results := make([]operationResultKey, 0, len(m.operations))
+	for k := range m.operations {
+		results = append(results, k)
+	}
+
+	slices.SortFunc(results, func(a, b operationResultKey) int {
+		if c := compareOperationKeys(a.operationKey, b.operationKey); c != 0 {
+			return c
+		}
+
+		return a.code - b.code
+	})
+
+	for _, k := range results {
+		fmt.Fprintf(&b, "hcp_waypoint_agent_operations_total{group=%s,action=%s,code=\"%d\"} %d\n",
+			quoteLabel(k.group), quoteLabel(k.action), k.code, m.operations[k])
+	}
+
+	b.WriteString("# HELP hcp_waypoint_agent_operation_duration_seconds How long operations took to run.\n")
+	b.WriteString("# TYPE hcp_waypoint_agent_operation_duration_seconds histogram\n")
+
+	keys := make([]operationKey, 0, len(m.durations))
+	for k := range m.durations {
+		keys = append(keys, k)
+	}
+
+	slices.SortFunc(keys, compareOperationKeys)
+
+	for _, k := range keys {
+		h := m.durations[k]
+		labels := fmt.Sprintf("group=%s,action=%s", quoteLabel(k.group), quoteLabel(k.action))
+
+		for i, bound := range operationDurationBuckets {
+			fmt.Fprintf(&b, "hcp_waypoint_agent_operation_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+
+		fmt.Fprintf(&b, "hcp_waypoint_agent_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&b, "hcp_waypoint_agent_operation_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "hcp_waypoint_agent_operation_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func compareOperationKeys(a, b operationKey) int {
+	if c := strings.Compare(a.group, b.group); c != 0 {
+		return c
+	}
+
+	return strings.Compare(a.action, b.action)
+}
+
+// quoteLabel quotes a label value, escaping it as the text format requires.
+func quoteLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+
+	return `"` + s + `"`
+}
@@ -0,0 +1,118 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"sync"
+)
+
+// workerPool bounds the number of operations the agent runs at once. There is
+// an overall limit as well as optional per group limits, so that a single busy
+// group can not use every worker.
+type workerPool struct {
+	size   int
+	limits map[string]int
+
+	mu      sync.Mutex
+	active  int
+	byGroup map[string]int
+
+	freed chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newWorkerPool(size int, limits map[string]int) *workerPool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &workerPool{
+		size:    size,
+		limits:  limits,
+		byGroup: make(map[string]int),
+		freed:   make(chan struct{}, 1),
+	}
+}
+
+// Available returns the groups that can accept another operation. If the pool
+// is full, nil is returned.
+func (p *workerPool) Available(groups []string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active >= p.size {
+		return nil
+	}
+
+	var ret []string
+
+	for _, g := range groups {
+		if limit, ok := p.limits[g]; ok && p.byGroup[g] >= limit {
+			continue
+		}
+
+		ret = append(ret, g)
+	}
+
+	return ret
+}
+
+// SetLimits replaces the per group limits. Operations already running are
+// still counted against their group.
+func (p *workerPool) SetLimits(limits map[string]int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.limits = limits
+}
+
+// Go runs f in a new goroutine, accounting it against the overall limit and
+// the limit for group. Callers should use Available first to check that there
+// is room for the operation.
+func (p *workerPool) Go(group string, f func()) {
+	p.mu.Lock()
+	p.active++
+	p.byGroup[group]++
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+		defer p.release(group)
+
+		f()
+	}()
+}
+
+func (p *workerPool) release(group string) {
+	p.mu.Lock()
+	p.active--
+	p.byGroup[group]--
+	p.mu.Unlock()
+
+	select {
+	case p.freed <- struct{}{}:
+	default:
+	}
+}
+
+// Active returns the number of operations currently running.
+func (p *workerPool) Active() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.active
+}
+
+// Freed returns a channel that receives a value whenever an operation
+// finishes.
+func (p *workerPool) Freed() <-chan struct{} {
+	return p.freed
+}
+
+// Wait blocks until all operations started with Go have finished.
+func (p *workerPool) Wait() {
+	p.wg.Wait()
+}
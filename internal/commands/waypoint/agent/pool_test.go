@@ -0,0 +1,58 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPool(t *testing.T) {
+	t.Parallel()
+
+	t.Run("limits the overall number of operations", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		p := newWorkerPool(2, nil)
+
+		block := make(chan struct{})
+
+		r.Equal([]string{"a", "b"}, p.Available([]string{"a", "b"}))
+
+		p.Go("a", func() { <-block })
+		p.Go("b", func() { <-block })
+
+		r.Nil(p.Available([]string{"a", "b"}))
+		r.Equal(2, p.Active())
+
+		close(block)
+		p.Wait()
+
+		r.Equal(0, p.Active())
+		r.Equal([]string{"a", "b"}, p.Available([]string{"a", "b"}))
+	})
+
+	t.Run("limits the number of operations per group", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		p := newWorkerPool(4, map[string]int{"a": 1})
+
+		block := make(chan struct{})
+
+		p.Go("a", func() { <-block })
+
+		r.Equal([]string{"b"}, p.Available([]string{"a", "b"}))
+
+		close(block)
+		<-p.Freed()
+		p.Wait()
+
+		r.Equal([]string{"a", "b"}, p.Available([]string{"a", "b"}))
+	})
+}
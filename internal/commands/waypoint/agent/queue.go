@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/go-openapi/strfmt"
 	"github.com/hashicorp/go-hclog"
@@ -28,6 +29,15 @@ type QueueOpts struct {
 	Body  string
 
 	ActionRunID string
+	LocalQueue  string
+
+	Wait    bool
+	Follow  bool
+	Timeout time.Duration
+
+	// pollInterval is how often the action run is read when waiting. It
+	// defaults to defaultQueuePollInterval.
+	pollInterval time.Duration
 }
 
 func NewCmdQueue(ctx *cmd.Context) *cmd.Command {
@@ -40,7 +50,21 @@ func NewCmdQueue(ctx *cmd.Context) *cmd.Command {
 		ShortHelp: "Queue an operation for an agent to execute.",
 		LongHelp: heredoc.New(ctx.IO).Must(`
 		The {{ template "mdCodeOrBold" "hcp waypoint agent queue" }} command queues an operation for an agent to run.
+
+With {{ template "mdCodeOrBold" "--local-queue" }}, the operation is written to a directory for an agent started
+with {{ template "mdCodeOrBold" "hcp waypoint agent run --local-queue" }} instead of being queued in HCP.
+
+With {{ template "mdCodeOrBold" "--wait" }}, the command waits for the action run the operation is queued for to finish,
+shows how it ended and exits with its status code. {{ template "mdCodeOrBold" "--follow" }} also prints the status logs
+of the run as they arrive. HCP only records whether a run succeeded, so a run that failed there exits with 1.
+Waiting requires {{ template "mdCodeOrBold" "--action-run" }}, unless the operation is queued locally.
 		`),
+		Examples: []cmd.Example{
+			{
+				Preamble: "Queue an operation for an action run and follow it until it finishes:",
+				Command:  "$ hcp waypoint agent queue -g prod -i deploy --action-run $ACTION_RUN_ID --follow",
+			},
+		},
 		Flags: cmd.Flags{
 			Local: []*cmd.Flag{
 				{
@@ -72,8 +96,45 @@ func NewCmdQueue(ctx *cmd.Context) *cmd.Command {
 					Value:        flagvalue.Simple("", &opts.Group),
 					Required:     true,
 				},
+				{
+					Name:         "local-queue",
+					DisplayValue: "DIR",
+					Description:  "Write the operation to this directory for \"hcp waypoint agent run --local-queue\" instead of queuing it in HCP. An action run ID is generated if none is given.",
+					Value:        flagvalue.Simple("", &opts.LocalQueue),
+				},
+				{
+					Name:          "wait",
+					Description:   "Wait for the action run to finish and exit with its status code.",
+					Value:         flagvalue.Simple(false, &opts.Wait),
+					IsBooleanFlag: true,
+				},
+				{
+					Name:          "follow",
+					Description:   "Wait for the action run to finish, printing its status logs as they arrive.",
+					Value:         flagvalue.Simple(false, &opts.Follow),
+					IsBooleanFlag: true,
+				},
+				{
+					Name:         "timeout",
+					DisplayValue: "DURATION",
+					Description:  "How long to wait for the action run to finish. Use 0 to wait indefinitely.",
+					Value:        flagvalue.Duration(30*time.Minute, &opts.Timeout),
+				},
 			},
 		},
+		// Operations queued locally never reach HCP.
+		NoAuthRequired: true,
+		PersistentPreRun: func(c *cmd.Command, args []string) error {
+			if opts.LocalQueue != "" {
+				return nil
+			}
+
+			if err := requireLogin(ctx); err != nil {
+				return err
+			}
+
+			return cmd.RequireOrgAndProject(ctx)
+		},
 		RunF: func(c *cmd.Command, args []string) error {
 			return agentQueue(c.Logger(), opts)
 		},
@@ -83,31 +144,48 @@ func NewCmdQueue(ctx *cmd.Context) *cmd.Command {
 }
 
 func agentQueue(log hclog.Logger, opts *QueueOpts) error {
-	var body strfmt.Base64
+	if opts.Follow {
+		opts.Wait = true
+	}
 
-	if strings.HasPrefix(opts.Body, "@") {
-		path := opts.Body[1:]
-		data, err := os.ReadFile(path)
+	if opts.Wait && opts.ActionRunID == "" && opts.LocalQueue == "" {
+		return fmt.Errorf("--action-run is required to wait for an operation")
+	}
+
+	body, err := readBody(opts.Body)
+	if err != nil {
+		return err
+	}
+
+	ctx := opts.Ctx
+
+	var read queuedRunReader
+
+	if opts.LocalQueue != "" {
+		q, err := openLocalQueue(opts.LocalQueue)
 		if err != nil {
-			return fmt.Errorf("unable to read json file %s: %w", path, err)
+			return err
 		}
 
-		if !json.Valid(data) {
-			return fmt.Errorf("invalid json in file '%s'", path)
-		}
+		opts.WS2024Client = q
+
+		// Status logs and the ending of the run are only written locally
+		// for operations with an action run, so make one up.
+		if opts.ActionRunID == "" {
+			id, err := newLocalID()
+			if err != nil {
+				return err
+			}
 
-		body = data
-	} else if len(opts.Body) > 0 {
-		if !json.Valid([]byte(opts.Body)) {
-			return fmt.Errorf("invalid json specified on command line")
+			opts.ActionRunID = "local-" + id
 		}
 
-		body = strfmt.Base64(opts.Body)
+		read = localRunReader(q, opts.ActionRunID)
+	} else {
+		read = remoteRunReader(opts)
 	}
 
-	ctx := opts.Ctx
-
-	_, err := opts.WS2024Client.WaypointServiceQueueAgentOperation(&waypoint_service.WaypointServiceQueueAgentOperationParams{
+	_, err = opts.WS2024Client.WaypointServiceQueueAgentOperation(&waypoint_service.WaypointServiceQueueAgentOperationParams{
 		Body: &models.HashicorpCloudWaypointV20241122WaypointServiceQueueAgentOperationBody{
 			Operation: &models.HashicorpCloudWaypointV20241122AgentOperation{
 				ID:          opts.ID,
@@ -125,6 +203,43 @@ func agentQueue(log hclog.Logger, opts *QueueOpts) error {
 		return fmt.Errorf("error queuing operation: %w", err)
 	}
 
-	_, _ = fmt.Fprintf(opts.IO.Err(), "Operation '%s' queued.\n", opts.ID)
-	return nil
+	if opts.LocalQueue != "" {
+		_, _ = fmt.Fprintf(opts.IO.Err(), "Operation '%s' queued locally as action run '%s'.\n", opts.ID, opts.ActionRunID)
+	} else {
+		_, _ = fmt.Fprintf(opts.IO.Err(), "Operation '%s' queued.\n", opts.ID)
+	}
+
+	if !opts.Wait {
+		return nil
+	}
+
+	return waitQueued(log, opts, read)
+}
+
+// readBody returns the JSON body of an operation given on the command line,
+// either inline or as @filename.
+func readBody(arg string) (strfmt.Base64, error) {
+	if strings.HasPrefix(arg, "@") {
+		path := arg[1:]
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read json file %s: %w", path, err)
+		}
+
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("invalid json in file '%s'", path)
+		}
+
+		return data, nil
+	}
+
+	if len(arg) > 0 {
+		if !json.Valid([]byte(arg)) {
+			return nil, fmt.Errorf("invalid json specified on command line")
+		}
+
+		return strfmt.Base64(arg), nil
+	}
+
+	return nil, nil
 }
@@ -0,0 +1,338 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/strfmt"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/format"
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+)
+
+const (
+	// defaultQueuePollInterval is how often queue --wait reads the action
+	// run.
+	defaultQueuePollInterval = 2 * time.Second
+
+	// statusCodeTimeout is the exit code of queue --wait when the action run
+	// doesn't finish in time. It matches the exit code of timeout(1).
+	statusCodeTimeout = 124
+)
+
+// queuedRun is the state of the action run an operation was queued for.
+type queuedRun struct {
+	ActionRunID string `json:"action_run_id"`
+	Sequence    string `json:"sequence,omitempty"`
+
+	// Result is the response status of the run, SUCCESS or ERROR once it
+	// has finished.
+	Result string `json:"result"`
+
+	// Code is the status code of the run. HCP only records whether a run
+	// succeeded, so runs that failed there have code 1.
+	Code int `json:"code"`
+
+	// Status is the final status of a local run, or the last status log of
+	// a run in HCP.
+	Status      string    `json:"status,omitempty"`
+	CompletedAt time.Time `json:"completed_at"`
+
+	StatusLogs []*models.HashicorpCloudWaypointV20241122StatusLog `json:"status_logs,omitempty"`
+
+	finished bool
+}
+
+// queuedRunReader reads the current state of an action run. It returns nil
+// if the run can't be seen yet.
+type queuedRunReader func(ctx context.Context) (*queuedRun, error)
+
+// remoteRunReader reads the action run of opts from HCP. Runs are read by
+// action and sequence, so the run is first looked up by its ID, one page of
+// the project's action runs per read until it is found.
+func remoteRunReader(opts *QueueOpts) queuedRunReader {
+	var (
+		action, sequence string
+		page             *string
+	)
+
+	return func(ctx context.Context) (*queuedRun, error) {
+		if action == "" {
+			run, next, err := findActionRun(ctx, opts, page)
+			if err != nil {
+				return nil, err
+			}
+
+			page = next
+
+			if run == nil {
+				return nil, nil
+			}
+
+			switch {
+			case run.ActionConfigRef != nil && run.ActionConfigRef.Name != "":
+				action = run.ActionConfigRef.Name
+			case run.ActionConfig != nil && run.ActionConfig.Name != "":
+				action = run.ActionConfig.Name
+			default:
+				return nil, fmt.Errorf("action run %s has no action", opts.ActionRunID)
+			}
+
+			sequence = run.Sequence
+		}
+
+		resp, err := opts.WS2024Client.WaypointServiceGetActionRun(&waypoint_service.WaypointServiceGetActionRunParams{
+			NamespaceLocationOrganizationID: opts.Profile.OrganizationID,
+			NamespaceLocationProjectID:      opts.Profile.ProjectID,
+			ActionName:                      &action,
+			Sequence:                        &sequence,
+			Context:                         ctx,
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read action run %s: %w", opts.ActionRunID, err)
+		}
+
+		if resp.Payload == nil || resp.Payload.ActionRun == nil {
+			return nil, nil
+		}
+
+		run := resp.Payload.ActionRun
+
+		q := &queuedRun{
+			ActionRunID: opts.ActionRunID,
+			Sequence:    run.Sequence,
+			CompletedAt: time.Time(run.CompletedAt),
+			StatusLogs:  run.StatusLog,
+			finished:    agent.ActionRunFinished(run),
+		}
+
+		if run.ResponseStatus != nil {
+			q.Result = string(*run.ResponseStatus)
+		}
+
+		if n := len(run.StatusLog); n > 0 && run.StatusLog[n-1] != nil {
+			q.Status = run.StatusLog[n-1].Log
+		}
+
+		if q.finished && q.Result != string(models.HashicorpCloudWaypointV20241122ActionRunResponseStatusSUCCESS) {
+			q.Code = 1
+		}
+
+		return q, nil
+	}
+}
+
+// findActionRun looks for the run of opts on the page of the project's action
+// runs at page, the first page if nil. It returns nil if the run isn't on the
+// page, along with the page to read next, which is the first again after the
+// last page.
+func findActionRun(ctx context.Context, opts *QueueOpts, page *string) (*models.HashicorpCloudWaypointV20241122ActionRun, *string, error) {
+	resp, err := opts.WS2024Client.WaypointServiceListActionRunsByNamespace(&waypoint_service.WaypointServiceListActionRunsByNamespaceParams{
+		NamespaceLocationOrganizationID: opts.Profile.OrganizationID,
+		NamespaceLocationProjectID:      opts.Profile.ProjectID,
+		PaginationNextPageToken:         page,
+		Context:                         ctx,
+	}, nil)
+	if err != nil {
+		return nil, page, fmt.Errorf("unable to list action runs: %w", err)
+	}
+
+	if resp.Payload == nil {
+		return nil, nil, nil
+	}
+
+	for _, run := range resp.Payload.ActionRuns {
+		if run != nil && run.ID == opts.ActionRunID {
+			return run, nil, nil
+		}
+	}
+
+	if resp.Payload.Pagination == nil || resp.Payload.Pagination.NextPageToken == "" {
+		return nil, nil, nil
+	}
+
+	return nil, &resp.Payload.Pagination.NextPageToken, nil
+}
+
+// localRunReader reads an action run from the run file of a local queue.
+func localRunReader(q *localQueue, actionRunID string) queuedRunReader {
+	return func(context.Context) (*queuedRun, error) {
+		events, err := q.events(actionRunID)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read action run %s: %w", actionRunID, err)
+		}
+
+		run := &queuedRun{
+			ActionRunID: actionRunID,
+			Result:      string(models.HashicorpCloudWaypointV20241122ActionRunResponseStatusNONE),
+		}
+
+		for _, ev := range events {
+			switch ev.Event {
+			case "status_log":
+				run.StatusLogs = append(run.StatusLogs, &models.HashicorpCloudWaypointV20241122StatusLog{
+					EmittedAt: strfmt.DateTime(ev.Time),
+					Log:       ev.Log,
+					Metadata:  ev.Metadata,
+				})
+			case "ending":
+				run.finished = true
+				run.Status = ev.Status
+				run.CompletedAt = ev.Time
+
+				if ev.Code != nil {
+					run.Code = int(*ev.Code)
+				}
+
+				run.Result = string(models.HashicorpCloudWaypointV20241122ActionRunResponseStatusSUCCESS)
+				if run.Code != 0 {
+					run.Result = string(models.HashicorpCloudWaypointV20241122ActionRunResponseStatusERROR)
+				}
+			}
+		}
+
+		return run, nil
+	}
+}
+
+// waitQueued waits for the action run of a queued operation to finish,
+// printing its status logs as they arrive if following, and shows how it
+// ended. The command exits with the status code of the run.
+func waitQueued(log hclog.Logger, opts *QueueOpts, read queuedRunReader) error {
+	ctx := opts.Ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	interval := opts.pollInterval
+	if interval <= 0 {
+		interval = defaultQueuePollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var printed int
+
+	for {
+		run, err := read(ctx)
+		if err != nil {
+			var respErr runtime.ClientResponseStatus
+			if errors.As(err, &respErr) && respErr.IsClientError() {
+				return err
+			}
+
+			if ctx.Err() == nil {
+				log.Warn("error reading action run, retrying", "error", err)
+			}
+		}
+
+		if run != nil {
+			if opts.Follow {
+				printed = printStatusLogs(opts, run.StatusLogs, printed)
+			}
+
+			if run.finished {
+				if err := opts.Output.Display(queuedRunDisplayer{run}); err != nil {
+					return err
+				}
+
+				if run.Code != 0 {
+					return cmd.NewExitError(run.Code, fmt.Errorf("action run %s finished with status %s", opts.ActionRunID, run.Result))
+				}
+
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return cmd.NewExitError(statusCodeTimeout, fmt.Errorf("timed out after %s waiting for action run %s", opts.Timeout, opts.ActionRunID))
+			}
+
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// printStatusLogs prints the status logs after the first printed ones to
+// stderr, so they don't mix with the output of the command, and returns the
+// number printed so far.
+func printStatusLogs(opts *QueueOpts, logs []*models.HashicorpCloudWaypointV20241122StatusLog, printed int) int {
+	if printed > len(logs) {
+		return printed
+	}
+
+	for _, sl := range logs[printed:] {
+		if sl == nil {
+			continue
+		}
+
+		ts := time.Time(sl.EmittedAt).Local().Format(time.TimeOnly)
+
+		for _, line := range strings.Split(strings.TrimRight(sl.Log, "\n"), "\n") {
+			_, _ = fmt.Fprintf(opts.IO.Err(), "%s %s\n", ts, line)
+		}
+	}
+
+	return len(logs)
+}
+
+type queuedRunDisplayer struct {
+	run *queuedRun
+}
+
+func (d queuedRunDisplayer) DefaultFormat() format.Format {
+	return format.Pretty
+}
+
+func (d queuedRunDisplayer) Payload() any {
+	return d.run
+}
+
+func (d queuedRunDisplayer) FieldTemplates() []format.Field {
+	return []format.Field{
+		{
+			Name:        "Action Run",
+			ValueFormat: "{{ .ActionRunID }}",
+		},
+		{
+			Name:        "Sequence",
+			ValueFormat: "{{ .Sequence }}",
+		},
+		{
+			Name:        "Result",
+			ValueFormat: "{{ .Result }}",
+		},
+		{
+			Name:        "Code",
+			ValueFormat: "{{ .Code }}",
+		},
+		{
+			Name:        "Completed At",
+			ValueFormat: "{{ .CompletedAt }}",
+		},
+		{
+			Name:        "Status",
+			ValueFormat: "{{ .Status }}",
+		},
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/strfmt"
+	"github.com/hashicorp/go-hclog"
+	cloud "github.com/hashicorp/hcp-sdk-go/clients/cloud-shared/v1/models"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	mock_waypoint_service "github.com/hashicorp/hcp/internal/pkg/api/mocks/github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/format"
+	"github.com/hashicorp/hcp/internal/pkg/iostreams"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+func TestQueueWait(t *testing.T) {
+	t.Parallel()
+
+	newOpts := func(io *iostreams.Testing, api waypoint_service.ClientService) *QueueOpts {
+		return &QueueOpts{
+			WaypointOpts: opts.WaypointOpts{
+				Ctx:          context.Background(),
+				IO:           io,
+				Profile:      &profile.Profile{OrganizationID: "org", ProjectID: "proj"},
+				Output:       format.New(io),
+				WS2024Client: api,
+			},
+			Group:        "prod",
+			ID:           "deploy",
+			ActionRunID:  "run-1",
+			Timeout:      10 * time.Second,
+			pollInterval: time.Millisecond,
+		}
+	}
+
+	expectQueued := func(api *mock_waypoint_service.MockClientService) {
+		api.EXPECT().
+			WaypointServiceQueueAgentOperation(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceQueueAgentOperationOK{}, nil).
+			Once()
+
+		api.EXPECT().
+			WaypointServiceListActionRunsByNamespace(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceListActionRunsByNamespaceOK{
+				Payload: &models.HashicorpCloudWaypointV20241122ListActionRunsByNamespaceResponse{
+					ActionRuns: []*models.HashicorpCloudWaypointV20241122ActionRun{
+						{ID: "run-0", Sequence: "1"},
+						{
+							ID:              "run-1",
+							Sequence:        "2",
+							ActionConfigRef: &models.HashicorpCloudWaypointV20241122ActionCfgRef{Name: "deploy"},
+						},
+					},
+				},
+			}, nil).
+			Once()
+	}
+
+	t.Run("requires an action run", func(t *testing.T) {
+		t.Parallel()
+
+		o := newOpts(iostreams.Test(), nil)
+		o.ActionRunID = ""
+		o.Follow = true
+
+		require.ErrorContains(t, agentQueue(hclog.NewNullLogger(), o), "--action-run is required")
+	})
+
+	t.Run("follows the status logs of the run and exits with its code", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		expectQueued(api)
+
+		emitted := strfmt.DateTime(time.Now())
+		logs := []*models.HashicorpCloudWaypointV20241122StatusLog{
+			{EmittedAt: emitted, Log: "deploying"},
+			{EmittedAt: emitted, Log: "health check failed"},
+		}
+
+		var reads int
+
+		api.EXPECT().
+			WaypointServiceGetActionRun(mock.Anything, mock.Anything).
+			RunAndReturn(func(params *waypoint_service.WaypointServiceGetActionRunParams, _ runtime.ClientAuthInfoWriter, _ ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceGetActionRunOK, error) {
+				r.Equal("deploy", *params.ActionName)
+				r.Equal("2", *params.Sequence)
+
+				reads++
+
+				run := &models.HashicorpCloudWaypointV20241122ActionRun{
+					ID:       "run-1",
+					Sequence: "2",
+				}
+
+				switch reads {
+				case 1, 2:
+					run.StatusLog = logs[:1]
+				default:
+					run.StatusLog = logs
+					run.ResponseStatus = models.HashicorpCloudWaypointV20241122ActionRunResponseStatusERROR.Pointer()
+				}
+
+				return &waypoint_service.WaypointServiceGetActionRunOK{
+					Payload: &models.HashicorpCloudWaypointV20241122GetActionRunResponse{ActionRun: run},
+				}, nil
+			}).
+			Times(3)
+
+		io := iostreams.Test()
+		o := newOpts(io, api)
+		o.Follow = true
+
+		err := agentQueue(hclog.NewNullLogger(), o)
+
+		var exitErr *cmd.ExitCodeError
+		r.ErrorAs(err, &exitErr)
+		r.Equal(1, exitErr.Code)
+
+		// Each status log is printed once.
+		r.Equal(1, countLines(io.Error.String(), "deploying"))
+		r.Equal(1, countLines(io.Error.String(), "health check failed"))
+
+		r.Contains(io.Output.String(), "ERROR")
+		r.Contains(io.Output.String(), "health check failed")
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		expectQueued(api)
+
+		api.EXPECT().
+			WaypointServiceGetActionRun(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceGetActionRunOK{
+				Payload: &models.HashicorpCloudWaypointV20241122GetActionRunResponse{
+					ActionRun: &models.HashicorpCloudWaypointV20241122ActionRun{ID: "run-1", Sequence: "2"},
+				},
+			}, nil)
+
+		o := newOpts(iostreams.Test(), api)
+		o.Wait = true
+		o.Timeout = 50 * time.Millisecond
+
+		err := agentQueue(hclog.NewNullLogger(), o)
+
+		var exitErr *cmd.ExitCodeError
+		r.ErrorAs(err, &exitErr)
+		r.Equal(statusCodeTimeout, exitErr.Code)
+		r.ErrorContains(err, "timed out")
+	})
+
+	t.Run("reads one page of action runs at a time until the run is found", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		api.EXPECT().
+			WaypointServiceQueueAgentOperation(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceQueueAgentOperationOK{}, nil).
+			Once()
+
+		var pages []string
+
+		api.EXPECT().
+			WaypointServiceListActionRunsByNamespace(mock.Anything, mock.Anything).
+			RunAndReturn(func(params *waypoint_service.WaypointServiceListActionRunsByNamespaceParams, _ runtime.ClientAuthInfoWriter, _ ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceListActionRunsByNamespaceOK, error) {
+				resp := &models.HashicorpCloudWaypointV20241122ListActionRunsByNamespaceResponse{}
+
+				if params.PaginationNextPageToken == nil {
+					pages = append(pages, "")
+					resp.ActionRuns = []*models.HashicorpCloudWaypointV20241122ActionRun{{ID: "run-0", Sequence: "1"}}
+					resp.Pagination = &cloud.HashicorpCloudCommonPaginationResponse{NextPageToken: "page-2"}
+				} else {
+					pages = append(pages, *params.PaginationNextPageToken)
+					resp.ActionRuns = []*models.HashicorpCloudWaypointV20241122ActionRun{{
+						ID:              "run-1",
+						Sequence:        "2",
+						ActionConfigRef: &models.HashicorpCloudWaypointV20241122ActionCfgRef{Name: "deploy"},
+					}}
+				}
+
+				return &waypoint_service.WaypointServiceListActionRunsByNamespaceOK{Payload: resp}, nil
+			}).
+			Times(2)
+
+		api.EXPECT().
+			WaypointServiceGetActionRun(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceGetActionRunOK{
+				Payload: &models.HashicorpCloudWaypointV20241122GetActionRunResponse{
+					ActionRun: &models.HashicorpCloudWaypointV20241122ActionRun{
+						ID:             "run-1",
+						Sequence:       "2",
+						ResponseStatus: models.HashicorpCloudWaypointV20241122ActionRunResponseStatusSUCCESS.Pointer(),
+					},
+				},
+			}, nil).
+			Once()
+
+		o := newOpts(iostreams.Test(), api)
+		o.Wait = true
+
+		r.NoError(agentQueue(hclog.NewNullLogger(), o))
+		r.Equal([]string{"", "page-2"}, pages)
+	})
+
+	t.Run("waits for runs in a local queue", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+
+		q, err := openLocalQueue(dir)
+		r.NoError(err)
+
+		code := int32(3)
+		r.NoError(q.appendEvent("run-1", &localRunEvent{Event: "starting", Group: "prod"}))
+		r.NoError(q.appendEvent("run-1", &localRunEvent{Event: "status_log", Log: "deploying"}))
+		r.NoError(q.appendEvent("run-1", &localRunEvent{Event: "ending", Status: "bad config", Code: &code}))
+
+		io := iostreams.Test()
+		o := newOpts(io, nil)
+		o.LocalQueue = dir
+		o.Wait = true
+		o.Output.SetFormat(format.JSON)
+
+		err = agentQueue(hclog.NewNullLogger(), o)
+
+		var exitErr *cmd.ExitCodeError
+		r.ErrorAs(err, &exitErr)
+		r.Equal(3, exitErr.Code)
+
+		// Without --follow, status logs are only part of the output.
+		r.NotContains(io.Error.String(), "deploying")
+
+		var run queuedRun
+		r.NoError(json.Unmarshal(io.Output.Bytes(), &run))
+		r.Equal("run-1", run.ActionRunID)
+		r.Equal("ERROR", run.Result)
+		r.Equal("bad config", run.Status)
+		r.Len(run.StatusLogs, 1)
+	})
+}
+
+// countLines returns the number of lines of s that contain substr.
+func countLines(s, substr string) int {
+	var n int
+
+	for _, line := range strings.Split(s, "\n") {
+		if strings.Contains(line, substr) {
+			n++
+		}
+	}
+
+	return n
+}
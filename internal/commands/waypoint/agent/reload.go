@@ -0,0 +1,222 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+)
+
+// configWatchInterval is how often the agent checks its config file for
+// changes.
+const configWatchInterval = 5 * time.Second
+
+// watchConfig asks for the config to be reloaded when a value is received on
+// hup, or when the file at path is modified. It returns once ctx is cancelled.
+func watchConfig(ctx context.Context, log hclog.Logger, path string, interval time.Duration, hup <-chan os.Signal, reload chan<- struct{}) {
+	request := func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+
+	last, _ := os.Stat(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info("received SIGHUP, reloading agent config")
+			request()
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				// The file may be mid-replace. The next tick will try again.
+				continue
+			}
+
+			if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+				log.Info("agent config changed, reloading", "path", path)
+				request()
+			}
+
+			last = info
+		}
+	}
+}
+
+// reloadConfig parses and validates the config file again and, if it is
+// valid, uses it for operations started from now on. Operations already
+// running keep the config they started with. If the new config has any
+// problems, the current config is kept.
+//
+// Only the projects, groups, actions and per group concurrency are reloaded. Agent wide
+// settings such as concurrency, poll intervals and auth take effect on restart.
+func (r *agentRunner) reloadConfig(ctx context.Context) {
+	cfg, err := agent.ParseConfigFile(r.opts.ConfigPath)
+	if err != nil {
+		r.log.Error("unable to reload agent config, keeping the current config", "error", err)
+		return
+	}
+
+	if diags := cfg.Validate(); len(diags) > 0 {
+		for _, d := range diags {
+			r.log.Error("invalid action in agent config",
+				"group", d.Group,
+				"action", d.Action,
+				"position", d.Diagnostic.Subject,
+				"error", d.Diagnostic.Summary+": "+d.Diagnostic.Detail,
+			)
+		}
+
+		r.log.Error("agent config has errors, keeping the current config")
+		return
+	}
+
+	if err := cfg.LoadSecrets(); err != nil {
+		r.log.Error("unable to reload agent config, keeping the current config", "error", err)
+		return
+	}
+
+	targets, err := newAgentTargets(r.opts, cfg)
+	if err != nil {
+		r.log.Error("unable to reload agent config, keeping the current config", "error", err)
+		return
+	}
+
+	// Check the groups of each project that it wasn't already polled for.
+	for _, t := range targets {
+		var prev []string
+		if old := r.target(t.name); old != nil {
+			prev = old.groups
+		}
+
+		if added, _ := diffStrings(prev, t.groups); len(added) == 0 {
+			continue
+		}
+
+		log := targetLogger(r.log, t)
+
+		unknown, err := validateGroups(ctx, t, t.groups)
+		if err != nil {
+			log.Error("unable to validate agent groups, keeping the current config", "error", err)
+			return
+		}
+
+		if len(unknown) > 0 {
+			log.Error("agent config has unknown groups, keeping the current config", "groups", unknown)
+			return
+		}
+	}
+
+	old := r.exec.Config
+	added, removed := diffStrings(old.Groups(), cfg.Groups())
+
+	for _, g := range added {
+		r.log.Info("agent group added", "group", g, "actions", cfg.Actions(g))
+	}
+
+	for _, g := range removed {
+		r.log.Info("agent group removed", "group", g)
+	}
+
+	for _, g := range cfg.Groups() {
+		if !slices.Contains(old.Groups(), g) {
+			continue
+		}
+
+		addedActions, removedActions := diffStrings(old.Actions(g), cfg.Actions(g))
+
+		for _, a := range addedActions {
+			r.log.Info("agent action added", "group", g, "action", a)
+		}
+
+		for _, a := range removedActions {
+			r.log.Info("agent action removed", "group", g, "action", a)
+		}
+	}
+
+	addedProjects, removedProjects := diffStrings(targetNames(r.targets), targetNames(targets))
+
+	for _, p := range addedProjects {
+		r.log.Info("agent project added", "project", p)
+	}
+
+	for _, p := range removedProjects {
+		r.log.Info("agent project removed", "project", p)
+	}
+
+	if !sameAuth(old.Auth(), cfg.Auth()) {
+		r.log.Warn("agent auth changed, the new auth is used once the agent restarts")
+	}
+
+	r.exec = &agent.Executor{
+		Log:    r.log,
+		Config: cfg,
+	}
+	r.opts.Config = cfg
+	r.opts.Groups = cfg.Groups()
+	r.targets = targets
+	r.pool.SetLimits(cfg.GroupConcurrency())
+
+	r.log.Info("agent config reloaded", "groups", r.opts.Groups)
+}
+
+// validateGroups returns the groups that the project of target t does not
+// know about.
+func validateGroups(ctx context.Context, t *agentTarget, groups []string) ([]string, error) {
+	resp, err := t.client.WaypointServiceValidateAgentGroups(&waypoint_service.WaypointServiceValidateAgentGroupsParams{
+		NamespaceLocationOrganizationID: t.profile.OrganizationID,
+		NamespaceLocationProjectID:      t.profile.ProjectID,
+		Body: &models.HashicorpCloudWaypointV20241122WaypointServiceValidateAgentGroupsBody{
+			Groups: groups,
+		},
+		Context: ctx,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Payload.UnknownGroups, nil
+}
+
+// sameAuth reports whether a and b authenticate the agent the same way.
+func sameAuth(a, b *agent.Auth) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// diffStrings returns the values in next that are not in prev, and the values
+// in prev that are not in next.
+func diffStrings(prev, next []string) (added, removed []string) {
+	for _, s := range next {
+		if !slices.Contains(prev, s) {
+			added = append(added, s)
+		}
+	}
+
+	for _, s := range prev {
+		if !slices.Contains(next, s) {
+			removed = append(removed, s)
+		}
+	}
+
+	return added, removed
+}
@@ -0,0 +1,167 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	mock_waypoint_service "github.com/hashicorp/hcp/internal/pkg/api/mocks/github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+)
+
+const reloadTestConfig = `
+group "test" {
+  action "deploy" {
+    run {
+      command = "true"
+    }
+  }
+}
+`
+
+func TestAgentReload(t *testing.T) {
+	t.Parallel()
+
+	setup := func(t *testing.T) (*agentRunner, *mock_waypoint_service.MockClientService, string) {
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, reloadTestConfig)
+
+		path := filepath.Join(t.TempDir(), "agent.hcl")
+		require.NoError(t, os.WriteFile(path, []byte(reloadTestConfig), 0o600))
+		runner.opts.ConfigPath = path
+
+		return runner, api, path
+	}
+
+	t.Run("swaps in a new config", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		runner, api, path := setup(t)
+		old := runner.exec
+
+		api.EXPECT().
+			WaypointServiceValidateAgentGroups(mock.MatchedBy(func(p *waypoint_service.WaypointServiceValidateAgentGroupsParams) bool {
+				return len(p.Body.Groups) == 2
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceValidateAgentGroupsOK{
+				Payload: &models.HashicorpCloudWaypointV20241122ValidateAgentGroupsResponse{},
+			}, nil)
+
+		r.NoError(os.WriteFile(path, []byte(`
+group "test" {
+  action "rollback" {
+    run {
+      command = "true"
+    }
+  }
+}
+
+group "other" {
+  concurrency = 1
+
+  action "deploy" {
+    run {
+      command = "true"
+    }
+  }
+}
+`), 0o600))
+
+		runner.reloadConfig(context.Background())
+
+		r.NotSame(old, runner.exec)
+		r.Equal([]string{"other", "test"}, runner.opts.Groups)
+		r.Equal([]string{"rollback"}, runner.exec.Config.Actions("test"))
+		r.Equal([]string{"test"}, runner.pool.Available([]string{"test"}))
+	})
+
+	t.Run("keeps the current config if the new one is invalid", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		runner, _, path := setup(t)
+		old := runner.exec
+
+		r.NoError(os.WriteFile(path, []byte(`group "test" {`), 0o600))
+		runner.reloadConfig(context.Background())
+		r.Same(old, runner.exec)
+
+		r.NoError(os.WriteFile(path, []byte(`
+group "test" {
+  action "deploy" {
+    run {}
+  }
+}
+`), 0o600))
+		runner.reloadConfig(context.Background())
+		r.Same(old, runner.exec)
+		r.Equal([]string{"test"}, runner.opts.Groups)
+	})
+
+	t.Run("keeps the current config if a new group is unknown", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		runner, api, path := setup(t)
+		old := runner.exec
+
+		api.EXPECT().
+			WaypointServiceValidateAgentGroups(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceValidateAgentGroupsOK{
+				Payload: &models.HashicorpCloudWaypointV20241122ValidateAgentGroupsResponse{
+					UnknownGroups: []string{"other"},
+				},
+			}, nil)
+
+		r.NoError(os.WriteFile(path, []byte(reloadTestConfig+`
+group "other" {}
+`), 0o600))
+
+		runner.reloadConfig(context.Background())
+		r.Same(old, runner.exec)
+		r.Equal([]string{"test"}, runner.opts.Groups)
+	})
+}
+
+func TestWatchConfig(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "agent.hcl")
+	r.NoError(os.WriteFile(path, []byte(reloadTestConfig), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hup := make(chan os.Signal, 1)
+	reload := make(chan struct{}, 1)
+
+	go watchConfig(ctx, hclog.NewNullLogger(), path, 10*time.Millisecond, hup, reload)
+
+	hup <- os.Interrupt
+
+	select {
+	case <-reload:
+	case <-time.After(5 * time.Second):
+		r.Fail("no reload after signal")
+	}
+
+	r.NoError(os.WriteFile(path, []byte(reloadTestConfig+"\n# changed\n"), 0o600))
+
+	select {
+	case <-reload:
+	case <-time.After(5 * time.Second):
+		r.Fail("no reload after file change")
+	}
+}
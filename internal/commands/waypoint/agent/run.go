@@ -6,6 +6,10 @@ package agent
 import (
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -24,8 +28,15 @@ type RunOpts struct {
 	opts.WaypointOpts
 	Groups []string
 
-	ConfigPath string
-	Config     *agent.Config
+	ConfigPath      string
+	Config          *agent.Config
+	Concurrency     int
+	PollMinInterval time.Duration
+	PollMaxInterval time.Duration
+	ShutdownGrace   time.Duration
+	HealthAddr      string
+	HistoryPath     string
+	LocalQueue      string
 }
 
 func NewCmdRun(ctx *cmd.Context) *cmd.Command {
@@ -38,19 +49,101 @@ func NewCmdRun(ctx *cmd.Context) *cmd.Command {
 		ShortHelp: "Start the Waypoint Agent.",
 		LongHelp: heredoc.New(ctx.IO).Must(`
 		The {{ template "mdCodeOrBold" "hcp waypoint agent run" }} command executes a local Waypoint Agent.
+The agent polls HCP Waypoint for operations queued for the groups in its configuration file,
+runs them, and reports the status logs and result of each action run.
+
+The agent uses the CLI's login and polls the project of the active profile, unless the
+configuration declares its own credentials with an {{ template "mdCodeOrBold" "auth" }} block or the projects to poll
+with {{ template "mdCodeOrBold" "project" }} blocks.
+
+Use {{ template "mdCodeOrBold" "hcp waypoint agent validate" }} to check a configuration file, and
+{{ template "mdCodeOrBold" "hcp waypoint agent exec" }} to try one of its actions without HCP.
 		`),
+		Examples: []cmd.Example{
+			{
+				Preamble: "Run an agent and serve its health checks and metrics:",
+				Command:  "$ hcp waypoint agent run -c agent.hcl --health-addr 127.0.0.1:9102",
+			},
+			{
+				Preamble: "Run operations queued in a directory instead of from HCP, to test a configuration offline:",
+				Command:  "$ hcp waypoint agent run -c agent.hcl --local-queue ./queue",
+			},
+		},
 		Flags: cmd.Flags{
 			Local: []*cmd.Flag{
 				{
 					Name:         "config",
 					Shorthand:    "c",
 					DisplayValue: "PATH",
-					Description:  "Path to configuration file for agent.",
+					Description:  "Path to configuration file for agent. The file is reloaded on SIGHUP or when it changes, and kept as it was if the new one has errors.",
 					Value:        flagvalue.Simple("agent.hcl", &opts.ConfigPath),
 				},
+				{
+					Name:         "concurrency",
+					DisplayValue: "N",
+					Description:  "Maximum number of operations to run at once. Overrides the concurrency set in the configuration file.",
+					Value:        flagvalue.Simple(0, &opts.Concurrency),
+				},
+				{
+					Name:         "poll-min-interval",
+					DisplayValue: "DURATION",
+					Description:  "Shortest time to wait between polls when there is no work. Overrides the value set in the configuration file.",
+					Value:        flagvalue.Duration(0, &opts.PollMinInterval),
+				},
+				{
+					Name:         "poll-max-interval",
+					DisplayValue: "DURATION",
+					Description:  "Longest time to wait between polls when there is no work. Overrides the value set in the configuration file.",
+					Value:        flagvalue.Duration(0, &opts.PollMaxInterval),
+				},
+				{
+					Name:         "shutdown-grace",
+					DisplayValue: "DURATION",
+					Description:  "How long to wait for running operations to finish after a shutdown signal before cancelling them. A second signal cancels them immediately. Their on_failure and finally blocks still run once they are cancelled, for up to 5 minutes each. Defaults to 20s.",
+					Value:        flagvalue.Duration(0, &opts.ShutdownGrace),
+				},
+				{
+					Name:         "health-addr",
+					DisplayValue: "ADDR",
+					Description:  "Address to serve /healthz, /readyz and /metrics on, such as 127.0.0.1:9102. /readyz reports the agent is not ready while it can't poll HCP or refresh its credentials. Overrides the health_addr set in the configuration file. Disabled by default.",
+					Value:        flagvalue.Simple("", &opts.HealthAddr),
+				},
+				{
+					Name:         "history-path",
+					DisplayValue: "PATH",
+					Description:  "File to record the operations the agent runs in. Overrides the history_path set in the configuration file. Defaults to " + defaultHistoryPath + ", or " + localHistoryFile + " in the --local-queue directory. The action runs the agent has claimed are journaled next to it, and runs interrupted by a crash are ended with code 137 when the agent starts again.",
+					Value:        flagvalue.Simple("", &opts.HistoryPath),
+				},
+				{
+					Name:         "local-queue",
+					DisplayValue: "DIR",
+					Description:  "Run operations queued in this directory with \"hcp waypoint agent queue --local-queue\" instead of from HCP. Status logs and the start and end of action runs are written to files in the directory.",
+					Value:        flagvalue.Simple("", &opts.LocalQueue),
+				},
 			},
 		},
+		// The CLI's login is only needed if the config has no auth block.
+		NoAuthRequired: true,
 		PersistentPreRun: func(c *cmd.Command, args []string) error {
+			if opts.LocalQueue != "" {
+				return nil
+			}
+
+			// Errors in the config are reported when the agent starts.
+			cfg, err := agent.ParseConfigFile(opts.ConfigPath)
+
+			// Agents without an auth block use the CLI's credentials.
+			if err != nil || cfg.Auth() == nil {
+				if err := requireLogin(ctx); err != nil {
+					return fmt.Errorf("%w, or add an auth block to the agent configuration", err)
+				}
+			}
+
+			// Agents that declare their projects don't poll the profile's.
+			if err == nil && len(cfg.Projects()) > 0 {
+				return nil
+			}
+
 			return cmd.RequireOrgAndProject(ctx)
 		},
 		RunF: func(c *cmd.Command, args []string) error {
@@ -61,8 +154,6 @@ func NewCmdRun(ctx *cmd.Context) *cmd.Command {
 	return cmd
 }
 
-var agentRunDuration = 60 * time.Second
-
 func agentRun(log hclog.Logger, opts *RunOpts) error {
 	// Only set level to info if not in debug mode
 	if !log.IsDebug() {
@@ -75,99 +166,458 @@ func agentRun(log hclog.Logger, opts *RunOpts) error {
 		return err
 	}
 
+	if err := cfg.LoadSecrets(); err != nil {
+		return err
+	}
+
+	opts.Config = cfg
 	opts.Groups = cfg.Groups()
 
 	ctx := opts.Ctx
 
+	var localDir string
+
+	if opts.LocalQueue != "" {
+		q, err := openLocalQueue(opts.LocalQueue)
+		if err != nil {
+			return err
+		}
+
+		opts.WS2024Client = q
+		localDir = q.dir
+
+		log.Info("running operations from local queue, not HCP", "dir", q.dir)
+	}
+
+	metrics := newAgentMetrics()
+
+	if a := cfg.Auth(); a != nil && opts.LocalQueue == "" {
+		client, err := newAuthClient(log, a, opts.Profile.GetGeography(), metrics)
+		if err != nil {
+			return err
+		}
+
+		opts.WS2024Client = client
+
+		log.Info("authenticating with the auth block of the agent config", "method", a.Method())
+	}
+
+	targets, err := newAgentTargets(opts, cfg)
+	if err != nil {
+		return err
+	}
+
 	// check the groups!
-	resp2, err := opts.WS2024Client.WaypointServiceValidateAgentGroups(&waypoint_service.WaypointServiceValidateAgentGroupsParams{
-		NamespaceLocationOrganizationID: opts.Profile.OrganizationID,
-		NamespaceLocationProjectID:      opts.Profile.ProjectID,
-		Body: &models.HashicorpCloudWaypointV20241122WaypointServiceValidateAgentGroupsBody{
-			Groups: opts.Groups,
-		},
-		Context: opts.Ctx,
-	}, nil)
+	for _, t := range targets {
+		unknown, err := validateGroups(ctx, t, t.groups)
+		if err != nil {
+			if t.name != "" {
+				return errors.Wrapf(err, "Error validating agent group names of project %q", t.name)
+			}
+
+			return errors.Wrapf(err, "Error validating agent group names")
+		}
+
+		if len(unknown) > 0 {
+			if t.name != "" {
+				_, _ = fmt.Fprintf(opts.IO.Err(), "Unknown agent groups detected in project %q:\n", t.name)
+			} else {
+				_, _ = fmt.Fprintf(opts.IO.Err(), "Unknown agent groups detected:\n")
+			}
+
+			for _, g := range unknown {
+				_, _ = fmt.Fprintf(opts.IO.Err(), "  %s\n ", g)
+			}
+			return nil
+		}
+	}
+
+	concurrency := cfg.Concurrency()
+	if opts.Concurrency > 0 {
+		concurrency = opts.Concurrency
+	}
+
+	minInterval, maxInterval := cfg.PollInterval()
+	if opts.PollMinInterval > 0 {
+		minInterval = opts.PollMinInterval
+	}
+	if opts.PollMaxInterval > 0 {
+		maxInterval = opts.PollMaxInterval
+	}
+
+	grace := cfg.ShutdownGrace()
+	if opts.ShutdownGrace > 0 {
+		grace = opts.ShutdownGrace
+	}
+	if grace <= 0 {
+		grace = defaultShutdownGrace
+	}
+
+	// The first signal cancels opts.Ctx, which starts draining running
+	// operations. A second signal skips the rest of the grace period.
+	force := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	go func() {
+		for received := 0; ; {
+			select {
+			case <-stop:
+				return
+			case <-sigCh:
+				received++
+				if received == 2 {
+					close(force)
+					return
+				}
+			}
+		}
+	}()
+
+	healthAddr := cfg.HealthAddr()
+	if opts.HealthAddr != "" {
+		healthAddr = opts.HealthAddr
+	}
+
+	// Runs from a local queue are recorded in its directory, so they never
+	// send the pending reports or end the orphaned runs of an agent polling
+	// HCP through the local queue.
+	historyPath := opts.HistoryPath
+	switch {
+	case historyPath != "":
+	case localDir != "":
+		historyPath = filepath.Join(localDir, localHistoryFile)
+	case cfg.HistoryPath() != "":
+		historyPath = cfg.HistoryPath()
+	default:
+		historyPath = defaultHistoryPath
+	}
+
+	history, err := openHistory(historyPath)
+	if err != nil {
+		return err
+	}
+
+	journal, err := openJournal(journalPath(history.path))
 	if err != nil {
-		return errors.Wrapf(err, "Error validating agent group names")
+		return err
 	}
 
-	if len(resp2.Payload.UnknownGroups) > 0 {
-		_, _ = fmt.Fprintf(opts.IO.Err(), "Unknown agent groups detected:\n")
+	backoff := newPollBackoff(minInterval, maxInterval)
 
-		for _, g := range resp2.Payload.UnknownGroups {
-			_, _ = fmt.Fprintf(opts.IO.Err(), "  %s\n ", g)
+	if healthAddr != "" {
+		stopHealth, err := serveHealth(log, healthAddr, &healthHandler{
+			metrics:     metrics,
+			readyWithin: readyPollIntervals * backoff.max,
+		})
+		if err != nil {
+			return err
 		}
-		return nil
+
+		defer stopHealth()
 	}
 
-	retry := time.NewTimer(agentRunDuration)
-	defer retry.Stop()
+	// The config is reloaded on SIGHUP or when the file changes.
+	reload := make(chan struct{}, 1)
 
-	log.Info("Waypoint agent initialized",
-		"hcp-org", opts.Profile.OrganizationID,
-		"hcp-project", opts.Profile.ProjectID,
-		"groups", opts.Groups,
-	)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go watchConfig(ctx, log, opts.ConfigPath, configWatchInterval, hup, reload)
 
-	exec := &agent.Executor{
-		Log:    log,
-		Config: cfg,
+	for _, t := range targets {
+		targetLogger(log, t).Info("Waypoint agent initialized",
+			"hcp-org", t.profile.OrganizationID,
+			"hcp-project", t.profile.ProjectID,
+			"groups", t.groups,
+			"concurrency", concurrency,
+		)
+	}
+
+	r := &agentRunner{
+		log:  log,
+		opts: opts,
+		exec: &agent.Executor{
+			Log:    log,
+			Config: cfg,
+		},
+		targets: targets,
+		pool:    newWorkerPool(concurrency, cfg.GroupConcurrency()),
+		backoff: backoff,
+		metrics: metrics,
+		history: history,
+		journal: journal,
+		grace:   grace,
+		force:   force,
+		reload:  reload,
 	}
 
+	// Send any ending reports that failed before the agent last stopped.
+	r.retryPendingReports(ctx)
+
+	// End the action runs that were interrupted when the agent last stopped.
+	r.recoverOrphans(ctx)
+
+	return r.Run(ctx)
+}
+
+const (
+	// defaultShutdownGrace is how long running operations have to finish once
+	// the agent is asked to shut down.
+	defaultShutdownGrace = 20 * time.Second
+
+	// agentReportTimeout bounds the calls made to report an action run has
+	// ended, which use a fresh context so they succeed during shutdown.
+	agentReportTimeout = 15 * time.Second
+
+	// statusCodeShutdown is reported for operations cancelled because the
+	// agent shut down. It matches the exit code of a process killed by SIGTERM.
+	statusCodeShutdown = 143
+)
+
+// agentRunner polls HCP for operations and dispatches them to a worker pool.
+type agentRunner struct {
+	log  hclog.Logger
+	opts *RunOpts
+	exec *agent.Executor
+
+	// targets are the projects polled for operations. Each round of polling
+	// starts with the target after the one the previous round started with.
+	targets  []*agentTarget
+	rotation int
+
+	pool    *workerPool
+	backoff *pollBackoff
+	metrics *agentMetrics
+
+	// history records the operations the agent runs. It may be nil.
+	history *historyStore
+
+	// journal records the action runs the agent has claimed and finished, so
+	// none is run twice. It may be nil.
+	journal *runJournal
+
+	// grace is how long running operations have to finish once ctx passed to
+	// Run is cancelled. If force is closed, they are cancelled right away.
+	grace time.Duration
+	force <-chan struct{}
+
+	// reload receives a value when the config should be reloaded. Reloading
+	// happens between polls, so exec and targets are only ever accessed
+	// from the polling goroutine.
+	reload <-chan struct{}
+}
+
+// Run polls for operations until ctx is cancelled, then drains any running
+// operations before returning.
+func (r *agentRunner) Run(ctx context.Context) error {
+	// Operations run on their own context so that shutting down stops polling
+	// without killing work that is already running.
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
 	for {
-		opCfg, err := opts.WS2024Client.WaypointServiceRetrieveAgentOperation(&waypoint_service.WaypointServiceRetrieveAgentOperationParams{
-			Body: &models.HashicorpCloudWaypointV20241122WaypointServiceRetrieveAgentOperationBody{
-				Groups: opts.Groups,
-			},
-			NamespaceLocationOrganizationID: opts.Profile.OrganizationID,
-			NamespaceLocationProjectID:      opts.Profile.ProjectID,
-			Context:                         ctx,
-		}, nil)
+		select {
+		case <-r.reload:
+			r.reloadConfig(ctx)
+		default:
+		}
 
-		if err != nil {
-			log.Error("error reading agent operation", "error", err)
-		} else if ao := opCfg.Payload.Operation; ao != nil {
-			runOp(log, ctx, opts, ao, exec)
+		// Only ask for work from groups that have room to run it. If every
+		// worker is busy, wait for one to finish before polling again.
+		if !r.hasAvailable() {
+			r.metrics.SetBusy(true)
+
+			select {
+			case <-ctx.Done():
+				r.metrics.SetBusy(false)
+				return r.drain(cancelWork)
+			case <-r.reload:
+				r.reloadConfig(ctx)
+			case <-r.pool.Freed():
+			}
+
+			r.metrics.SetBusy(false)
+
+			continue
+		}
+
+		found, err := r.pollRound(ctx, workCtx)
+
+		wait := r.backoff.Next(found, err)
+
+		if wait == 0 {
+			if ctx.Err() != nil {
+				return r.drain(cancelWork)
+			}
+
+			continue
 		}
 
-		retry.Reset(agentRunDuration)
+		r.log.Trace("waiting to poll for operations", "wait", wait)
+
+		timer.Reset(wait)
 
 		select {
-		case <-opts.Ctx.Done():
-			return nil
-		case <-retry.C:
+		case <-ctx.Done():
+			return r.drain(cancelWork)
+		case <-r.reload:
+			// Reload right away, then poll with the new groups.
+			r.reloadConfig(ctx)
+			timer.Stop()
+		case <-timer.C:
 			// ok
 		}
 	}
 }
 
-func runOp(
-	log hclog.Logger,
+// drain waits for running operations to finish. Once the grace period expires
+// they are cancelled, and the ending of each is still reported. If the drain
+// is forced, drain returns without waiting for those reports.
+func (r *agentRunner) drain(cancelWork context.CancelFunc) error {
+	done := make(chan struct{})
+	go func() {
+		r.pool.Wait()
+		close(done)
+	}()
+
+	if active := r.pool.Active(); active > 0 {
+		r.log.Info("agent shutting down, waiting for running operations", "operations", active, "grace", r.grace)
+	}
+
+	timer := time.NewTimer(r.grace)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		r.log.Warn("shutdown grace period expired, cancelling running operations")
+	case <-r.force:
+		r.log.Warn("shutdown forced, cancelling running operations")
+		cancelWork()
+		return nil
+	}
+
+	cancelWork()
+
+	select {
+	case <-done:
+	case <-r.force:
+	}
+
+	return nil
+}
+
+// poll retrieves at most one operation for groups from target t and starts it
+// on the pool, running it with workCtx. It reports whether an operation was
+// found.
+func (r *agentRunner) poll(ctx, workCtx context.Context, t *agentTarget, groups []string) (bool, error) {
+	opCfg, err := t.client.WaypointServiceRetrieveAgentOperation(&waypoint_service.WaypointServiceRetrieveAgentOperationParams{
+		Body: &models.HashicorpCloudWaypointV20241122WaypointServiceRetrieveAgentOperationBody{
+			Groups: groups,
+		},
+		NamespaceLocationOrganizationID: t.profile.OrganizationID,
+		NamespaceLocationProjectID:      t.profile.ProjectID,
+		Context:                         ctx,
+	}, nil)
+
+	r.metrics.ObservePoll(err)
+
+	if err != nil {
+		return false, err
+	}
+
+	ao := opCfg.Payload.Operation
+	if ao == nil {
+		return false, nil
+	}
+
+	// Capture the executor now, since a reload may replace it while the
+	// operation runs.
+	exec := r.exec
+
+	// Runs that can't be claimed aren't counted as work found, so HCP handing
+	// one out again doesn't stop the agent backing off.
+	if !r.claim(ctx, t, ao) {
+		return false, nil
+	}
+
+	r.pool.Go(ao.Group, func() {
+		r.metrics.OperationStarted()
+		start := time.Now()
+
+		code := r.runOp(workCtx, t, ao, exec)
+
+		r.metrics.OperationFinished(ao.Group, ao.ID, code, time.Since(start))
+	})
+
+	return true, nil
+}
+
+// runOp runs an operation retrieved from target t, reporting to t's project
+// when its action run starts and ends, and returns its status code. The
+// operation is recorded in the history once it has ended.
+func (r *agentRunner) runOp(
 	ctx context.Context,
-	opts *RunOpts,
+	t *agentTarget,
 	ao *models.HashicorpCloudWaypointV20241122AgentOperation,
 	exec *agent.Executor,
-) {
+) (statusCode int) {
 	var (
 		status      string
-		statusCode  int
 		sequenceNum string
 	)
 
-	log = log.With("group", ao.Group, "operation", ao.ID, "action-run-id", ao.ActionRunID)
+	log := targetLogger(r.log, t).With("group", ao.Group, "operation", ao.ID, "action-run-id", ao.ActionRunID)
+
+	entry := &historyEntry{
+		Project:     t.name,
+		Group:       ao.Group,
+		Action:      ao.ID,
+		ActionRunID: ao.ActionRunID,
+		StartedAt:   time.Now(),
+	}
+
+	inputs, err := exec.Config.RedactedBody(ao.Group, ao.ID, ao.Body)
+	if err != nil {
+		log.Debug("unable to record operation inputs", "error", err)
+	}
+
+	entry.Inputs = inputs
+
+	// Deferred first so that it runs after the ending has been reported.
+	defer func() {
+		if entry.EndedAt.IsZero() {
+			entry.EndedAt = time.Now()
+		}
+
+		entry.Status = status
+		entry.Code = statusCode
+
+		r.record(log, entry)
+		r.finish(log, t, ao.ActionRunID)
+	}()
 
 	if ao.ActionRunID != "" {
 		log.Info("reporting action run starting")
 
-		resp, err := opts.WS2024Client.WaypointServiceStartingAction(&waypoint_service.WaypointServiceStartingActionParams{
+		resp, err := t.client.WaypointServiceStartingAction(&waypoint_service.WaypointServiceStartingActionParams{
 			Body: &models.HashicorpCloudWaypointV20241122WaypointServiceStartingActionBody{
 				ActionRunID: ao.ActionRunID,
 				GroupName:   ao.Group,
 			},
 			Context:                         ctx,
-			NamespaceLocationOrganizationID: opts.Profile.OrganizationID,
-			NamespaceLocationProjectID:      opts.Profile.ProjectID,
+			NamespaceLocationOrganizationID: t.profile.OrganizationID,
+			NamespaceLocationProjectID:      t.profile.ProjectID,
 		}, nil)
 
 		if err != nil {
@@ -178,21 +628,15 @@ func runOp(
 
 			}
 			defer func() {
-				log.Info("reporting action run ended", "status", status, "status-code", statusCode, "action-run-sequence", sequenceNum)
+				entry.EndedAt = time.Now()
+				entry.ActionRunID = resp.Payload.ActionRunID
 
-				_, err = opts.WS2024Client.WaypointServiceEndingAction(&waypoint_service.WaypointServiceEndingActionParams{
-					Body: &models.HashicorpCloudWaypointV20241122WaypointServiceEndingActionBody{
-						ActionRunID: resp.Payload.ActionRunID,
-						FinalStatus: status,
-						StatusCode:  int32(statusCode),
-					},
-					Context:                         ctx,
-					NamespaceLocationOrganizationID: opts.Profile.OrganizationID,
-					NamespaceLocationProjectID:      opts.Profile.ProjectID,
-				}, nil)
+				log.Info("reporting action run ended", "status", status, "status-code", statusCode, "action-run-sequence", sequenceNum)
 
+				err := reportEnding(ctx, t, resp.Payload.ActionRunID, status, statusCode)
 				if err != nil {
 					log.Error("unable to send ending action", "error", err)
+					entry.ReportPending = true
 				}
 			}()
 		}
@@ -215,7 +659,17 @@ func runOp(
 		return
 	}
 
-	opStat, err := exec.Execute(ctx, opts.WS2024Client, opts.Profile, ao)
+	// Only an operation that ended because it was cancelled is reported as
+	// shut down, one that finished as shutdown began keeps its own result.
+	opStat, err := exec.Execute(ctx, t.client, t.profile, ao)
+	if errors.Is(err, context.Canceled) {
+		status = "agent shutting down: operation cancelled"
+		statusCode = statusCodeShutdown
+
+		log.Warn("operation cancelled by agent shutdown")
+		return
+	}
+
 	if err != nil {
 		status = "error execution operation: " + err.Error()
 		statusCode = 2
@@ -226,6 +680,30 @@ func runOp(
 
 	status = opStat.Status
 	statusCode = opStat.Code
+	entry.Output = opStat.Output
 
 	log.Info("finished operation")
+
+	return statusCode
+}
+
+// reportEnding reports to the project of target t that an action run has
+// ended. It uses a fresh context so the run is still ended when ctx was
+// cancelled by the agent shutting down.
+func reportEnding(ctx context.Context, t *agentTarget, actionRunID, status string, statusCode int) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), agentReportTimeout)
+	defer cancel()
+
+	_, err := t.client.WaypointServiceEndingAction(&waypoint_service.WaypointServiceEndingActionParams{
+		Body: &models.HashicorpCloudWaypointV20241122WaypointServiceEndingActionBody{
+			ActionRunID: actionRunID,
+			FinalStatus: status,
+			StatusCode:  int32(statusCode),
+		},
+		Context:                         ctx,
+		NamespaceLocationOrganizationID: t.profile.OrganizationID,
+		NamespaceLocationProjectID:      t.profile.ProjectID,
+	}, nil)
+
+	return err
 }
@@ -0,0 +1,433 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	mock_waypoint_service "github.com/hashicorp/hcp/internal/pkg/api/mocks/github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+)
+
+func testRunner(t *testing.T, api waypoint_service.ClientService, config string) *agentRunner {
+	t.Helper()
+
+	cfg, err := agent.ParseConfig(config)
+	require.NoError(t, err)
+
+	log := hclog.New(&hclog.LoggerOptions{
+		Name:  "agent-run",
+		Level: hclog.Trace,
+	})
+
+	opts := &RunOpts{
+		WaypointOpts: opts.WaypointOpts{
+			WS2024Client: api,
+			Profile: &profile.Profile{
+				OrganizationID: "test-org-id",
+				ProjectID:      "test-proj-id",
+			},
+		},
+		Groups: cfg.Groups(),
+	}
+
+	targets, err := newAgentTargets(opts, cfg)
+	require.NoError(t, err)
+
+	return &agentRunner{
+		log:  log,
+		opts: opts,
+		exec: &agent.Executor{
+			Log:    log,
+			Config: cfg,
+		},
+		targets: targets,
+		pool:    newWorkerPool(cfg.Concurrency(), cfg.GroupConcurrency()),
+		backoff: newPollBackoff(time.Hour, time.Hour),
+		metrics: newAgentMetrics(),
+		grace:   10 * time.Second,
+	}
+}
+
+// expectActionRun sets up api to retrieve a single operation for an action
+// run and then cancel ctx, and to accept reports that the run started.
+func expectActionRun(api *mock_waypoint_service.MockClientService, ao *models.HashicorpCloudWaypointV20241122AgentOperation, cancel context.CancelFunc) {
+	api.EXPECT().
+		WaypointServiceRetrieveAgentOperation(mock.Anything, mock.Anything).
+		Return(retrieved(ao), nil).
+		Once()
+
+	api.EXPECT().
+		WaypointServiceRetrieveAgentOperation(mock.Anything, mock.Anything).
+		RunAndReturn(func(*waypoint_service.WaypointServiceRetrieveAgentOperationParams, runtime.ClientAuthInfoWriter, ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceRetrieveAgentOperationOK, error) {
+			cancel()
+			return retrieved(nil), nil
+		}).
+		Once()
+
+	api.EXPECT().
+		WaypointServiceStartingAction(mock.Anything, mock.Anything).
+		Return(&waypoint_service.WaypointServiceStartingActionOK{
+			Payload: &models.HashicorpCloudWaypointV20241122StartingActionResponse{
+				ActionRunID: ao.ActionRunID,
+			},
+		}, nil).
+		Once()
+}
+
+func retrieved(ao *models.HashicorpCloudWaypointV20241122AgentOperation) *waypoint_service.WaypointServiceRetrieveAgentOperationOK {
+	return &waypoint_service.WaypointServiceRetrieveAgentOperationOK{
+		Payload: &models.HashicorpCloudWaypointV20241122RetrieveAgentOperationResponse{
+			Operation: ao,
+		},
+	}
+}
+
+func TestAgentRunner(t *testing.T) {
+	t.Parallel()
+
+	t.Run("polls again immediately after finding work", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `group "test" {}`)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		api.EXPECT().
+			WaypointServiceRetrieveAgentOperation(mock.Anything, mock.Anything).
+			Return(retrieved(&models.HashicorpCloudWaypointV20241122AgentOperation{
+				Group: "test",
+				ID:    "missing",
+			}), nil).
+			Once()
+
+		// The backoff is an hour, so only reaching this call before the
+		// timeout shows the runner didn't wait.
+		api.EXPECT().
+			WaypointServiceRetrieveAgentOperation(mock.Anything, mock.Anything).
+			RunAndReturn(func(*waypoint_service.WaypointServiceRetrieveAgentOperationParams, runtime.ClientAuthInfoWriter, ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceRetrieveAgentOperationOK, error) {
+				cancel()
+				return retrieved(nil), nil
+			}).
+			Once()
+
+		r.NoError(runner.Run(ctx))
+		r.ErrorIs(ctx.Err(), context.Canceled)
+
+		runner.pool.Wait()
+		r.EqualValues(2, runner.metrics.polls)
+		r.EqualValues(1, runner.metrics.operations[operationResultKey{operationKey{"test", "missing"}, 127}])
+	})
+
+	t.Run("only polls groups with capacity", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `
+		concurrency = 2
+
+		group "busy" {
+			concurrency = 1
+		}
+
+		group "idle" {}
+`)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		runner.pool.Go("busy", func() { <-ctx.Done() })
+
+		api.EXPECT().
+			WaypointServiceRetrieveAgentOperation(mock.MatchedBy(func(params *waypoint_service.WaypointServiceRetrieveAgentOperationParams) bool {
+				return len(params.Body.Groups) == 1 && params.Body.Groups[0] == "idle"
+			}), mock.Anything).
+			RunAndReturn(func(*waypoint_service.WaypointServiceRetrieveAgentOperationParams, runtime.ClientAuthInfoWriter, ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceRetrieveAgentOperationOK, error) {
+				cancel()
+				return retrieved(nil), nil
+			}).
+			Once()
+
+		r.NoError(runner.Run(ctx))
+	})
+
+	t.Run("backs off when retrieving fails", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `group "test" {}`)
+
+		var waits []time.Duration
+		runner.backoff = newPollBackoff(time.Millisecond, time.Millisecond)
+		runner.backoff.jitter = func(d time.Duration) time.Duration {
+			waits = append(waits, d)
+			return d
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		calls := 0
+		api.EXPECT().
+			WaypointServiceRetrieveAgentOperation(mock.Anything, mock.Anything).
+			RunAndReturn(func(*waypoint_service.WaypointServiceRetrieveAgentOperationParams, runtime.ClientAuthInfoWriter, ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceRetrieveAgentOperationOK, error) {
+				calls++
+				if calls == 3 {
+					cancel()
+				}
+				return nil, errors.New("unavailable")
+			}).
+			Times(3)
+
+		r.NoError(runner.Run(ctx))
+		r.Equal([]time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}, waits)
+	})
+}
+
+func TestAgentRunnerDrain(t *testing.T) {
+	t.Parallel()
+
+	t.Run("waits for running operations to finish on shutdown", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `
+		group "test" {
+			action "launch" {
+				run {
+					command = ["sleep", "0.2"]
+				}
+			}
+		}
+`)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		expectActionRun(api, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "run-1",
+		}, cancel)
+
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-1" &&
+					params.Body.StatusCode == 0 &&
+					params.Context.Err() == nil
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceEndingActionOK{}, nil).
+			Once()
+
+		r.NoError(runner.Run(ctx))
+		r.Equal(0, runner.pool.Active())
+	})
+
+	t.Run("cancels operations once the grace period expires", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `
+		concurrency = 2
+
+		group "test" {
+			action "launch" {
+				run {
+					command = ["sleep", "30"]
+				}
+			}
+		}
+`)
+		runner.grace = 50 * time.Millisecond
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		expectActionRun(api, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "run-1",
+		}, cancel)
+
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-1" &&
+					params.Body.StatusCode == statusCodeShutdown &&
+					params.Body.FinalStatus == "agent shutting down: operation cancelled" &&
+					params.Context.Err() == nil
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceEndingActionOK{}, nil).
+			Once()
+
+		start := time.Now()
+		r.NoError(runner.Run(ctx))
+		r.Less(time.Since(start), 5*time.Second)
+	})
+
+	t.Run("keeps the result of an operation that finished as shutdown began", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `
+		group "test" {
+			action "launch" {
+				status {
+					message = "deployed"
+				}
+			}
+		}
+`)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// The agent starts shutting down once the run has started, but the
+		// status operation doesn't stop for it.
+		api.EXPECT().
+			WaypointServiceStartingAction(mock.Anything, mock.Anything).
+			RunAndReturn(func(*waypoint_service.WaypointServiceStartingActionParams, runtime.ClientAuthInfoWriter, ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceStartingActionOK, error) {
+				cancel()
+
+				return &waypoint_service.WaypointServiceStartingActionOK{
+					Payload: &models.HashicorpCloudWaypointV20241122StartingActionResponse{
+						ActionRunID: "run-1",
+					},
+				}, nil
+			}).
+			Once()
+
+		api.EXPECT().
+			WaypointServiceSendStatusLog2(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceSendStatusLog2OK{}, nil).
+			Once()
+
+		api.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.Body.ActionRunID == "run-1" && params.Body.StatusCode == 0
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceEndingActionOK{}, nil).
+			Once()
+
+		code := runner.runOp(ctx, runner.targets[0], &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "run-1",
+		}, runner.exec)
+		r.Equal(0, code)
+	})
+
+	t.Run("a forced shutdown does not wait for operations", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `group "test" {}`)
+
+		force := make(chan struct{})
+		close(force)
+		runner.force = force
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		block := make(chan struct{})
+		defer close(block)
+		runner.pool.Go("test", func() { <-block })
+
+		r.NoError(runner.Run(ctx))
+		r.Equal(1, runner.pool.Active())
+	})
+}
+
+func TestPollBackoff(t *testing.T) {
+	t.Parallel()
+
+	noJitter := func(d time.Duration) time.Duration { return d }
+
+	t.Run("doubles up to the max while idle", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		b := newPollBackoff(time.Second, 5*time.Second)
+		b.jitter = noJitter
+
+		r.Equal(time.Second, b.Next(false, nil))
+		r.Equal(2*time.Second, b.Next(false, nil))
+		r.Equal(4*time.Second, b.Next(false, nil))
+		r.Equal(5*time.Second, b.Next(false, nil))
+		r.Equal(5*time.Second, b.Next(false, nil))
+	})
+
+	t.Run("resets when work is found", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		b := newPollBackoff(time.Second, 5*time.Second)
+		b.jitter = noJitter
+
+		r.Equal(time.Second, b.Next(false, nil))
+		r.Equal(2*time.Second, b.Next(false, nil))
+		r.Equal(time.Duration(0), b.Next(true, nil))
+		r.Equal(time.Second, b.Next(false, nil))
+	})
+
+	t.Run("backs off errors separately up to the error ceiling", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		b := newPollBackoff(time.Minute, 2*time.Minute)
+		b.jitter = noJitter
+
+		err := errors.New("unavailable")
+
+		r.Equal(time.Minute, b.Next(false, nil))
+		r.Equal(time.Minute, b.Next(false, err))
+		r.Equal(2*time.Minute, b.Next(false, err))
+		r.Equal(4*time.Minute, b.Next(false, err))
+		r.Equal(5*time.Minute, b.Next(false, err))
+		r.Equal(2*time.Minute, b.Next(false, nil))
+	})
+
+	t.Run("jitter stays within the interval", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		for range 100 {
+			d := equalJitter(time.Second)
+			r.GreaterOrEqual(d, 500*time.Millisecond)
+			r.Less(d, time.Second)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	hcpconf "github.com/hashicorp/hcp-sdk-go/config"
+	"github.com/hashicorp/hcp-sdk-go/httpclient"
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+	"github.com/hashicorp/hcp/version"
+)
+
+// agentTarget is an HCP project the agent polls for operations, along with
+// the client and profile used to reach it.
+type agentTarget struct {
+	// name is the name of the project block, or empty for the project of the
+	// agent's profile.
+	name string
+
+	client  waypoint_service.ClientService
+	profile *profile.Profile
+	groups  []string
+}
+
+// newAgentTargets returns the projects the agent polls. If the config
+// declares no projects, that is the project of the agent's profile, polled
+// for every group. Projects with a credential file get a client of their own,
+// unless the agent runs from a local queue.
+func newAgentTargets(opts *RunOpts, cfg *agent.Config) ([]*agentTarget, error) {
+	projects := cfg.Projects()
+	if len(projects) == 0 {
+		return []*agentTarget{{
+			client:  opts.WS2024Client,
+			profile: opts.Profile,
+			groups:  cfg.Groups(),
+		}}, nil
+	}
+
+	targets := make([]*agentTarget, 0, len(projects))
+
+	for _, p := range projects {
+		prof := &profile.Profile{}
+		if opts.Profile != nil {
+			*prof = *opts.Profile
+		}
+
+		prof.OrganizationID = p.OrganizationID
+		prof.ProjectID = p.ProjectID
+
+		client := opts.WS2024Client
+		if p.CredFile != "" && opts.LocalQueue == "" {
+			var err error
+
+			client, err = newProjectClient(p.CredFile, prof.GetGeography())
+			if err != nil {
+				return nil, fmt.Errorf("project %q: %w", p.Name, err)
+			}
+		}
+
+		targets = append(targets, &agentTarget{
+			name:    p.Name,
+			client:  client,
+			profile: prof,
+			groups:  p.Groups,
+		})
+	}
+
+	return targets, nil
+}
+
+// newProjectClient returns a Waypoint client authenticated with the
+// credential file at path.
+func newProjectClient(path, geography string) (waypoint_service.ClientService, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, fmt.Errorf("error expanding credential file path: %w", err)
+	}
+
+	options := []hcpconf.HCPConfigOption{
+		hcpconf.WithoutLogging(),
+		hcpconf.WithCredentialFilePath(path),
+		hcpconf.WithoutBrowserLogin(),
+	}
+	if geography != "" {
+		options = append(options, hcpconf.WithGeography(geography))
+	}
+
+	hcpCfg, err := hcpconf.NewHCPConfig(options...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load credential file %s: %w", path, err)
+	}
+
+	return newWaypointClient(hcpCfg)
+}
+
+// newWaypointClient returns a Waypoint client that authenticates with hcpCfg.
+func newWaypointClient(hcpCfg hcpconf.HCPConfig) (waypoint_service.ClientService, error) {
+	hcpClient, err := httpclient.New(httpclient.Config{
+		HCPConfig:     hcpCfg,
+		SourceChannel: version.GetSourceChannel(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HCP client: %w", err)
+	}
+
+	return waypoint_service.New(hcpClient, nil), nil
+}
+
+// nextTargets returns the targets to poll in the next round, starting after
+// the target the previous round started with, so that no project is always
+// polled first.
+func (r *agentRunner) nextTargets() []*agentTarget {
+	n := len(r.targets)
+	if n == 0 {
+		return nil
+	}
+
+	start := r.rotation % n
+	r.rotation = start + 1
+
+	return append(r.targets[start:n:n], r.targets[:start]...)
+}
+
+// target returns the target named name, or nil if there is none.
+func (r *agentRunner) target(name string) *agentTarget {
+	for _, t := range r.targets {
+		if t.name == name {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// targetNames returns the names of the project blocks of targets.
+func targetNames(targets []*agentTarget) []string {
+	var names []string
+
+	for _, t := range targets {
+		if t.name != "" {
+			names = append(names, t.name)
+		}
+	}
+
+	return names
+}
+
+// targetLogger returns log with the name of t's project, if it has one.
+func targetLogger(log hclog.Logger, t *agentTarget) hclog.Logger {
+	if t.name == "" {
+		return log
+	}
+
+	return log.With("project", t.name)
+}
+
+// hasAvailable reports whether any target has a group with room to run an
+// operation.
+func (r *agentRunner) hasAvailable() bool {
+	return slices.ContainsFunc(r.targets, func(t *agentTarget) bool {
+		return len(r.pool.Available(t.groups)) > 0
+	})
+}
+
+// pollRound polls each target that has a group with room once, in rotation,
+// and reports whether any operation was found. Errors are logged as they
+// happen, and only returned if no target had work, so that one failing
+// project doesn't slow the agent down for the others.
+func (r *agentRunner) pollRound(ctx, workCtx context.Context) (bool, error) {
+	var (
+		found bool
+		errs  []error
+	)
+
+	for _, t := range r.nextTargets() {
+		if ctx.Err() != nil {
+			break
+		}
+
+		groups := r.pool.Available(t.groups)
+		if len(groups) == 0 {
+			continue
+		}
+
+		ok, err := r.poll(ctx, workCtx, t, groups)
+		if err != nil {
+			targetLogger(r.log, t).Error("error reading agent operation", "error", err)
+			errs = append(errs, err)
+		}
+
+		found = found || ok
+	}
+
+	if found {
+		return true, nil
+	}
+
+	return false, errors.Join(errs...)
+}
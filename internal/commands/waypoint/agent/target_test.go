@@ -0,0 +1,183 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	mock_waypoint_service "github.com/hashicorp/hcp/internal/pkg/api/mocks/github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+)
+
+const testProjectsConfig = `
+concurrency = 2
+
+project "a" {
+	organization_id = "org"
+	project_id      = "proj-a"
+	groups          = ["prod"]
+}
+
+project "b" {
+	organization_id = "org"
+	project_id      = "proj-b"
+	groups          = ["prod", "dev"]
+}
+
+group "prod" {
+	action "launch" {
+		run {
+			command = ["true"]
+		}
+	}
+}
+
+group "dev" {}
+`
+
+// projectRunner returns a runner for testProjectsConfig that reaches project
+// a with apiA and project b with apiB.
+func projectRunner(t *testing.T, apiA, apiB waypoint_service.ClientService) *agentRunner {
+	t.Helper()
+
+	runner := testRunner(t, apiA, testProjectsConfig)
+	require.Len(t, runner.targets, 2)
+
+	runner.targets[1].client = apiB
+
+	return runner
+}
+
+func TestAgentTargets(t *testing.T) {
+	t.Parallel()
+
+	t.Run("uses the profile's project when the config declares none", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		runner := testRunner(t, api, `
+		group "prod" {}
+		group "dev" {}
+`)
+
+		r.Len(runner.targets, 1)
+		r.Empty(runner.targets[0].name)
+		r.Equal("test-proj-id", runner.targets[0].profile.ProjectID)
+		r.Equal([]string{"dev", "prod"}, runner.targets[0].groups)
+	})
+
+	t.Run("polls projects in rotation and reports to the project of each run", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		apiA := mock_waypoint_service.NewMockClientService(t)
+		apiB := mock_waypoint_service.NewMockClientService(t)
+		runner := projectRunner(t, apiA, apiB)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var polled []string
+
+		expectProject := func(api *mock_waypoint_service.MockClientService, name, projectID string, groups []string) {
+			runID := "run-" + name
+
+			api.EXPECT().
+				WaypointServiceRetrieveAgentOperation(mock.Anything, mock.Anything).
+				RunAndReturn(func(params *waypoint_service.WaypointServiceRetrieveAgentOperationParams, _ runtime.ClientAuthInfoWriter, _ ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceRetrieveAgentOperationOK, error) {
+					r.Equal(projectID, params.NamespaceLocationProjectID)
+					r.Equal(groups, params.Body.Groups)
+
+					polled = append(polled, name)
+
+					// Each project has one operation, and the agent stops
+					// once both have been polled twice.
+					switch len(polled) {
+					case 1, 2:
+						return retrieved(&models.HashicorpCloudWaypointV20241122AgentOperation{
+							Group:       "prod",
+							ID:          "launch",
+							ActionRunID: runID,
+						}), nil
+					case 4:
+						cancel()
+					}
+
+					return retrieved(nil), nil
+				})
+
+			api.EXPECT().
+				WaypointServiceStartingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceStartingActionParams) bool {
+					return params.NamespaceLocationProjectID == projectID && params.Body.ActionRunID == runID
+				}), mock.Anything).
+				Return(&waypoint_service.WaypointServiceStartingActionOK{
+					Payload: &models.HashicorpCloudWaypointV20241122StartingActionResponse{
+						ActionRunID: runID,
+					},
+				}, nil).
+				Once()
+
+			api.EXPECT().
+				WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+					return params.NamespaceLocationProjectID == projectID && params.Body.ActionRunID == runID
+				}), mock.Anything).
+				Return(&waypoint_service.WaypointServiceEndingActionOK{}, nil).
+				Once()
+		}
+
+		expectProject(apiA, "a", "proj-a", []string{"prod"})
+		expectProject(apiB, "b", "proj-b", []string{"dev", "prod"})
+
+		r.NoError(runner.Run(ctx))
+		runner.pool.Wait()
+
+		// Each round starts with the next project.
+		r.Equal([]string{"a", "b", "b", "a"}, polled)
+	})
+
+	t.Run("retries pending reports against the project of the run", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		apiA := mock_waypoint_service.NewMockClientService(t)
+		apiB := mock_waypoint_service.NewMockClientService(t)
+		runner := projectRunner(t, apiA, apiB)
+
+		history, err := openHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+		r.NoError(err)
+		runner.history = history
+
+		r.NoError(history.Append(&historyEntry{Project: "b", Group: "prod", Action: "launch", ActionRunID: "run-1", ReportPending: true}))
+		r.NoError(history.Append(&historyEntry{Project: "gone", Group: "prod", Action: "launch", ActionRunID: "run-2", ReportPending: true}))
+
+		apiB.EXPECT().
+			WaypointServiceEndingAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceEndingActionParams) bool {
+				return params.NamespaceLocationProjectID == "proj-b" && params.Body.ActionRunID == "run-1"
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceEndingActionOK{}, nil).
+			Once()
+
+		runner.retryPendingReports(context.Background())
+
+		entries, err := history.List()
+		r.NoError(err)
+		r.Len(entries, 2)
+		r.False(entries[0].ReportPending)
+
+		// The project is no longer in the config, so the report is kept.
+		r.True(entries[1].ReportPending)
+	})
+}
@@ -0,0 +1,265 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/flagvalue"
+	"github.com/hashicorp/hcp/internal/pkg/format"
+	"github.com/hashicorp/hcp/internal/pkg/heredoc"
+	"github.com/hashicorp/hcp/internal/pkg/waypoint/agent"
+)
+
+type ValidateOpts struct {
+	opts.WaypointOpts
+
+	ConfigPath  string
+	CheckGroups bool
+}
+
+func NewCmdValidate(ctx *cmd.Context) *cmd.Command {
+	opts := &ValidateOpts{
+		WaypointOpts: opts.New(ctx),
+	}
+
+	cmd := &cmd.Command{
+		Name:      "validate",
+		ShortHelp: "Check an agent configuration file for errors.",
+		LongHelp: heredoc.New(ctx.IO).Must(`
+		The {{ template "mdCodeOrBold" "hcp waypoint agent validate" }} command checks an agent
+configuration file for errors without running it. Every action in every group is converted
+with placeholder inputs, so problems such as a missing command or a URL that is not a
+string are found before HCP Waypoint dispatches the action.
+
+Declared inputs are given their default, or a value of their type. Other variables an
+action refers to are given a placeholder string.
+
+With {{ template "mdCodeOrBold" "--check-groups" }}, the groups are also checked against the
+agent groups registered in HCP Waypoint. Only this check needs an HCP login.
+
+Problems are printed with their position in the file. Use {{ template "mdCodeOrBold" "--format=json" }}
+for output that can be read by other tools. The command exits with a non-zero code if any
+problems are found.
+		`),
+		Examples: []cmd.Example{
+			{
+				Preamble: "Validate agent.hcl in the current directory:",
+				Command:  "$ hcp waypoint agent validate",
+			},
+			{
+				Preamble: "Validate a config and check its groups exist in HCP Waypoint, printing JSON:",
+				Command:  "$ hcp waypoint agent validate -c /etc/waypoint/agent.hcl --check-groups --format=json",
+			},
+		},
+		Flags: cmd.Flags{
+			Local: []*cmd.Flag{
+				{
+					Name:         "config",
+					Shorthand:    "c",
+					DisplayValue: "PATH",
+					Description:  "Path to configuration file for agent.",
+					Value:        flagvalue.Simple("agent.hcl", &opts.ConfigPath),
+				},
+				{
+					Name:          "check-groups",
+					Description:   "Check that the groups in the configuration are registered in HCP Waypoint.",
+					Value:         flagvalue.Simple(false, &opts.CheckGroups),
+					IsBooleanFlag: true,
+				},
+			},
+		},
+		// Only --check-groups calls HCP, so linting a file in CI needs no
+		// credentials.
+		NoAuthRequired: true,
+		RunF: func(c *cmd.Command, args []string) error {
+			if opts.CheckGroups {
+				if err := requireLogin(ctx); err != nil {
+					return err
+				}
+
+				if err := cmd.RequireOrgAndProject(ctx); err != nil {
+					return err
+				}
+			}
+
+			return agentValidate(c.Logger(), opts)
+		},
+	}
+
+	return cmd
+}
+
+func agentValidate(log hclog.Logger, opts *ValidateOpts) error {
+	var diags validateDisplayer
+
+	cfg, err := agent.ParseConfigFile(opts.ConfigPath)
+	if err != nil {
+		var hdiags hcl.Diagnostics
+		if !errors.As(err, &hdiags) {
+			hdiags = hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid configuration",
+				Detail:   err.Error(),
+				Subject:  &hcl.Range{Filename: opts.ConfigPath},
+			}}
+		}
+
+		for _, d := range hdiags {
+			diags = append(diags, newValidateDiagnostic("", "", d))
+		}
+
+		return displayDiagnostics(opts, diags)
+	}
+
+	for _, d := range cfg.Validate() {
+		diags = append(diags, newValidateDiagnostic(d.Group, d.Action, d.Diagnostic))
+	}
+
+	if opts.CheckGroups && len(cfg.Groups()) > 0 {
+		resp, err := opts.WS2024Client.WaypointServiceValidateAgentGroups(&waypoint_service.WaypointServiceValidateAgentGroupsParams{
+			NamespaceLocationOrganizationID: opts.Profile.OrganizationID,
+			NamespaceLocationProjectID:      opts.Profile.ProjectID,
+			Body: &models.HashicorpCloudWaypointV20241122WaypointServiceValidateAgentGroupsBody{
+				Groups: cfg.Groups(),
+			},
+			Context: opts.Ctx,
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("error validating agent group names: %w", err)
+		}
+
+		for _, g := range resp.Payload.UnknownGroups {
+			rng := cfg.GroupRange(g)
+
+			diags = append(diags, newValidateDiagnostic(g, "", &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unknown agent group",
+				Detail:   fmt.Sprintf("HCP Waypoint has no agent group named %q. Create it with hcp waypoint agent group create.", g),
+				Subject:  &rng,
+			}))
+		}
+	}
+
+	return displayDiagnostics(opts, diags)
+}
+
+// displayDiagnostics prints diags and returns an error if any of them are
+// errors.
+func displayDiagnostics(opts *ValidateOpts, diags validateDisplayer) error {
+	if len(diags) == 0 && opts.Output.GetFormat() != format.JSON {
+		_, _ = fmt.Fprintf(opts.IO.Err(), "Configuration '%s' is valid.\n", opts.ConfigPath)
+		return nil
+	}
+
+	if diags == nil {
+		diags = validateDisplayer{}
+	}
+
+	if err := opts.Output.Display(diags); err != nil {
+		return err
+	}
+
+	var errs int
+	for _, d := range diags {
+		if d.Severity == "error" {
+			errs++
+		}
+	}
+
+	if errs > 0 {
+		return cmd.NewExitError(1, fmt.Errorf("found %d errors in '%s'", errs, opts.ConfigPath))
+	}
+
+	return nil
+}
+
+type validateDiagnostic struct {
+	Severity string `json:"severity"`
+	Filename string `json:"filename,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Action   string `json:"action,omitempty"`
+	Summary  string `json:"summary"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func newValidateDiagnostic(group, action string, d *hcl.Diagnostic) *validateDiagnostic {
+	vd := &validateDiagnostic{
+		Severity: "error",
+		Group:    group,
+		Action:   action,
+		Summary:  d.Summary,
+		Detail:   d.Detail,
+	}
+
+	if d.Severity == hcl.DiagWarning {
+		vd.Severity = "warning"
+	}
+
+	if d.Subject != nil {
+		vd.Filename = d.Subject.Filename
+		vd.Line = d.Subject.Start.Line
+		vd.Column = d.Subject.Start.Column
+	}
+
+	return vd
+}
+
+// Position returns the location of the problem as file:line,column.
+func (d *validateDiagnostic) Position() string {
+	if d.Line == 0 {
+		return d.Filename
+	}
+
+	return fmt.Sprintf("%s:%d,%d", d.Filename, d.Line, d.Column)
+}
+
+type validateDisplayer []*validateDiagnostic
+
+func (d validateDisplayer) DefaultFormat() format.Format {
+	return format.Table
+}
+
+func (d validateDisplayer) Payload() any {
+	return d
+}
+
+func (d validateDisplayer) FieldTemplates() []format.Field {
+	return []format.Field{
+		{
+			Name:        "Position",
+			ValueFormat: "{{ .Position }}",
+		},
+		{
+			Name:        "Severity",
+			ValueFormat: "{{ .Severity }}",
+		},
+		{
+			Name:        "Group",
+			ValueFormat: "{{ .Group }}",
+		},
+		{
+			Name:        "Action",
+			ValueFormat: "{{ .Action }}",
+		},
+		{
+			Name:        "Summary",
+			ValueFormat: "{{ .Summary }}",
+		},
+		{
+			Name:        "Detail",
+			ValueFormat: "{{ .Detail }}",
+		},
+	}
+}
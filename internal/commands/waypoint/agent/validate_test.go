@@ -0,0 +1,246 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/hcp/internal/commands/waypoint/opts"
+	mock_waypoint_service "github.com/hashicorp/hcp/internal/pkg/api/mocks/github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp/internal/pkg/cmd"
+	"github.com/hashicorp/hcp/internal/pkg/format"
+	"github.com/hashicorp/hcp/internal/pkg/iostreams"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+func TestAgentValidate(t *testing.T) {
+	t.Parallel()
+
+	writeConfig := func(t *testing.T, config string) string {
+		path := filepath.Join(t.TempDir(), "agent.hcl")
+		require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+		return path
+	}
+
+	validateOpts := func(io *iostreams.Testing, path string) *ValidateOpts {
+		return &ValidateOpts{
+			WaypointOpts: opts.WaypointOpts{
+				Ctx:    context.Background(),
+				IO:     io,
+				Output: format.New(io),
+				Profile: &profile.Profile{
+					OrganizationID: "test-org-id",
+					ProjectID:      "test-proj-id",
+				},
+			},
+			ConfigPath: path,
+		}
+	}
+
+	t.Run("valid config", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		path := writeConfig(t, `
+group "test" {
+  action "deploy" {
+    run {
+      command = "./deploy.sh ${var.version}"
+    }
+  }
+}
+`)
+
+		io := iostreams.Test()
+		r.NoError(agentValidate(hclog.NewNullLogger(), validateOpts(io, path)))
+		r.Contains(io.Error.String(), "is valid")
+	})
+
+	t.Run("does not read secrets", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		path := writeConfig(t, `
+secret "token" {
+  env = "HCP_WAYPOINT_AGENT_TEST_UNSET"
+}
+
+group "test" {
+  action "deploy" {
+    run {
+      command = ["./deploy.sh", secret.token]
+    }
+  }
+}
+`)
+
+		io := iostreams.Test()
+		r.NoError(agentValidate(hclog.NewNullLogger(), validateOpts(io, path)))
+		r.Contains(io.Error.String(), "is valid")
+	})
+
+	t.Run("reports action errors as json", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		path := writeConfig(t, `
+group "test" {
+  action "deploy" {
+    run {
+      env = {}
+    }
+  }
+}
+`)
+
+		io := iostreams.Test()
+		o := validateOpts(io, path)
+		o.Output.SetFormat(format.JSON)
+
+		err := agentValidate(hclog.NewNullLogger(), o)
+
+		var exitErr *cmd.ExitCodeError
+		r.True(errors.As(err, &exitErr))
+		r.Equal(1, exitErr.Code)
+
+		var diags []validateDiagnostic
+		r.NoError(json.Unmarshal(io.Output.Bytes(), &diags))
+		r.Len(diags, 1)
+		r.Equal("error", diags[0].Severity)
+		r.Equal(path, diags[0].Filename)
+		r.Equal(4, diags[0].Line)
+		r.Equal("test", diags[0].Group)
+		r.Equal("deploy", diags[0].Action)
+		r.Equal("Missing required argument", diags[0].Summary)
+	})
+
+	t.Run("reports commands without a program", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		path := writeConfig(t, `
+group "test" {
+  action "split" {
+    run {
+      command = split(" ", "echo hi")
+    }
+  }
+
+  action "empty" {
+    run {
+      command = []
+    }
+  }
+
+  action "number" {
+    run {
+      command = 5
+    }
+  }
+}
+`)
+
+		io := iostreams.Test()
+		o := validateOpts(io, path)
+		o.Output.SetFormat(format.JSON)
+
+		err := agentValidate(hclog.NewNullLogger(), o)
+
+		var exitErr *cmd.ExitCodeError
+		r.True(errors.As(err, &exitErr))
+
+		var diags []validateDiagnostic
+		r.NoError(json.Unmarshal(io.Output.Bytes(), &diags))
+		r.Len(diags, 2)
+		r.Equal("empty", diags[0].Action)
+		r.Equal("Invalid command", diags[0].Summary)
+		r.Equal("number", diags[1].Action)
+		r.Equal("Invalid command", diags[1].Summary)
+	})
+
+	t.Run("reports parse errors", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		path := writeConfig(t, `
+group "test" {
+  action "deploy" {
+`)
+
+		io := iostreams.Test()
+		o := validateOpts(io, path)
+		o.Output.SetFormat(format.JSON)
+
+		err := agentValidate(hclog.NewNullLogger(), o)
+		r.Error(err)
+
+		var diags []validateDiagnostic
+		r.NoError(json.Unmarshal(io.Output.Bytes(), &diags))
+		r.Len(diags, 1)
+		r.Equal(path, diags[0].Filename)
+		r.Equal(3, diags[0].Line)
+		r.Empty(diags[0].Action)
+	})
+
+	t.Run("checks groups with HCP", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+
+		path := writeConfig(t, `
+group "test" {
+  action "deploy" {
+    run {
+      command = "true"
+    }
+  }
+}
+
+group "other" {
+  action "deploy" {
+    run {
+      command = "true"
+    }
+  }
+}
+`)
+
+		api := mock_waypoint_service.NewMockClientService(t)
+		api.EXPECT().
+			WaypointServiceValidateAgentGroups(mock.MatchedBy(func(p *waypoint_service.WaypointServiceValidateAgentGroupsParams) bool {
+				return len(p.Body.Groups) == 2
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceValidateAgentGroupsOK{
+				Payload: &models.HashicorpCloudWaypointV20241122ValidateAgentGroupsResponse{
+					UnknownGroups: []string{"other"},
+				},
+			}, nil)
+
+		io := iostreams.Test()
+		o := validateOpts(io, path)
+		o.WS2024Client = api
+		o.CheckGroups = true
+		o.Output.SetFormat(format.JSON)
+
+		err := agentValidate(hclog.NewNullLogger(), o)
+		r.Error(err)
+
+		var diags []validateDiagnostic
+		r.NoError(json.Unmarshal(io.Output.Bytes(), &diags))
+		r.Len(diags, 1)
+		r.Equal("other", diags[0].Group)
+		r.Equal("Unknown agent group", diags[0].Summary)
+		r.Equal(10, diags[0].Line)
+	})
+}
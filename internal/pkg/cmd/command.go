@@ -80,6 +80,10 @@ type Command struct {
 	// required to be invoked.
 	NoAuthRequired bool
 
+	// Hidden hides the command from help output, suggestions and generated
+	// documentation. It can still be invoked.
+	Hidden bool
+
 	// Args documents the expected positional arguments.
 	Args PositionalArguments
 
@@ -210,7 +210,7 @@ func (c *Command) help() string {
 		// Determine the minimum padding
 		maxLength := 0
 		for _, c := range c.children {
-			if (group && c.RunF != nil) || (!group && c.RunF == nil) {
+			if c.Hidden || (group && c.RunF != nil) || (!group && c.RunF == nil) {
 				continue
 			}
 
@@ -220,7 +220,7 @@ func (c *Command) help() string {
 		namePadding := maxLength + 2
 		var names []string
 		for _, c := range c.children {
-			if (group && c.RunF != nil) || (!group && c.RunF == nil) {
+			if c.Hidden || (group && c.RunF != nil) || (!group && c.RunF == nil) {
 				continue
 			}
 
@@ -434,12 +434,20 @@ func (c *Command) usageHelp() string {
 		// Determine the minimum padding
 		maxLength := 0
 		for _, c := range c.children {
+			if c.Hidden {
+				continue
+			}
+
 			maxLength = max(maxLength, len(c.Name))
 		}
 
 		namePadding := maxLength + 2
 		var names []string
 		for _, c := range c.children {
+			if c.Hidden {
+				continue
+			}
+
 			names = append(names, rpad(c.Name+":", namePadding)+c.ShortHelp)
 		}
 
@@ -500,9 +508,11 @@ func (c *Command) nestedSuggestFunc(w io.Writer, arg string) {
 
 // suggestionsFor provides suggestions for the typedName.
 func (c *Command) suggestionsFor(typedName string) []string {
-	options := make([]string, len(c.children))
-	for i, c := range c.children {
-		options[i] = c.Name
+	options := make([]string, 0, len(c.children))
+	for _, c := range c.children {
+		if !c.Hidden {
+			options = append(options, c.Name)
+		}
 	}
 
 	typedNameLower := strings.ToLower(typedName)
@@ -131,3 +131,37 @@ func TestCommand_ExitCode(t *testing.T) {
 	r.Equal(code, root.Run([]string{}))
 	r.Contains(io.Error.String(), err.Error())
 }
+
+func TestCommand_Hidden(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	root := &Command{
+		Name: "root",
+		io:   iostreams.Test(),
+	}
+	shown := &Command{
+		Name:      "shown",
+		ShortHelp: "Shown command.",
+		RunF: func(c *Command, args []string) error {
+			return nil
+		},
+	}
+	hidden := &Command{
+		Name:      "hidden",
+		ShortHelp: "Hidden command.",
+		Hidden:    true,
+		RunF: func(c *Command, args []string) error {
+			return nil
+		},
+	}
+	root.AddChild(shown)
+	root.AddChild(hidden)
+
+	// Hidden commands aren't listed or suggested, but can still be run.
+	r.Contains(root.help(), "Shown command.")
+	r.NotContains(root.help(), "Hidden command.")
+	r.NotContains(root.usageHelp(), "Hidden command.")
+	r.Empty(root.suggestionsFor("hidde"))
+	r.Zero(hidden.Run(nil))
+}
@@ -5,6 +5,7 @@ package cmd
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/mitchellh/cli"
 	"github.com/posener/complete"
@@ -87,6 +88,22 @@ func toCommandMap(parent string, c *Command, m map[string]cli.CommandFactory) {
 	}
 }
 
+// HiddenCommands returns the paths of the hidden commands below c, as keys of
+// the map returned by ToCommandMap, so they can be left out of autocompletion.
+// The passed Command should be the root command.
+func HiddenCommands(c *Command) []string {
+	var hidden []string
+	for path, factory := range ToCommandMap(c) {
+		cc, err := factory()
+		if err == nil && cc.(*CompatibleCommand).c.Hidden {
+			hidden = append(hidden, path)
+		}
+	}
+
+	slices.Sort(hidden)
+	return hidden
+}
+
 // RootHelpFunc returns a help function that meets the mitchellh/cli interface
 // for help functions.
 func RootHelpFunc(c *Command) func(map[string]cli.CommandFactory) string {
@@ -115,3 +115,30 @@ func TestToCommandMap(t *testing.T) {
 	// Check the actual and expected match
 	r.Equal(expectedCommands, actualCommands, "commands")
 }
+
+func TestHiddenCommands(t *testing.T) {
+	r := require.New(t)
+	t.Parallel()
+
+	root := &Command{
+		Name: "hcp",
+	}
+	c1 := &Command{
+		Name:    "child1",
+		Aliases: []string{"c1"},
+	}
+	c1n1 := &Command{
+		Name:    "nested1",
+		Aliases: []string{"n1"},
+		Hidden:  true,
+	}
+	c1n2 := &Command{
+		Name: "nested2",
+	}
+
+	root.AddChild(c1)
+	c1.AddChild(c1n1)
+	c1.AddChild(c1n2)
+
+	r.Equal([]string{"c1 n1", "c1 nested1", "child1 n1", "child1 nested1"}, HiddenCommands(root))
+}
@@ -48,6 +48,10 @@ func GenMarkdownTree(c *Command, dir string, link LinkHandler) error {
 	}
 
 	for _, c := range c.children {
+		if c.Hidden {
+			continue
+		}
+
 		dir := dir
 		if len(c.children) > 0 {
 			dir = filepath.Join(dir, c.Name)
@@ -118,6 +122,10 @@ func GenMarkdown(c *Command, w io.Writer, link LinkHandler) error {
 	if len(c.children) > 0 {
 		var commands, groups []string
 		for _, c := range c.children {
+			if c.Hidden {
+				continue
+			}
+
 			path := strings.ReplaceAll(c.commandPath(), " ", "/")
 			entry := fmt.Sprintf("- [`%s`](%s) - %s", c.Name, link(path), c.ShortHelp)
 
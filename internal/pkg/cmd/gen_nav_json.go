@@ -77,6 +77,10 @@ func genNavJSON(c *Command, nav *DocNavItem, path string) {
 
 	// If we have children, create a new nav item for each child
 	for _, child := range c.children {
+		if child.Hidden {
+			continue
+		}
+
 		genNavJSON(child, self, path)
 	}
 
@@ -0,0 +1,84 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Auth is how the agent authenticates to HCP, set with an auth block. Without
+// one, the agent uses the credentials of the CLI, which change whenever
+// someone logs in on the host.
+type Auth struct {
+	// CredFile is the credential file the agent authenticates with.
+	CredFile string
+
+	// ClientID and ClientSecret are the service principal credentials the
+	// agent authenticates with if there is no credential file. They are read
+	// from the environment when the config is loaded.
+	ClientID     string
+	ClientSecret string
+}
+
+// Method describes how the agent authenticates, for its logs.
+func (a *Auth) Method() string {
+	if a.CredFile != "" {
+		return "credential file"
+	}
+
+	return "client credentials"
+}
+
+// hclAuth is the auth block.
+type hclAuth struct {
+	CredFile        string `hcl:"cred_file,optional"`
+	ClientIDEnv     string `hcl:"client_id_env,optional"`
+	ClientSecretEnv string `hcl:"client_secret_env,optional"`
+}
+
+// loadAuth checks the auth block and reads the client credentials it names.
+// A relative credential file path is resolved from baseDir.
+func loadAuth(ha *hclAuth, baseDir string) (*Auth, error) {
+	if ha == nil {
+		return nil, nil
+	}
+
+	clientEnv := ha.ClientIDEnv != "" || ha.ClientSecretEnv != ""
+
+	switch {
+	case ha.CredFile != "" && clientEnv:
+		return nil, fmt.Errorf("auth: only one of cred_file or client_id_env and client_secret_env can be set")
+	case ha.CredFile != "":
+		credFile := ha.CredFile
+		if !filepath.IsAbs(credFile) && !strings.HasPrefix(credFile, "~") {
+			credFile = filepath.Join(baseDir, credFile)
+		}
+
+		return &Auth{CredFile: credFile}, nil
+	case ha.ClientIDEnv == "" || ha.ClientSecretEnv == "":
+		return nil, fmt.Errorf("auth: cred_file, or both client_id_env and client_secret_env, must be set")
+	}
+
+	a := &Auth{}
+
+	for _, v := range []struct {
+		env string
+		val *string
+	}{
+		{ha.ClientIDEnv, &a.ClientID},
+		{ha.ClientSecretEnv, &a.ClientSecret},
+	} {
+		val, ok := os.LookupEnv(v.env)
+		if !ok || val == "" {
+			return nil, fmt.Errorf("auth: environment variable %s is not set", v.env)
+		}
+
+		*v.val = val
+	}
+
+	return a, nil
+}
@@ -5,15 +5,22 @@ package agent
 
 import (
 	"context"
+	"fmt"
+	"maps"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
 	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
 	"github.com/hashicorp/hcp/internal/pkg/profile"
+	"github.com/zclconf/go-cty/cty"
 )
 
 type CompoundOperation struct {
 	Operations []Operation
+
+	// Names holds a name for each operation, used to report which step
+	// failed.
+	Names []string
 }
 
 func (c *CompoundOperation) Run(
@@ -23,12 +30,103 @@ func (c *CompoundOperation) Run(
 	profile *profile.Profile,
 	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
 ) (OperationStatus, error) {
-	for _, op := range c.Operations {
-		code, err := op.Run(ctx, log, api, profile, opInfo)
+	status := cleanStatus
+
+	// results holds the result of each step that has run, for the steps
+	// after it that refer to them.
+	results := make(map[string]cty.Value)
+
+	for i, op := range c.Operations {
+		name := c.name(i)
+
+		if d, ok := op.(*DeferredOperation); ok {
+			var err error
+
+			op, err = d.convert(results)
+			if err != nil {
+				return errStatus, fmt.Errorf("step %s: %w", name, err)
+			}
+		}
+
+		code, err := op.Run(ctx, log.With("step", name), api, profile, opInfo)
 		if err != nil {
-			return code, err
+			return code, fmt.Errorf("step %s: %w", name, err)
+		}
+
+		if code.Code != 0 {
+			code.Values = maps.Clone(code.Values)
+			if code.Values == nil {
+				code.Values = make(map[string]string)
+			}
+
+			code.Values["failed_step"] = name
+			code.Status = fmt.Sprintf("step %s failed: %s", name, code.Status)
+
+			return code, nil
 		}
+
+		results[name] = stepResult(code)
+		status = code
+	}
+
+	return status, nil
+}
+
+// stepResult returns the result of a step as later steps refer to it, as
+// step.<name>.status, step.<name>.code and step.<name>.values.
+func stepResult(status OperationStatus) cty.Value {
+	values := make(map[string]cty.Value, len(status.Values))
+	for k, v := range status.Values {
+		values[k] = cty.StringVal(v)
+	}
+
+	return cty.ObjectVal(map[string]cty.Value{
+		"status": cty.StringVal(status.Status),
+		"code":   cty.NumberIntVal(int64(status.Code)),
+		"values": cty.ObjectVal(values),
+	})
+}
+
+// DeferredOperation is a step of a CompoundOperation that refers to the
+// results of the steps before it. It is converted from its config when it is
+// about to run, once those results are known.
+type DeferredOperation struct {
+	// Preview is the operation converted with placeholder results, used to
+	// describe it before it runs.
+	Preview Operation
+
+	// convert converts the operation given the results of the earlier steps,
+	// by name.
+	convert func(results map[string]cty.Value) (Operation, error)
+}
+
+// Run converts and runs the operation without the results of any earlier
+// steps. Within a CompoundOperation, it is converted with them instead.
+func (d *DeferredOperation) Run(
+	ctx context.Context,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (OperationStatus, error) {
+	op, err := d.convert(nil)
+	if err != nil {
+		return errStatus, err
+	}
+
+	return op.Run(ctx, log, api, profile, opInfo)
+}
+
+func (c *CompoundOperation) name(i int) string {
+	return stepName(c.Names, i)
+}
+
+// stepName returns the name of the i'th step, or its number if it has no
+// name.
+func stepName(names []string, i int) string {
+	if i < len(names) && names[i] != "" {
+		return names[i]
 	}
 
-	return cleanStatus, nil
+	return fmt.Sprintf("%d", i+1)
 }
@@ -5,21 +5,64 @@ package agent
 
 import (
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/buildkite/shellwords"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hclsimple"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
 )
 
 type Config struct {
 	groupNames []string
 	groups     map[string]*hclGroup
 
+	concurrency int
+
+	pollMinInterval time.Duration
+	pollMaxInterval time.Duration
+	shutdownGrace   time.Duration
+
+	// healthAddr is the address the agent serves health checks and metrics
+	// on. Empty disables the listener.
+	healthAddr string
+
+	// historyPath is the file the agent records the operations it runs in.
+	historyPath string
+
+	// functions are available to expressions in actions.
+	functions map[string]function.Function
+
+	// hsecrets are the secret blocks, and secrets their values once
+	// LoadSecrets has read them. Actions refer to them as secret.<name>.
+	hsecrets []*hclSecret
+	secrets  map[string]string
+
+	// process holds the process options in effect for the run blocks being
+	// converted. It starts as the top level process block and is overridden
+	// by the process blocks in actions.
+	process *ProcessOptions
+
+	// projects are the HCP projects the agent polls, if the config declares
+	// any.
+	projects []*Project
+
+	// auth is how the agent authenticates to HCP, if the config has an auth
+	// block.
+	auth *Auth
+
+	// baseDir is the directory relative paths in the config are resolved
+	// from.
+	baseDir string
+
 	forceShell string
 	opWrapper  func(Operation) Operation
 }
@@ -34,18 +77,7 @@ func ParseConfig(input string) (*Config, error) {
 		return nil, err
 	}
 
-	var cfg Config
-	cfg.groups = make(map[string]*hclGroup)
-	cfg.opWrapper = func(o Operation) Operation { return o }
-
-	for _, grp := range hc.Groups {
-		cfg.groupNames = append(cfg.groupNames, grp.Name)
-		cfg.groups[grp.Name] = grp
-	}
-
-	sort.Strings(cfg.groupNames)
-
-	return &cfg, nil
+	return newConfig(&hc, ".")
 }
 
 func ParseConfigFile(path string) (*Config, error) {
@@ -63,17 +95,106 @@ func ParseConfigFile(path string) (*Config, error) {
 		return nil, err
 	}
 
+	return newConfig(&hc, filepath.Dir(path))
+}
+
+func newConfig(hc *hclConfig, baseDir string) (*Config, error) {
 	var cfg Config
 	cfg.groups = make(map[string]*hclGroup)
 	cfg.opWrapper = func(o Operation) Operation { return o }
+	cfg.functions = functions(baseDir, hc.AllowEnvFunction)
+
+	if hc.Concurrency < 0 {
+		return nil, fmt.Errorf("concurrency must not be negative")
+	}
+
+	// Secrets are only read by LoadSecrets, so the config can be checked
+	// without them.
+	if err := checkSecrets(hc.Secrets); err != nil {
+		return nil, err
+	}
+
+	cfg.hsecrets = hc.Secrets
+	cfg.baseDir = baseDir
+
+	var err error
+
+	cfg.auth, err = loadAuth(hc.Auth, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if hc.Process != nil {
+		cfg.process, err = cfg.process.merge(hc.Process, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("process: %w", err)
+		}
+	}
+	cfg.concurrency = hc.Concurrency
+	cfg.healthAddr = hc.HealthAddr
+	cfg.historyPath = hc.HistoryPath
+
+	// Relative paths are relative to the config file, as they are for file().
+	if cfg.historyPath != "" && !filepath.IsAbs(cfg.historyPath) && !strings.HasPrefix(cfg.historyPath, "~") {
+		cfg.historyPath = filepath.Join(baseDir, cfg.historyPath)
+	}
+
+	if hc.PollMinInterval != "" {
+		d, err := time.ParseDuration(hc.PollMinInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid poll_min_interval: %w", err)
+		}
+
+		cfg.pollMinInterval = d
+	}
+
+	if hc.PollMaxInterval != "" {
+		d, err := time.ParseDuration(hc.PollMaxInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid poll_max_interval: %w", err)
+		}
+
+		cfg.pollMaxInterval = d
+	}
+
+	if hc.ShutdownGrace != "" {
+		d, err := time.ParseDuration(hc.ShutdownGrace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shutdown_grace: %w", err)
+		}
+
+		cfg.shutdownGrace = d
+	}
+
+	if cfg.pollMinInterval > 0 && cfg.pollMaxInterval > 0 && cfg.pollMinInterval > cfg.pollMaxInterval {
+		return nil, fmt.Errorf("poll_min_interval must not be greater than poll_max_interval")
+	}
 
 	for _, grp := range hc.Groups {
+		if grp.Concurrency < 0 {
+			return nil, fmt.Errorf("group %q: concurrency must not be negative", grp.Name)
+		}
+
+		for _, act := range grp.Actions {
+			inputs, err := convertInputs(act.Inputs)
+			if err != nil {
+				return nil, fmt.Errorf("group %q action %q: %w", grp.Name, act.Name, err)
+			}
+
+			act.inputs = inputs
+		}
+
 		cfg.groupNames = append(cfg.groupNames, grp.Name)
 		cfg.groups[grp.Name] = grp
 	}
 
 	sort.Strings(cfg.groupNames)
 
+	cfg.projects, err = loadProjects(hc.Projects, cfg.groups, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
@@ -81,6 +202,69 @@ func (c *Config) Groups() []string {
 	return c.groupNames
 }
 
+// Projects returns the HCP projects declared in the config, in the order they
+// are declared. It is empty if the agent should poll the project of its
+// profile.
+func (c *Config) Projects() []*Project {
+	return c.projects
+}
+
+// Auth returns how the agent authenticates to HCP, or nil if the config has
+// no auth block and the agent should use the CLI's credentials.
+func (c *Config) Auth() *Auth {
+	return c.auth
+}
+
+// Concurrency returns the number of operations the agent may run at once. If
+// the config does not specify a value, operations are run one at a time.
+func (c *Config) Concurrency() int {
+	if c.concurrency < 1 {
+		return 1
+	}
+
+	return c.concurrency
+}
+
+// GroupConcurrency returns the per group limits on the number of operations
+// that may run at once. Groups without a limit are not included.
+func (c *Config) GroupConcurrency() map[string]int {
+	limits := make(map[string]int)
+
+	for name, grp := range c.groups {
+		if grp.Concurrency > 0 {
+			limits[name] = grp.Concurrency
+		}
+	}
+
+	return limits
+}
+
+// PollInterval returns the minimum and maximum time to wait between polls for
+// new operations when the queue is empty. A zero value means the config does
+// not specify that bound.
+func (c *Config) PollInterval() (minInterval, maxInterval time.Duration) {
+	return c.pollMinInterval, c.pollMaxInterval
+}
+
+// ShutdownGrace returns how long the agent waits for running operations to
+// finish when asked to shut down. A zero value means the config does not
+// specify one.
+func (c *Config) ShutdownGrace() time.Duration {
+	return c.shutdownGrace
+}
+
+// HealthAddr returns the address to serve health checks and metrics on, or
+// an empty string if the config does not specify one.
+func (c *Config) HealthAddr() string {
+	return c.healthAddr
+}
+
+// HistoryPath returns the file to record the operations the agent runs in, or
+// an empty string if the config does not specify one.
+func (c *Config) HistoryPath() string {
+	return c.historyPath
+}
+
 func (c *Config) IsAvailable(group, id string) (bool, error) {
 	grp, ok := c.groups[group]
 	if !ok {
@@ -96,6 +280,79 @@ func (c *Config) IsAvailable(group, id string) (bool, error) {
 	return false, nil
 }
 
+// WrapOperations sets a function that every operation is passed through as
+// actions are converted. It can be used to observe or replace operations, for
+// example NoopOperations to resolve an action without running it.
+func (c *Config) WrapOperations(wrap func(Operation) Operation) {
+	if wrap == nil {
+		wrap = func(o Operation) Operation { return o }
+	}
+
+	c.opWrapper = wrap
+}
+
+// Actions returns the IDs of the actions in a group.
+func (c *Config) Actions(group string) []string {
+	grp, ok := c.groups[group]
+	if !ok {
+		return nil
+	}
+
+	var ids []string
+	for _, act := range grp.Actions {
+		ids = append(ids, act.Name)
+	}
+
+	return ids
+}
+
+// LoadSecrets reads the values of the config's secrets, from their files or
+// environment variables. Until it is called, actions are converted with
+// RedactedValue in place of each secret, and can't be executed.
+func (c *Config) LoadSecrets() error {
+	secrets, err := loadSecrets(c.hsecrets, c.baseDir)
+	if err != nil {
+		return err
+	}
+
+	c.secrets = secrets
+
+	return nil
+}
+
+// secretsLoaded reports whether the values of the config's secrets have been
+// read, or it has none.
+func (c *Config) secretsLoaded() bool {
+	return len(c.hsecrets) == 0 || c.secrets != nil
+}
+
+// Masker returns a new Masker for the values of the config's secrets and the
+// client secret of its auth block.
+func (c *Config) Masker() *Masker {
+	values := slices.Collect(maps.Values(c.secrets))
+	if c.auth != nil && c.auth.ClientSecret != "" {
+		values = append(values, c.auth.ClientSecret)
+	}
+
+	return NewMasker(values...)
+}
+
+// Inputs returns the inputs declared by an action.
+func (c *Config) Inputs(group, id string) []*Input {
+	grp, ok := c.groups[group]
+	if !ok {
+		return nil
+	}
+
+	for _, act := range grp.Actions {
+		if act.Name == id {
+			return act.inputs
+		}
+	}
+
+	return nil
+}
+
 func (c *Config) Action(group, id string, hctx *hcl.EvalContext) (Operation, error) {
 	grp, ok := c.groups[group]
 	if !ok {
@@ -106,6 +363,12 @@ func (c *Config) Action(group, id string, hctx *hcl.EvalContext) (Operation, err
 		hctx = &hcl.EvalContext{}
 	}
 
+	hctx = hctx.NewChild()
+	hctx.Functions = c.functions
+	hctx.Variables = map[string]cty.Value{
+		"secret": secretsObject(c.hsecrets, c.secrets),
+	}
+
 	for _, act := range grp.Actions {
 		if act.Name == id {
 			return c.convertAction(hctx, act.Body)
@@ -116,6 +379,14 @@ func (c *Config) Action(group, id string, hctx *hcl.EvalContext) (Operation, err
 }
 
 var actionSchema = hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{
+			Name: "name",
+		},
+		{
+			Name: "timeout",
+		},
+	},
 	Blocks: []hcl.BlockHeaderSchema{
 		{
 			Type: "run",
@@ -126,9 +397,27 @@ var actionSchema = hcl.BodySchema{
 		{
 			Type: "status",
 		},
+		{
+			Type: "parallel",
+		},
+		{
+			Type: "action",
+		},
 		{
 			Type: "operation",
 		},
+		{
+			Type: "retry",
+		},
+		{
+			Type: "on_failure",
+		},
+		{
+			Type: "finally",
+		},
+		{
+			Type: "process",
+		},
 	},
 }
 
@@ -138,6 +427,28 @@ func (c *Config) convertAction(hctx *hcl.EvalContext, body hcl.Body) (Operation,
 		return nil, diag
 	}
 
+	// A process block applies to every run block within the body, so convert
+	// the body with a copy of the config that carries it.
+	if blks := content.Blocks.OfType("process"); len(blks) > 0 {
+		process, err := c.process.decodeProcess(hctx, blks[0], c.baseDir)
+		if err != nil {
+			return nil, err
+		}
+
+		cc := *c
+		cc.process = process
+		c = &cc
+	}
+
+	op, err := c.convertOperation(hctx, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.convertControl(hctx, content, op)
+}
+
+func (c *Config) convertOperation(hctx *hcl.EvalContext, content *hcl.BodyContent) (Operation, error) {
 	for _, blk := range content.Blocks {
 		switch blk.Type {
 		case "run":
@@ -146,6 +457,10 @@ func (c *Config) convertAction(hctx *hcl.EvalContext, body hcl.Body) (Operation,
 			return c.convertHTTPAction(hctx, blk)
 		case "status":
 			return c.convertStatus(hctx, blk)
+		case "parallel":
+			return c.convertParallel(hctx, blk)
+		case "action":
+			return c.convertRunAction(hctx, blk)
 		}
 	}
 
@@ -166,19 +481,312 @@ func (c *Config) convertAction(hctx *hcl.EvalContext, body hcl.Body) (Operation,
 	return nil, fmt.Errorf("no operation specified")
 }
 
+type hclRetry struct {
+	Attempts int    `hcl:"attempts"`
+	Backoff  string `hcl:"backoff,optional"`
+}
+
+// convertControl wraps op with the timeout, retry and failure handling
+// specified alongside it. The timeout applies to each attempt, and on_failure
+// only runs once all attempts have failed.
+func (c *Config) convertControl(hctx *hcl.EvalContext, content *hcl.BodyContent, op Operation) (Operation, error) {
+	if attr, ok := content.Attributes["timeout"]; ok {
+		d, err := decodeDuration(hctx, attr)
+		if err != nil {
+			return nil, err
+		}
+
+		op = c.opWrapper(&TimeoutOperation{
+			Operation: op,
+			Timeout:   d,
+		})
+	}
+
+	blocks := content.Blocks.ByType()
+
+	if blks, ok := blocks["retry"]; ok {
+		var retry hclRetry
+
+		diag := gohcl.DecodeBody(blks[0].Body, hctx, &retry)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+
+		if retry.Attempts < 1 {
+			return nil, fmt.Errorf("retry attempts must be at least 1")
+		}
+
+		ro := &RetryOperation{
+			Operation: op,
+			Attempts:  retry.Attempts,
+		}
+
+		if retry.Backoff != "" {
+			d, err := time.ParseDuration(retry.Backoff)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retry backoff: %w", err)
+			}
+
+			ro.Backoff = d
+		}
+
+		op = c.opWrapper(ro)
+	}
+
+	var (
+		guard   GuardedOperation
+		guarded bool
+	)
+
+	if blks, ok := blocks["on_failure"]; ok {
+		handler, err := c.convertAction(hctx, blks[0].Body)
+		if err != nil {
+			return nil, fmt.Errorf("on_failure: %w", err)
+		}
+
+		guard.OnFailure = handler
+		guarded = true
+	}
+
+	if blks, ok := blocks["finally"]; ok {
+		handler, err := c.convertAction(hctx, blks[0].Body)
+		if err != nil {
+			return nil, fmt.Errorf("finally: %w", err)
+		}
+
+		guard.Finally = handler
+		guarded = true
+	}
+
+	if guarded {
+		guard.Operation = op
+		op = c.opWrapper(&guard)
+	}
+
+	return op, nil
+}
+
+var operationNameSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{
+			Name: "name",
+		},
+	},
+}
+
 func (c *Config) convertCompound(hctx *hcl.EvalContext, blks []*hcl.Block) (Operation, error) {
-	var co CompoundOperation
+	ops, names, err := c.convertSteps(hctx, blks, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.opWrapper(&CompoundOperation{
+		Operations: ops,
+		Names:      names,
+	}), nil
+}
+
+// convertSteps converts operation blocks, returning each operation and its
+// name. If the steps run in sequence, steps that refer to the results of
+// earlier ones as step.<name> are deferred until they run.
+func (c *Config) convertSteps(hctx *hcl.EvalContext, blks []*hcl.Block, sequential bool) ([]Operation, []string, error) {
+	var (
+		ops   []Operation
+		names []string
+	)
 
 	for _, blk := range blks {
-		op, err := c.convertAction(hctx, blk.Body)
+		var (
+			op  Operation
+			err error
+		)
+
+		if refs := variablesOf(blk.Body, "step"); sequential && len(refs) > 0 {
+			earlier := make([]string, len(names))
+			for i := range names {
+				earlier[i] = stepName(names, i)
+			}
+
+			op, err = c.deferStep(hctx, blk.Body, refs, earlier)
+		} else {
+			op, err = c.convertAction(hctx, blk.Body)
+		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		var name string
+
+		content, _, diag := blk.Body.PartialContent(operationNameSchema)
+		if diag.HasErrors() {
+			return nil, nil, diag
+		}
+
+		if attr, ok := content.Attributes["name"]; ok {
+			diag := gohcl.DecodeExpression(attr.Expr, hctx, &name)
+			if diag.HasErrors() {
+				return nil, nil, diag
+			}
+		}
+
+		ops = append(ops, op)
+		names = append(names, name)
+	}
+
+	return ops, names, nil
+}
+
+// deferStep returns a DeferredOperation for a step that refers to the results
+// of the earlier steps, or of the steps before an enclosing step. The step is
+// converted with placeholder results now, to find problems in it, and again
+// with the real results when it runs.
+//
+// Only the steps named in earlier or already in hctx get placeholders, so a
+// reference to any other step is reported when the step is converted. The
+// steps of a compound operation nested in this one check their own.
+func (c *Config) deferStep(hctx *hcl.EvalContext, body hcl.Body, refs []hcl.Traversal, earlier []string) (Operation, error) {
+	outer := stepResults(hctx)
+	used := make(map[string]any)
+
+	for _, tr := range refs {
+		if len(tr) < 2 {
+			continue
+		}
+
+		var name string
+
+		switch s := tr[1].(type) {
+		case hcl.TraverseAttr:
+			name = s.Name
+		case hcl.TraverseIndex:
+			if s.Key.Type() != cty.String {
+				continue
+			}
+
+			name = s.Key.AsString()
+		default:
+			continue
+		}
+
+		if _, ok := outer[name]; ok || slices.Contains(earlier, name) {
+			addPlaceholder(used, tr[1:])
+		}
+	}
+
+	withResults := func(results map[string]cty.Value) *hcl.EvalContext {
+		steps := stepResults(hctx)
+		maps.Copy(steps, results)
+
+		child := hctx.NewChild()
+		child.Variables = map[string]cty.Value{
+			"step": cty.ObjectVal(steps),
+		}
+
+		return child
+	}
+
+	_, placeholders := anyToCty(used)
+
+	preview, err := c.convertAction(withResults(placeholders), body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeferredOperation{
+		Preview: preview,
+		convert: func(results map[string]cty.Value) (Operation, error) {
+			return c.convertAction(withResults(results), body)
+		},
+	}, nil
+}
+
+// stepResults returns the results of the steps in hctx, which are set when
+// converting a step of a compound operation nested within a deferred step.
+func stepResults(hctx *hcl.EvalContext) map[string]cty.Value {
+	ret := make(map[string]cty.Value)
+
+	for ; hctx != nil; hctx = hctx.Parent() {
+		if v, ok := hctx.Variables["step"]; ok {
+			if v.Type().IsObjectType() {
+				maps.Copy(ret, v.AsValueMap())
+			}
+
+			break
+		}
+	}
+
+	return ret
+}
+
+var parallelSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{
+			Name: "failure_policy",
+		},
+	},
+	Blocks: []hcl.BlockHeaderSchema{
+		{
+			Type: "operation",
+		},
+	},
+}
+
+func (c *Config) convertParallel(hctx *hcl.EvalContext, blk *hcl.Block) (Operation, error) {
+	content, diag := blk.Body.Content(parallelSchema)
+	if diag.HasErrors() {
+		return nil, diag
+	}
+
+	if len(content.Blocks) == 0 {
+		return nil, fmt.Errorf("parallel: no operations specified")
+	}
+
+	po := &ParallelOperation{
+		FailurePolicy: FailFast,
+	}
+
+	if attr, ok := content.Attributes["failure_policy"]; ok {
+		var str string
+
+		diag := gohcl.DecodeExpression(attr.Expr, hctx, &str)
+		if diag.HasErrors() {
+			return nil, diag
 		}
 
-		co.Operations = append(co.Operations, op)
+		switch policy := FailurePolicy(str); policy {
+		case FailFast, WaitAll:
+			po.FailurePolicy = policy
+		default:
+			return nil, fmt.Errorf("parallel: failure_policy must be one of %q or %q", FailFast, WaitAll)
+		}
 	}
 
-	return c.opWrapper(&co), nil
+	ops, names, err := c.convertSteps(hctx, content.Blocks, false)
+	if err != nil {
+		return nil, err
+	}
+
+	po.Operations = ops
+	po.Names = names
+
+	return c.opWrapper(po), nil
+}
+
+// decodeDuration decodes a string attribute, such as "30s", as a duration.
+func decodeDuration(hctx *hcl.EvalContext, attr *hcl.Attribute) (time.Duration, error) {
+	var str string
+
+	diag := gohcl.DecodeExpression(attr.Expr, hctx, &str)
+	if diag.HasErrors() {
+		return 0, diag
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", attr.Name, err)
+	}
+
+	return d, nil
 }
 
 var runActionSchema = &hcl.BodySchema{
@@ -190,6 +798,15 @@ var runActionSchema = &hcl.BodySchema{
 		{
 			Name: "env",
 		},
+		{
+			Name: "sensitive_env",
+		},
+		{
+			Name: "stream_output",
+		},
+		{
+			Name: "stream_max_bytes",
+		},
 	},
 	Blocks: []hcl.BlockHeaderSchema{
 		{
@@ -206,7 +823,9 @@ func (c *Config) convertRun(hctx *hcl.EvalContext, blk *hcl.Block) (Operation, e
 		return nil, diag
 	}
 
-	val, diag := body.Attributes["command"].Expr.Value(hctx)
+	attr := body.Attributes["command"]
+
+	val, diag := attr.Expr.Value(hctx)
 	if diag.HasErrors() {
 		return nil, diag
 	}
@@ -216,12 +835,20 @@ func (c *Config) convertRun(hctx *hcl.EvalContext, blk *hcl.Block) (Operation, e
 		err   error
 	)
 
-	if val.Type() == cty.String {
+	switch ty := val.Type(); {
+	case val.IsNull() || !val.IsWhollyKnown():
+		return nil, invalidCommand(attr, "The command must be known when the action runs.")
+	case ty == cty.String:
 		str := val.AsString()
 
 		if strings.ContainsAny(str, posixSpecialChars) {
 			shell := c.forceShell
 
+			// The agent's shell may not exist inside a container.
+			if _, ok := body.Blocks.ByType()["docker"]; ok && shell == "" {
+				shell = "sh"
+			}
+
 			if shell == "" {
 				shell = os.Getenv("SHELL")
 			}
@@ -237,17 +864,17 @@ func (c *Config) convertRun(hctx *hcl.EvalContext, blk *hcl.Block) (Operation, e
 				return nil, err
 			}
 		}
-	} else if val.Type().IsTupleType() {
-		for _, v := range val.AsValueSlice() {
-			switch v.Type() {
-			case cty.String:
-				words = append(words, v.AsString())
-			case cty.Number:
-				words = append(words, v.AsBigFloat().String())
-			default:
-				return nil, fmt.Errorf("unsupported value type in arguments: %s", v.Type().GoString())
-			}
+	case ty.IsTupleType() || ty.IsListType() || ty.IsSetType():
+		words, err = ctyValueToStringSlice(val)
+		if err != nil {
+			return nil, invalidCommand(attr, err.Error())
 		}
+	default:
+		return nil, invalidCommand(attr, "The command must be a string or a list of arguments, not "+ty.FriendlyName()+".")
+	}
+
+	if len(words) == 0 || words[0] == "" {
+		return nil, invalidCommand(attr, "The command must name a program to run.")
 	}
 
 	var do *DockerOptions
@@ -261,6 +888,21 @@ func (c *Config) convertRun(hctx *hcl.EvalContext, blk *hcl.Block) (Operation, e
 		if diag.HasErrors() {
 			return nil, diag
 		}
+
+		if do.Pull != "" && !slices.Contains(dockerPullPolicies, do.Pull) {
+			return nil, fmt.Errorf("docker pull must be one of %s", strings.Join(dockerPullPolicies, ", "))
+		}
+
+		// These would only change how the docker command runs on the agent's
+		// host, not the container, so don't let them look like they apply.
+		if p := c.process; p != nil && (p.WorkDir != "" || p.UID != nil || p.GID != nil || len(p.Limits) > 0) {
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Process options not supported in containers",
+				Detail:   "The workdir, uid, gid and limits of process blocks don't apply to commands run with docker. Use the workdir, user, cpus and memory of the docker block instead.",
+				Subject:  blk.DefRange.Ptr(),
+			}}
+		}
 	}
 
 	env := map[string]string{}
@@ -272,11 +914,90 @@ func (c *Config) convertRun(hctx *hcl.EvalContext, blk *hcl.Block) (Operation, e
 		}
 	}
 
-	return c.opWrapper(&ShellOperation{
+	so := &ShellOperation{
 		Arguments:     words,
 		Environment:   env,
 		DockerOptions: do,
-	}), nil
+		Process:       c.process,
+	}
+
+	// Variables in sensitive_env are set like those in env, but their values
+	// are masked wherever the agent logs or reports them.
+	if v, ok := body.Attributes["sensitive_env"]; ok {
+		var senv map[string]string
+
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &senv)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+
+		if so.Environment == nil {
+			so.Environment = make(map[string]string)
+		}
+
+		for k, v := range senv {
+			so.Environment[k] = v
+			so.SensitiveEnv = append(so.SensitiveEnv, k)
+		}
+
+		slices.Sort(so.SensitiveEnv)
+	}
+
+	if v, ok := body.Attributes["stream_output"]; ok {
+		var str string
+
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &str)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+
+		so.StreamOutput, err = parseOutputStream(str)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := body.Attributes["stream_max_bytes"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &so.StreamMaxBytes)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+	}
+
+	return c.opWrapper(so), nil
+}
+
+// ctyValueToStringSlice returns the elements of a tuple, list or set of
+// strings and numbers as arguments.
+func ctyValueToStringSlice(val cty.Value) ([]string, error) {
+	var words []string
+
+	for it := val.ElementIterator(); it.Next(); {
+		_, v := it.Element()
+
+		switch {
+		case v.IsNull():
+			return nil, fmt.Errorf("arguments must not be null")
+		case v.Type() == cty.String:
+			words = append(words, v.AsString())
+		case v.Type() == cty.Number:
+			words = append(words, v.AsBigFloat().String())
+		default:
+			return nil, fmt.Errorf("unsupported value type in arguments: %s", v.Type().FriendlyName())
+		}
+	}
+
+	return words, nil
+}
+
+// invalidCommand returns a diagnostic for the command of a run block.
+func invalidCommand(attr *hcl.Attribute, detail string) hcl.Diagnostics {
+	return hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid command",
+		Detail:   detail,
+		Subject:  attr.Expr.Range().Ptr(),
+	}}
 }
 
 var httpActionSchema = &hcl.BodySchema{
@@ -285,6 +1006,32 @@ var httpActionSchema = &hcl.BodySchema{
 			Name:     "url",
 			Required: true,
 		},
+		{
+			Name: "method",
+		},
+		{
+			Name: "headers",
+		},
+		{
+			Name: "body",
+		},
+		{
+			Name: "timeout",
+		},
+		{
+			Name: "expected_status",
+		},
+		{
+			Name: "retries",
+		},
+		{
+			Name: "capture",
+		},
+	},
+	Blocks: []hcl.BlockHeaderSchema{
+		{
+			Type: "tls",
+		},
 	},
 }
 
@@ -303,9 +1050,152 @@ func (c *Config) convertHTTPAction(hctx *hcl.EvalContext, blk *hcl.Block) (Opera
 		return nil, fmt.Errorf("url must be a string")
 	}
 
-	return c.opWrapper(&HTTPOperation{
+	ho := &HTTPOperation{
 		URL: val.AsString(),
-	}), nil
+	}
+
+	if v, ok := body.Attributes["method"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &ho.Method)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+
+		ho.Method = strings.ToUpper(ho.Method)
+	}
+
+	if v, ok := body.Attributes["headers"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &ho.Headers)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+	}
+
+	if v, ok := body.Attributes["body"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &ho.Body)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+	}
+
+	if v, ok := body.Attributes["timeout"]; ok {
+		d, err := decodeDuration(hctx, v)
+		if err != nil {
+			return nil, err
+		}
+
+		ho.Timeout = d
+	}
+
+	if v, ok := body.Attributes["expected_status"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &ho.ExpectedStatus)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+	}
+
+	if v, ok := body.Attributes["retries"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &ho.Retries)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+
+		if ho.Retries < 0 {
+			return nil, fmt.Errorf("retries must not be negative")
+		}
+	}
+
+	if v, ok := body.Attributes["capture"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &ho.Capture)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+	}
+
+	if blks, ok := body.Blocks.ByType()["tls"]; ok {
+		ho.TLS = &HTTPTLSOptions{}
+
+		diag := gohcl.DecodeBody(blks[0].Body, hctx, ho.TLS)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+
+		if ho.TLS.CAFile != "" && !filepath.IsAbs(ho.TLS.CAFile) {
+			ho.TLS.CAFile = filepath.Join(c.baseDir, ho.TLS.CAFile)
+		}
+	}
+
+	return c.opWrapper(ho), nil
+}
+
+var runActionOpSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{
+			Name:     "name",
+			Required: true,
+		},
+		{
+			Name: "application",
+		},
+		{
+			Name: "inputs",
+		},
+		{
+			Name: "wait",
+		},
+		{
+			Name: "poll_interval",
+		},
+	},
+}
+
+func (c *Config) convertRunAction(hctx *hcl.EvalContext, blk *hcl.Block) (Operation, error) {
+	body, diag := blk.Body.Content(runActionOpSchema)
+	if diag.HasErrors() {
+		return nil, diag
+	}
+
+	var ao RunActionOperation
+
+	diag = gohcl.DecodeExpression(body.Attributes["name"].Expr, hctx, &ao.Action)
+	if diag.HasErrors() {
+		return nil, diag
+	}
+
+	if ao.Action == "" {
+		return nil, fmt.Errorf("action name must not be empty")
+	}
+
+	if v, ok := body.Attributes["application"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &ao.Application)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+	}
+
+	if v, ok := body.Attributes["inputs"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &ao.Inputs)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+	}
+
+	if v, ok := body.Attributes["wait"]; ok {
+		diag := gohcl.DecodeExpression(v.Expr, hctx, &ao.Wait)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+	}
+
+	if v, ok := body.Attributes["poll_interval"]; ok {
+		d, err := decodeDuration(hctx, v)
+		if err != nil {
+			return nil, err
+		}
+
+		ao.PollInterval = d
+	}
+
+	return c.opWrapper(&ao), nil
 }
 
 var statusSchema = &hcl.BodySchema{
@@ -396,15 +1286,31 @@ func (c *Config) convertStatus(hctx *hcl.EvalContext, blk *hcl.Block) (Operation
 }
 
 type hclAction struct {
-	Name string   `hcl:",label"`
-	Body hcl.Body `hcl:",remain"`
+	Name   string      `hcl:",label"`
+	Inputs []*hclInput `hcl:"input,block"`
+	Body   hcl.Body    `hcl:",remain"`
+
+	inputs []*Input
 }
 
 type hclGroup struct {
-	Name    string       `hcl:",label"`
-	Actions []*hclAction `hcl:"action,block"`
+	Name        string       `hcl:",label"`
+	Concurrency int          `hcl:"concurrency,optional"`
+	Actions     []*hclAction `hcl:"action,block"`
+	Body        hcl.Body     `hcl:",body"`
 }
 
 type hclConfig struct {
-	Groups []*hclGroup `hcl:"group,block"`
+	Concurrency      int           `hcl:"concurrency,optional"`
+	AllowEnvFunction bool          `hcl:"allow_env_function,optional"`
+	PollMinInterval  string        `hcl:"poll_min_interval,optional"`
+	PollMaxInterval  string        `hcl:"poll_max_interval,optional"`
+	ShutdownGrace    string        `hcl:"shutdown_grace,optional"`
+	HealthAddr       string        `hcl:"health_addr,optional"`
+	HistoryPath      string        `hcl:"history_path,optional"`
+	Auth             *hclAuth      `hcl:"auth,block"`
+	Secrets          []*hclSecret  `hcl:"secret,block"`
+	Process          *hclProcess   `hcl:"process,block"`
+	Projects         []*hclProject `hcl:"project,block"`
+	Groups           []*hclGroup   `hcl:"group,block"`
 }
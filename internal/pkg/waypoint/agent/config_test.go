@@ -4,9 +4,17 @@
 package agent
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
 	"github.com/stretchr/testify/require"
 	"github.com/zclconf/go-cty/cty"
 )
@@ -266,7 +274,14 @@ func TestConfig(t *testing.T) {
 					command = ["./launch.sh", "-delay", "5ms"]
 
 					docker {
-						image = "ubuntu"
+						image   = "ubuntu"
+						volumes = ["/srv/data:/data:ro"]
+						workdir = "/data"
+						network = "host"
+						user    = "1000:1000"
+						pull    = "missing"
+						cpus    = "0.5"
+						memory  = "256m"
 					}
 				}
 			}
@@ -288,5 +303,1188 @@ func TestConfig(t *testing.T) {
 		r.Equal([]string{"./launch.sh", "-delay", "5ms"}, shell.Arguments)
 		r.NotNil(shell.DockerOptions)
 		r.Equal("ubuntu", shell.DockerOptions.Image)
+		r.Equal([]string{"/srv/data:/data:ro"}, shell.DockerOptions.Volumes)
+		r.Equal("/data", shell.DockerOptions.WorkDir)
+		r.Equal("host", shell.DockerOptions.Network)
+		r.Equal("1000:1000", shell.DockerOptions.User)
+		r.Equal("missing", shell.DockerOptions.Pull)
+		r.Equal("0.5", shell.DockerOptions.CPUs)
+		r.Equal("256m", shell.DockerOptions.Memory)
+	})
+
+	t.Run("can specify concurrency limits", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		concurrency = 4
+
+		group "test" {
+			concurrency = 2
+
+			action "launch" {
+				run {
+					command = "./launch.sh"
+				}
+			}
+		}
+
+		group "other" {
+			action "launch" {
+				run {
+					command = "./launch.sh"
+				}
+			}
+		}
+`
+
+		cfg, err := ParseConfig(str)
+		r.NoError(err)
+
+		r.Equal(4, cfg.Concurrency())
+		r.Equal(map[string]int{"test": 2}, cfg.GroupConcurrency())
+	})
+
+	t.Run("defaults to running one operation at a time", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`group "test" {}`)
+		r.NoError(err)
+
+		r.Equal(1, cfg.Concurrency())
+		r.Empty(cfg.GroupConcurrency())
+	})
+
+	t.Run("can specify poll intervals and shutdown grace", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		poll_min_interval = "2s"
+		poll_max_interval = "30s"
+		shutdown_grace    = "45s"
+		health_addr       = "127.0.0.1:9102"
+		history_path      = "/var/lib/waypoint-agent/history.jsonl"
+
+		group "test" {}
+`
+
+		cfg, err := ParseConfig(str)
+		r.NoError(err)
+
+		minInterval, maxInterval := cfg.PollInterval()
+		r.Equal(2*time.Second, minInterval)
+		r.Equal(30*time.Second, maxInterval)
+		r.Equal(45*time.Second, cfg.ShutdownGrace())
+		r.Equal("127.0.0.1:9102", cfg.HealthAddr())
+		r.Equal("/var/lib/waypoint-agent/history.jsonl", cfg.HistoryPath())
+	})
+
+	t.Run("rejects a min poll interval above the max", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		poll_min_interval = "2m"
+		poll_max_interval = "30s"
+
+		group "test" {}
+`
+
+		_, err := ParseConfig(str)
+		r.Error(err)
+	})
+
+	t.Run("can configure output streaming", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		group "test" {
+			action "launch" {
+				run {
+					command          = "./launch.sh"
+					stream_output    = "both"
+					stream_max_bytes = 1024
+				}
+			}
+		}
+`
+
+		cfg, err := ParseConfig(str)
+		r.NoError(err)
+		cfg.opWrapper = NoopOperations
+
+		op, err := cfg.Action("test", "launch", nil)
+		r.NoError(err)
+
+		shell, ok := op.(*NoopWrapper).Operation.(*ShellOperation)
+		r.True(ok)
+
+		r.Equal(OutputStreamBoth, shell.StreamOutput)
+		r.Equal(1024, shell.StreamMaxBytes)
+	})
+
+	t.Run("rejects unknown output streams", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		group "test" {
+			action "launch" {
+				run {
+					command       = "./launch.sh"
+					stream_output = "stderr"
+				}
+			}
+		}
+`
+
+		cfg, err := ParseConfig(str)
+		r.NoError(err)
+
+		_, err = cfg.Action("test", "launch", nil)
+		r.Error(err)
+	})
+
+	t.Run("can configure http requests", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		group "test" {
+			action "notify" {
+				http {
+					url             = "https://example.com/hook"
+					method          = "post"
+					headers         = { Authorization = "Bearer ${var.token}" }
+					body            = "{\"app\": \"${var.app}\"}"
+					timeout         = "5s"
+					expected_status = [200, 202]
+					retries         = 2
+					capture         = { id = "data.id" }
+
+					tls {
+						ca_file = "/etc/ssl/ca.pem"
+					}
+				}
+			}
+		}
+`
+
+		cfg, err := ParseConfig(str)
+		r.NoError(err)
+		cfg.opWrapper = NoopOperations
+
+		var hctx hcl.EvalContext
+
+		hctx.Variables = map[string]cty.Value{
+			"var": cty.ObjectVal(map[string]cty.Value{
+				"token": cty.StringVal("abc"),
+				"app":   cty.StringVal("web"),
+			}),
+		}
+
+		op, err := cfg.Action("test", "notify", &hctx)
+		r.NoError(err)
+
+		ho, ok := op.(*NoopWrapper).Operation.(*HTTPOperation)
+		r.True(ok)
+
+		r.Equal("POST", ho.Method)
+		r.Equal(map[string]string{"Authorization": "Bearer abc"}, ho.Headers)
+		r.Equal(`{"app": "web"}`, ho.Body)
+		r.Equal(5*time.Second, ho.Timeout)
+		r.Equal([]int{200, 202}, ho.ExpectedStatus)
+		r.Equal(2, ho.Retries)
+		r.Equal(map[string]string{"id": "data.id"}, ho.Capture)
+		r.Equal("/etc/ssl/ca.pem", ho.TLS.CAFile)
+	})
+
+	t.Run("resolves a relative ca_file from the config's directory", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+		path := filepath.Join(dir, "agent.hcl")
+
+		r.NoError(os.WriteFile(path, []byte(`
+		group "test" {
+			action "notify" {
+				http {
+					url = "https://example.com/hook"
+
+					tls {
+						ca_file = "certs/ca.pem"
+					}
+				}
+			}
+		}
+`), 0o644))
+
+		cfg, err := ParseConfigFile(path)
+		r.NoError(err)
+
+		op, err := cfg.Action("test", "notify", nil)
+		r.NoError(err)
+
+		ho, ok := op.(*HTTPOperation)
+		r.True(ok)
+		r.Equal(filepath.Join(dir, "certs", "ca.pem"), ho.TLS.CAFile)
+	})
+
+	t.Run("can specify timeouts, retries and failure handlers", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		group "test" {
+			action "deploy" {
+				timeout = "10m"
+
+				operation {
+					name    = "migrate"
+					timeout = "1m"
+
+					retry {
+						attempts = 3
+						backoff  = "5s"
+					}
+
+					run {
+						command = "./migrate.sh"
+					}
+				}
+
+				on_failure {
+					run {
+						command = "./rollback.sh"
+					}
+				}
+
+				finally {
+					status {
+						message = "deploy finished"
+					}
+				}
+			}
+		}
+`
+
+		cfg, err := ParseConfig(str)
+		r.NoError(err)
+
+		op, err := cfg.Action("test", "deploy", nil)
+		r.NoError(err)
+
+		guard, ok := op.(*GuardedOperation)
+		r.True(ok)
+
+		r.IsType(&ShellOperation{}, guard.OnFailure)
+		r.IsType(&StatusOperation{}, guard.Finally)
+
+		to, ok := guard.Operation.(*TimeoutOperation)
+		r.True(ok)
+		r.Equal(10*time.Minute, to.Timeout)
+
+		co, ok := to.Operation.(*CompoundOperation)
+		r.True(ok)
+		r.Equal([]string{"migrate"}, co.Names)
+
+		ro, ok := co.Operations[0].(*RetryOperation)
+		r.True(ok)
+		r.Equal(3, ro.Attempts)
+		r.Equal(5*time.Second, ro.Backoff)
+
+		to, ok = ro.Operation.(*TimeoutOperation)
+		r.True(ok)
+		r.Equal(time.Minute, to.Timeout)
+		r.IsType(&ShellOperation{}, to.Operation)
+	})
+
+	t.Run("expressions can call functions", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+		r.NoError(os.WriteFile(filepath.Join(dir, "payload.txt"), []byte("from file"), 0o644))
+
+		path := filepath.Join(dir, "agent.hcl")
+		r.NoError(os.WriteFile(path, []byte(`
+		group "test" {
+			action "notify" {
+				status {
+					message = format("deploying %s", upper(var.app))
+					values = {
+						body    = jsonencode({ app = var.app })
+						token   = base64encode("user:pass")
+						region  = coalesce(var.region, "us-east-1")
+						size    = lookup({ small = "1", large = "4" }, var.size, "2")
+						payload = file("payload.txt")
+					}
+				}
+			}
+		}
+`), 0o644))
+
+		cfg, err := ParseConfigFile(path)
+		r.NoError(err)
+
+		var hctx hcl.EvalContext
+
+		hctx.Variables = map[string]cty.Value{
+			"var": cty.ObjectVal(map[string]cty.Value{
+				"app":    cty.StringVal("web"),
+				"region": cty.NullVal(cty.String),
+				"size":   cty.StringVal("large"),
+			}),
+		}
+
+		op, err := cfg.Action("test", "notify", &hctx)
+		r.NoError(err)
+
+		so, ok := op.(*StatusOperation)
+		r.True(ok)
+
+		r.Equal("deploying WEB", so.Message)
+		r.Equal(`{"app":"web"}`, so.Values["body"])
+		r.Equal("dXNlcjpwYXNz", so.Values["token"])
+		r.Equal("us-east-1", so.Values["region"])
+		r.Equal("4", so.Values["size"])
+		r.Equal("from file", so.Values["payload"])
+	})
+
+	t.Run("the env function must be enabled", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		action := `
+		group "test" {
+			action "launch" {
+				run {
+					command = ["echo", env("PATH")]
+				}
+			}
+		}
+`
+
+		cfg, err := ParseConfig(action)
+		r.NoError(err)
+
+		_, err = cfg.Action("test", "launch", nil)
+		r.ErrorContains(err, "blah.hcl:5,")
+		r.ErrorContains(err, "Call to unknown function")
+
+		cfg, err = ParseConfig("allow_env_function = true\n" + action)
+		r.NoError(err)
+
+		op, err := cfg.Action("test", "launch", nil)
+		r.NoError(err)
+
+		r.Equal([]string{"echo", os.Getenv("PATH")}, op.(*ShellOperation).Arguments)
+	})
+
+	t.Run("commands can be built with functions returning lists", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+		group "test" {
+			action "split" {
+				run {
+					command = split(" ", "echo hi")
+				}
+			}
+
+			action "concat" {
+				run {
+					command = concat(["echo"], ["a", "b"])
+				}
+			}
+		}
+`)
+		r.NoError(err)
+
+		op, err := cfg.Action("test", "split", nil)
+		r.NoError(err)
+		r.Equal([]string{"echo", "hi"}, op.(*ShellOperation).Arguments)
+
+		op, err = cfg.Action("test", "concat", nil)
+		r.NoError(err)
+		r.Equal([]string{"echo", "a", "b"}, op.(*ShellOperation).Arguments)
+	})
+
+	t.Run("rejects commands without a program", func(t *testing.T) {
+		t.Parallel()
+
+		for _, command := range []string{`[]`, `5`, `""`, `[""]`, `[["echo"]]`} {
+			cfg, err := ParseConfig(`
+			group "test" {
+				action "launch" {
+					run {
+						command = ` + command + `
+					}
+				}
+			}
+`)
+			require.NoError(t, err)
+
+			_, err = cfg.Action("test", "launch", nil)
+			require.ErrorContains(t, err, "Invalid command", command)
+		}
+	})
+
+	t.Run("can declare inputs", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		group "test" {
+			action "deploy" {
+				input "version" {
+					type        = string
+					required    = true
+					description = "Version to deploy"
+				}
+
+				input "regions" {
+					type    = list(string)
+					default = ["us-east-1"]
+				}
+
+				input "token" {
+					sensitive = true
+				}
+
+				run {
+					command = "./deploy.sh"
+				}
+			}
+		}
+`
+
+		cfg, err := ParseConfig(str)
+		r.NoError(err)
+
+		inputs := cfg.Inputs("test", "deploy")
+		r.Len(inputs, 3)
+
+		r.Equal("version", inputs[0].Name)
+		r.Equal(cty.String, inputs[0].Type)
+		r.True(inputs[0].Required)
+		r.Equal("Version to deploy", inputs[0].Description)
+
+		r.Equal(cty.List(cty.String), inputs[1].Type)
+		r.True(inputs[1].Default.RawEquals(cty.ListVal([]cty.Value{cty.StringVal("us-east-1")})))
+
+		r.Equal(cty.DynamicPseudoType, inputs[2].Type)
+		r.True(inputs[2].Sensitive)
+	})
+
+	t.Run("rejects defaults that don't match the input type", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		group "test" {
+			action "deploy" {
+				input "replicas" {
+					type    = number
+					default = "many"
+				}
+
+				run {
+					command = "./deploy.sh"
+				}
+			}
+		}
+`
+
+		_, err := ParseConfig(str)
+		r.ErrorContains(err, "invalid default")
+	})
+
+	t.Run("validation finds operations without a command", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		op := &CompoundOperation{Operations: []Operation{
+			&ShellOperation{Arguments: []string{"true"}},
+			&TimeoutOperation{Operation: &ShellOperation{}},
+		}}
+
+		r.EqualError(checkOperations(op), "run operation has no command to run")
+		r.NoError(checkOperations(op.Operations[0]))
+	})
+
+	t.Run("validates every action", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		group "test" {
+			action "ok" {
+				input "replicas" {
+					type = number
+				}
+
+				http {
+					url  = "https://example.com/${var.env.name}/${var.hosts[1]}"
+					body = jsonencode({ replicas = var.replicas + 1 })
+				}
+			}
+
+			action "missing-command" {
+				run {
+					env = { A = "b" }
+				}
+			}
+
+			action "bad-url" {
+				http {
+					url = { host = var.host }
+				}
+			}
+
+			action "empty" {
+			}
+
+			action "ok" {
+				run {
+					command = "true"
+				}
+			}
+		}
+`
+
+		cfg, err := ParseConfig(str)
+		r.NoError(err)
+
+		diags := cfg.Validate()
+
+		var found []string
+		for _, d := range diags {
+			found = append(found, fmt.Sprintf("%s/%s:%d: %s", d.Group, d.Action, d.Diagnostic.Subject.Start.Line, d.Diagnostic.Summary))
+		}
+
+		r.Equal([]string{
+			"test/missing-command:15: Missing required argument",
+			"test/bad-url:20: Invalid action",
+			"test/empty:26: Invalid action",
+			"test/ok:29: Duplicate action",
+		}, found)
+
+		r.Equal("blah.hcl", diags[0].Diagnostic.Subject.Filename)
+		r.Equal(2, cfg.GroupRange("test").Start.Line)
+	})
+
+	t.Run("redacts sensitive inputs from bodies", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		str := `
+		group "test" {
+			action "deploy" {
+				input "version" {}
+
+				input "token" {
+					sensitive = true
+				}
+
+				run {
+					command = "./deploy.sh"
+				}
+			}
+		}
+`
+
+		cfg, err := ParseConfig(str)
+		r.NoError(err)
+
+		vars, err := cfg.RedactedBody("test", "deploy", []byte(`{"var.version": "1.2.3", "var.token": "hunter2"}`))
+		r.NoError(err)
+		r.Equal(map[string]any{
+			"var": map[string]any{
+				"version": "1.2.3",
+				"token":   RedactedValue,
+			},
+		}, vars)
+	})
+
+	t.Run("secrets are read by LoadSecrets", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+		r.NoError(os.WriteFile(filepath.Join(dir, "token"), []byte("hunter2\n"), 0o600))
+
+		path := filepath.Join(dir, "agent.hcl")
+		r.NoError(os.WriteFile(path, []byte(`
+		secret "token" {
+			file = "token"
+		}
+
+		secret "path" {
+			env = "PATH"
+		}
+
+		group "test" {
+			action "deploy" {
+				run {
+					command = ["deploy", secret.token]
+					sensitive_env = {
+						DEPLOY_PATH = secret.path
+					}
+				}
+			}
+		}
+`), 0o644))
+
+		cfg, err := ParseConfigFile(path)
+		r.NoError(err)
+
+		// Until they are loaded, secrets are redacted.
+		op, err := cfg.Action("test", "deploy", nil)
+		r.NoError(err)
+		r.Equal([]string{"deploy", RedactedValue}, op.(*ShellOperation).Arguments)
+
+		r.NoError(cfg.LoadSecrets())
+
+		op, err = cfg.Action("test", "deploy", nil)
+		r.NoError(err)
+
+		so, ok := op.(*ShellOperation)
+		r.True(ok)
+
+		r.Equal([]string{"deploy", "hunter2"}, so.Arguments)
+		r.Equal(os.Getenv("PATH"), so.Environment["DEPLOY_PATH"])
+		r.Equal([]string{"DEPLOY_PATH"}, so.SensitiveEnv)
+		r.Equal(RedactedValue, cfg.Masker().Mask("hunter2"))
+	})
+
+	t.Run("secrets must have one source", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			name, hcl, err string
+		}{
+			{
+				name: "none",
+				hcl:  `secret "token" {}`,
+				err:  "one of file or env must be set",
+			},
+			{
+				name: "both",
+				hcl: `secret "token" {
+					file = "token"
+					env  = "TOKEN"
+				}`,
+				err: "only one of file or env can be set",
+			},
+			{
+				name: "duplicate",
+				hcl: `secret "token" {
+					env = "PATH"
+				}
+				secret "token" {
+					env = "PATH"
+				}`,
+				err: "declared more than once",
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				_, err := ParseConfig(tc.hcl)
+				require.ErrorContains(t, err, tc.err)
+			})
+		}
+	})
+
+	t.Run("secrets are only required once loaded", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+		secret "token" {
+			env = "HCP_WAYPOINT_AGENT_TEST_UNSET"
+		}
+
+		secret "key" {
+			file = "/nonexistent/key"
+		}
+
+		group "test" {
+			action "deploy" {
+				run {
+					command = ["deploy", secret.token, secret.key]
+				}
+			}
+		}
+`)
+		r.NoError(err)
+		r.Empty(cfg.Validate())
+
+		r.ErrorContains(cfg.LoadSecrets(), "HCP_WAYPOINT_AGENT_TEST_UNSET is not set")
+
+		e := Executor{Log: hclog.NewNullLogger(), Config: cfg}
+
+		_, err = e.Execute(context.Background(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "deploy",
+		})
+		r.ErrorContains(err, "secrets of the agent config have not been loaded")
+	})
+
+	t.Run("process blocks override the enclosing ones", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+		path := filepath.Join(dir, "agent.hcl")
+		r.NoError(os.WriteFile(path, []byte(`
+		process {
+			env_policy = "allowlist"
+			env_allow  = ["PATH", "LANG"]
+			limits     = { nofile = 1024, cpu = 60 }
+		}
+
+		group "test" {
+			action "default" {
+				run {
+					command = ["true"]
+				}
+			}
+
+			action "deploy" {
+				process {
+					workdir = "work"
+					uid     = 1000
+					limits  = { cpu = 10 }
+				}
+
+				operation {
+					run {
+						command = ["build"]
+					}
+				}
+
+				operation {
+					process {
+						env_policy = "empty"
+					}
+
+					run {
+						command = ["ship"]
+					}
+				}
+			}
+		}
+`), 0o644))
+
+		cfg, err := ParseConfigFile(path)
+		r.NoError(err)
+
+		op, err := cfg.Action("test", "default", nil)
+		r.NoError(err)
+
+		so, ok := op.(*ShellOperation)
+		r.True(ok)
+
+		r.Equal(&ProcessOptions{
+			EnvPolicy: EnvPolicyAllowlist,
+			EnvAllow:  []string{"PATH", "LANG"},
+			Limits:    map[string]uint64{"nofile": 1024, "cpu": 60},
+		}, so.Process)
+
+		op, err = cfg.Action("test", "deploy", nil)
+		r.NoError(err)
+
+		co, ok := op.(*CompoundOperation)
+		r.True(ok)
+		r.Len(co.Operations, 2)
+
+		uid := uint32(1000)
+		build := &ProcessOptions{
+			EnvPolicy: EnvPolicyAllowlist,
+			EnvAllow:  []string{"PATH", "LANG"},
+			WorkDir:   filepath.Join(dir, "work"),
+			UID:       &uid,
+			Limits:    map[string]uint64{"nofile": 1024, "cpu": 10},
+		}
+
+		r.Equal(build, co.Operations[0].(*ShellOperation).Process)
+
+		ship := *build
+		ship.EnvPolicy = EnvPolicyEmpty
+		r.Equal(&ship, co.Operations[1].(*ShellOperation).Process)
+
+		// The top level options are left as they were.
+		r.Equal(uint64(60), cfg.process.Limits["cpu"])
+	})
+
+	t.Run("process blocks are checked", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			name, hcl, err string
+		}{
+			{
+				name: "unknown policy",
+				hcl:  `env_policy = "some"`,
+				err:  "env_policy must be one of",
+			},
+			{
+				name: "allowlist without names",
+				hcl:  `env_policy = "allowlist"`,
+				err:  "env_allow must be set",
+			},
+			{
+				name: "unknown limit",
+				hcl:  `limits = { memory = 1 }`,
+				err:  `unknown limit "memory"`,
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				_, err := ParseConfig("process {\n" + tc.hcl + "\n}")
+				require.ErrorContains(t, err, tc.err)
+			})
+		}
+	})
+
+	t.Run("process options are rejected for containers", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			name, hcl string
+		}{
+			{name: "workdir", hcl: `workdir = "/srv"`},
+			{name: "uid", hcl: `uid = 1000`},
+			{name: "gid", hcl: `gid = 1000`},
+			{name: "limits", hcl: `limits = { nofile = 64 }`},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				r := require.New(t)
+
+				cfg, err := ParseConfig(`
+				process {
+					` + tc.hcl + `
+				}
+
+				group "test" {
+					action "local" {
+						run {
+							command = ["true"]
+						}
+					}
+
+					action "container" {
+						run {
+							command = ["true"]
+
+							docker {
+								image = "alpine"
+							}
+						}
+					}
+				}
+`)
+				r.NoError(err)
+
+				_, err = cfg.Action("test", "local", nil)
+				r.NoError(err)
+
+				_, err = cfg.Action("test", "container", nil)
+				r.ErrorContains(err, "Process options not supported in containers")
+			})
+		}
+
+		// The policy applies to the variables passed to the container.
+		cfg, err := ParseConfig(`
+		group "test" {
+			action "container" {
+				process {
+					env_policy = "empty"
+				}
+
+				run {
+					command = ["true"]
+
+					docker {
+						image = "alpine"
+					}
+				}
+			}
+		}
+`)
+		require.NoError(t, err)
+
+		_, err = cfg.Action("test", "container", nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("can handle parallel actions", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+		group "test" {
+			action "notify" {
+				parallel {
+					failure_policy = "wait_all"
+
+					operation {
+						name = "slack"
+						http {
+							url = "https://example.com/slack"
+						}
+					}
+
+					operation {
+						run {
+							command = ["notify", "pager"]
+						}
+					}
+				}
+			}
+		}
+`)
+		r.NoError(err)
+
+		op, err := cfg.Action("test", "notify", nil)
+		r.NoError(err)
+
+		po, ok := op.(*ParallelOperation)
+		r.True(ok)
+
+		r.Equal(WaitAll, po.FailurePolicy)
+		r.Equal([]string{"slack", ""}, po.Names)
+		r.IsType(&HTTPOperation{}, po.Operations[0])
+		r.IsType(&ShellOperation{}, po.Operations[1])
+
+		var buf strings.Builder
+		r.NoError(WriteTree(&buf, op))
+		r.Equal(`parallel 2 steps, wait_all
+  step slack: http GET https://example.com/slack
+  step 2: run notify pager
+`, buf.String())
+
+		bad, err := ParseConfig(`
+		group "test" {
+			action "notify" {
+				parallel {
+					failure_policy = "sometimes"
+
+					operation {
+						run {
+							command = ["true"]
+						}
+					}
+				}
+			}
+		}
+`)
+		r.NoError(err)
+
+		_, err = bad.Action("test", "notify", nil)
+		r.ErrorContains(err, "failure_policy must be one of")
+	})
+
+	t.Run("can declare projects", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+		project "web" {
+			organization_id = "org-1"
+			project_id      = "proj-web"
+			groups          = ["prod", "dev"]
+		}
+
+		project "data" {
+			organization_id = "org-1"
+			project_id      = "proj-data"
+			groups          = ["prod"]
+			cred_file       = "creds/data.json"
+		}
+
+		group "prod" {}
+		group "dev" {}
+`)
+		r.NoError(err)
+
+		projects := cfg.Projects()
+		r.Len(projects, 2)
+
+		r.Equal(&Project{
+			Name:           "web",
+			OrganizationID: "org-1",
+			ProjectID:      "proj-web",
+			Groups:         []string{"dev", "prod"},
+		}, projects[0])
+
+		r.Equal("data", projects[1].Name)
+		r.Equal([]string{"prod"}, projects[1].Groups)
+		r.Equal(filepath.Join(".", "creds/data.json"), projects[1].CredFile)
+
+		cfg, err = ParseConfig(`group "prod" {}`)
+		r.NoError(err)
+		r.Empty(cfg.Projects())
+	})
+
+	t.Run("projects are checked", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			name, hcl, err string
+		}{
+			{
+				name: "unknown group",
+				hcl: `project "web" {
+					organization_id = "org"
+					project_id      = "proj"
+					groups          = ["other"]
+				}`,
+				err: `project "web": group "other" is not declared`,
+			},
+			{
+				name: "no groups",
+				hcl: `project "web" {
+					organization_id = "org"
+					project_id      = "proj"
+					groups          = []
+				}`,
+				err: "groups must not be empty",
+			},
+			{
+				name: "duplicate",
+				hcl: `project "web" {
+					organization_id = "org"
+					project_id      = "proj"
+					groups          = ["prod"]
+				}
+				project "web" {
+					organization_id = "org"
+					project_id      = "proj-2"
+					groups          = ["prod"]
+				}`,
+				err: `project "web" is declared more than once`,
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				_, err := ParseConfig(tc.hcl + "\ngroup \"prod\" {}\n")
+				require.ErrorContains(t, err, tc.err)
+			})
+		}
+	})
+
+	t.Run("can declare how the agent authenticates", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+		auth {
+			cred_file = "creds/agent.json"
+		}
+
+		group "prod" {}
+`)
+		r.NoError(err)
+		r.Equal(&Auth{CredFile: filepath.Join(".", "creds/agent.json")}, cfg.Auth())
+		r.Equal("credential file", cfg.Auth().Method())
+
+		// PATH stands in for the variables holding the credentials.
+		cfg, err = ParseConfig(`
+		auth {
+			client_id_env     = "PATH"
+			client_secret_env = "PATH"
+		}
+
+		group "prod" {}
+`)
+		r.NoError(err)
+		r.Equal(os.Getenv("PATH"), cfg.Auth().ClientID)
+		r.Equal(os.Getenv("PATH"), cfg.Auth().ClientSecret)
+
+		// The client secret is masked like a secret.
+		r.Equal("path is (sensitive)", cfg.Masker().Mask("path is "+os.Getenv("PATH")))
+
+		cfg, err = ParseConfig(`group "prod" {}`)
+		r.NoError(err)
+		r.Nil(cfg.Auth())
+	})
+
+	t.Run("auth is checked", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			name, hcl, err string
+		}{
+			{
+				name: "empty",
+				hcl:  `auth {}`,
+				err:  "cred_file, or both client_id_env and client_secret_env, must be set",
+			},
+			{
+				name: "both",
+				hcl: `auth {
+					cred_file     = "agent.json"
+					client_id_env = "PATH"
+				}`,
+				err: "only one of cred_file or client_id_env and client_secret_env can be set",
+			},
+			{
+				name: "no secret",
+				hcl: `auth {
+					client_id_env = "PATH"
+				}`,
+				err: "must be set",
+			},
+			{
+				name: "unset variable",
+				hcl: `auth {
+					client_id_env     = "PATH"
+					client_secret_env = "HCP_WAYPOINT_AGENT_TEST_UNSET"
+				}`,
+				err: "environment variable HCP_WAYPOINT_AGENT_TEST_UNSET is not set",
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				_, err := ParseConfig(tc.hcl + "\ngroup \"prod\" {}\n")
+				require.ErrorContains(t, err, tc.err)
+			})
+		}
 	})
 }
@@ -0,0 +1,129 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Describe returns a one line summary of an operation, without the operations
+// it contains.
+func Describe(op Operation) string {
+	switch o := op.(type) {
+	case *NoopWrapper:
+		return Describe(o.Operation)
+	case *DeferredOperation:
+		return Describe(o.Preview)
+	case *ShellOperation:
+		desc := "run " + strings.Join(o.Arguments, " ")
+		if o.DockerOptions != nil {
+			desc += " (in docker image " + o.DockerOptions.Image + ")"
+		}
+		return desc
+	case *HTTPOperation:
+		method := o.Method
+		if method == "" {
+			method = "GET"
+		}
+		return "http " + method + " " + o.URL
+	case *StatusOperation:
+		return fmt.Sprintf("status %q", o.Message)
+	case *RunActionOperation:
+		desc := "action " + o.Action
+		if o.Application != "" {
+			desc += " for application " + o.Application
+		}
+		if o.Wait {
+			desc += ", wait"
+		}
+		return desc
+	case *CompoundOperation:
+		return fmt.Sprintf("%d steps", len(o.Operations))
+	case *ParallelOperation:
+		return fmt.Sprintf("parallel %d steps, %s", len(o.Operations), o.FailurePolicy)
+	case *TimeoutOperation:
+		return fmt.Sprintf("timeout %s", o.Timeout)
+	case *RetryOperation:
+		return fmt.Sprintf("retry %d attempts, backoff %s", o.Attempts, o.Backoff)
+	case *GuardedOperation:
+		return "guarded"
+	default:
+		return fmt.Sprintf("%T", op)
+	}
+}
+
+// IsLeaf reports whether an operation does work itself rather than running
+// other operations.
+func IsLeaf(op Operation) bool {
+	return len(children(op)) == 0
+}
+
+type childOperation struct {
+	label string
+	op    Operation
+}
+
+// children returns the operations an operation runs, labeled with their role.
+func children(op Operation) []childOperation {
+	switch o := op.(type) {
+	case *NoopWrapper:
+		return children(o.Operation)
+	case *DeferredOperation:
+		return children(o.Preview)
+	case *CompoundOperation:
+		var ret []childOperation
+		for i, sub := range o.Operations {
+			ret = append(ret, childOperation{label: "step " + o.name(i), op: sub})
+		}
+		return ret
+	case *ParallelOperation:
+		var ret []childOperation
+		for i, sub := range o.Operations {
+			ret = append(ret, childOperation{label: "step " + o.name(i), op: sub})
+		}
+		return ret
+	case *TimeoutOperation:
+		return []childOperation{{op: o.Operation}}
+	case *RetryOperation:
+		return []childOperation{{op: o.Operation}}
+	case *GuardedOperation:
+		ret := []childOperation{{op: o.Operation}}
+		if o.OnFailure != nil {
+			ret = append(ret, childOperation{label: "on_failure", op: o.OnFailure})
+		}
+		if o.Finally != nil {
+			ret = append(ret, childOperation{label: "finally", op: o.Finally})
+		}
+		return ret
+	default:
+		return nil
+	}
+}
+
+// WriteTree writes an operation and the operations it contains to w, one per
+// line and indented by depth.
+func WriteTree(w io.Writer, op Operation) error {
+	return writeTree(w, "", op, 0)
+}
+
+func writeTree(w io.Writer, label string, op Operation, depth int) error {
+	line := Describe(op)
+	if label != "" {
+		line = label + ": " + line
+	}
+
+	if _, err := fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", depth), line); err != nil {
+		return err
+	}
+
+	for _, c := range children(op) {
+		if err := writeTree(w, c.label, c.op, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
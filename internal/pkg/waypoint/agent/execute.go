@@ -21,6 +21,14 @@ import (
 type OperationStatus struct {
 	Status string
 	Code   int
+
+	// Values holds structured results of the operation, such as fields
+	// captured from an HTTP response.
+	Values map[string]string
+
+	// Output holds the end of the operation's output. It is kept in the
+	// agent's local history and is not sent to HCP.
+	Output string
 }
 
 var (
@@ -57,6 +65,99 @@ func (e *Executor) Execute(
 	profile *profile.Profile,
 	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
 ) (OperationStatus, error) {
+	if !e.Config.secretsLoaded() {
+		return errStatus, errors.New("the secrets of the agent config have not been loaded")
+	}
+
+	// Secrets and sensitive inputs are masked in everything the operation
+	// logs or reports. Operations can add to the masker as they run.
+	op, m, err := e.Resolve(opInfo)
+	if err != nil {
+		var inputErr *InputError
+		if errors.As(err, &inputErr) {
+			return OperationStatus{
+				Status: m.Mask(inputErr.Error()),
+				Code:   statusCodeInvalidInput,
+			}, nil
+		}
+
+		return errStatus, m.MaskError(err)
+	}
+
+	status, err := op.Run(WithMasker(ctx, m), newMaskedLogger(e.Log, m), api, profile, opInfo)
+
+	return m.MaskStatus(status), m.MaskError(err)
+}
+
+// ctyStrings returns every known string and number within v, as text.
+func ctyStrings(v cty.Value) []string {
+	if v.IsNull() || !v.IsKnown() {
+		return nil
+	}
+
+	ty := v.Type()
+
+	switch {
+	case ty == cty.String:
+		return []string{v.AsString()}
+	case ty == cty.Number:
+		return []string{v.AsBigFloat().Text('f', -1)}
+	case ty.IsObjectType() || ty.IsMapType() || ty.IsTupleType() || ty.IsListType() || ty.IsSetType():
+		var ret []string
+		for it := v.ElementIterator(); it.Next(); {
+			_, ev := it.Element()
+			ret = append(ret, ctyStrings(ev)...)
+		}
+
+		return ret
+	default:
+		return nil
+	}
+}
+
+// InputError is returned by Resolve when the body of an operation does not
+// match the inputs its action declares.
+type InputError struct {
+	Err error
+}
+
+func (e *InputError) Error() string {
+	return "invalid input: " + e.Err.Error()
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// Resolve evaluates the action an operation refers to with the operation's
+// body, returning the operation that would be run and a Masker for the
+// config's secrets and the operation's sensitive inputs. Errors are not
+// masked, so mask them with the returned Masker before showing them.
+func (e *Executor) Resolve(
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (Operation, *Masker, error) {
+	m := e.Config.Masker()
+
+	// Sensitive inputs are masked as given before the body is resolved, so
+	// errors resolving it don't show them.
+	m.Add(e.Config.sensitiveValues(opInfo.Group, opInfo.ID, opInfo.Body)...)
+
+	op, vars, err := e.resolve(opInfo)
+	if err != nil {
+		return nil, m, err
+	}
+
+	// Values converted to the type of their input can differ from how they
+	// were given, so mask them again.
+	m.Add(sensitiveStrings(e.Config.Inputs(opInfo.Group, opInfo.ID), vars)...)
+
+	return op, m, nil
+}
+
+// resolve is Resolve, also returning the variables the operation's body set.
+func (e *Executor) resolve(
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (Operation, cty.Value, error) {
 	var (
 		hctx   hcl.EvalContext
 		varMap map[string]any
@@ -67,12 +168,12 @@ func (e *Executor) Execute(
 
 		err := json.Unmarshal(opInfo.Body, &rawInput)
 		if err != nil {
-			return errStatus, err
+			return nil, cty.NilVal, err
 		}
 
 		varMap, err = buildVariableMap(rawInput)
 		if err != nil {
-			return errStatus, err
+			return nil, cty.NilVal, err
 		}
 	} else {
 		varMap = make(map[string]any)
@@ -87,16 +188,31 @@ func (e *Executor) Execute(
 	}
 	_, ctyMap := anyToCty(varMap)
 
+	// Check the body against the inputs the action declares before evaluating
+	// any of it, so a bad body fails clearly rather than part way through.
+	if inputs := e.Config.Inputs(opInfo.Group, opInfo.ID); len(inputs) > 0 {
+		vars, err := validateInputs(inputs, ctyMap["var"])
+		if err != nil {
+			return nil, cty.NilVal, &InputError{Err: err}
+		}
+
+		ctyMap["var"] = vars
+	}
+
 	// Set all variables from the server on the HCL context so we can parse the
 	// agent config if any interpolated variables are defined
 	hctx.Variables = ctyMap
 
 	op, err := e.Config.Action(opInfo.Group, opInfo.ID, &hctx)
 	if err != nil {
-		return errStatus, err
+		return nil, cty.NilVal, err
+	}
+
+	if op == nil {
+		return nil, cty.NilVal, fmt.Errorf("%w: %s/%s", ErrUnknownOperation, opInfo.Group, opInfo.ID)
 	}
 
-	return op.Run(ctx, e.Log, api, profile, opInfo)
+	return op, ctyMap["var"], nil
 }
 
 // buildVariableMap takes a map of string any values where the keys are expected
@@ -138,21 +254,40 @@ func anyToCty(objMap map[string]any) (cty.Value, map[string]cty.Value) {
 	obj := make(map[string]cty.Value)
 
 	for k, v := range objMap {
-		switch sv := v.(type) {
-		case map[string]any:
-			// Recuse and walk the map for its children
-			obj[k], _ = anyToCty(sv)
-		case float64:
-			obj[k] = cty.NumberFloatVal(sv)
-		case bool:
-			obj[k] = cty.BoolVal(sv)
-		case string:
-			obj[k] = cty.StringVal(sv)
-		default:
-			// Unhandled var type
-			obj[k] = cty.StringVal(fmt.Sprintf("%v", v))
-		}
+		obj[k] = anyValueToCty(v)
 	}
 
 	return cty.ObjectVal(obj), obj
 }
+
+// anyValueToCty converts a single value decoded from JSON to a cty value.
+func anyValueToCty(v any) cty.Value {
+	switch sv := v.(type) {
+	case map[string]any:
+		// Recuse and walk the map for its children
+		obj, _ := anyToCty(sv)
+		return obj
+	case []any:
+		if len(sv) == 0 {
+			return cty.EmptyTupleVal
+		}
+
+		elems := make([]cty.Value, len(sv))
+		for i, e := range sv {
+			elems[i] = anyValueToCty(e)
+		}
+
+		return cty.TupleVal(elems)
+	case nil:
+		return cty.NullVal(cty.DynamicPseudoType)
+	case float64:
+		return cty.NumberFloatVal(sv)
+	case bool:
+		return cty.BoolVal(sv)
+	case string:
+		return cty.StringVal(sv)
+	default:
+		// Unhandled var type
+		return cty.StringVal(fmt.Sprintf("%v", v))
+	}
+}
@@ -6,9 +6,19 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/go-openapi/runtime"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
 	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
 	mock_waypoint_service "github.com/hashicorp/hcp/internal/pkg/api/mocks/github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
@@ -213,4 +223,1301 @@ func TestExecutor(t *testing.T) {
 		r.NoError(err)
 	})
 
+	t.Run("shell output can be streamed as status logs", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "launch" {
+					run {
+						command       = ["sh", "-c", "echo one; echo two; echo oops >&2"]
+						stream_output = "stdout"
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		profile := profile.Profile{
+			OrganizationID: "test-org-id",
+			ProjectID:      "test-proj-id",
+		}
+
+		opInfo := models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "test-run-id",
+		}
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		api.
+			On(
+				"WaypointServiceSendStatusLog2",
+				mock.MatchedBy(func(params *waypoint_service.WaypointServiceSendStatusLog2Params) bool {
+					return params.ActionRunID == opInfo.ActionRunID &&
+						params.Body.StatusLog.Log == "one\ntwo" &&
+						params.Body.StatusLog.Metadata["stream"] == "stdout"
+				}),
+				mock.Anything, // authInfo
+			).
+			Return(&waypoint_service.WaypointServiceSendStatusLog2OK{}, nil).
+			Once()
+
+		_, err = e.Execute(context.TODO(), api, &profile, &opInfo)
+		r.NoError(err)
+	})
+
+	t.Run("streamed output is capped", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "launch" {
+					run {
+						command          = ["sh", "-c", "echo one; echo two; echo three"]
+						stream_output    = "both"
+						stream_max_bytes = 6
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		profile := profile.Profile{
+			OrganizationID: "test-org-id",
+			ProjectID:      "test-proj-id",
+		}
+
+		opInfo := models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "test-run-id",
+		}
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		api.
+			On(
+				"WaypointServiceSendStatusLog2",
+				mock.MatchedBy(func(params *waypoint_service.WaypointServiceSendStatusLog2Params) bool {
+					return params.Body.StatusLog.Log == "one\ntwo\noutput truncated, limit reached"
+				}),
+				mock.Anything, // authInfo
+			).
+			Return(&waypoint_service.WaypointServiceSendStatusLog2OK{}, nil).
+			Once()
+
+		_, err = e.Execute(context.TODO(), api, &profile, &opInfo)
+		r.NoError(err)
+	})
+
+	t.Run("streamed output without newlines is split", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var e Executor
+
+		// The output is too long to log.
+		e.Log = hclog.NewNullLogger()
+		hcl := `
+			group "test" {
+				action "launch" {
+					run {
+						command       = ["sh", "-c", "head -c 10000 /dev/zero | tr '\\0' x"]
+						stream_output = "stdout"
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		profile := profile.Profile{
+			OrganizationID: "test-org-id",
+			ProjectID:      "test-proj-id",
+		}
+
+		opInfo := models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "test-run-id",
+		}
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		var logs []string
+
+		api.EXPECT().
+			WaypointServiceSendStatusLog2(mock.Anything, mock.Anything).
+			RunAndReturn(func(params *waypoint_service.WaypointServiceSendStatusLog2Params, _ runtime.ClientAuthInfoWriter, _ ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceSendStatusLog2OK, error) {
+				logs = append(logs, params.Body.StatusLog.Log)
+				return &waypoint_service.WaypointServiceSendStatusLog2OK{}, nil
+			})
+
+		_, err = e.Execute(context.TODO(), api, &profile, &opInfo)
+		r.NoError(err)
+
+		r.Len(logs, 3)
+		for _, l := range logs {
+			r.LessOrEqual(len(l), statusLogMaxLineBytes)
+		}
+		r.Equal(strings.Repeat("x", 10000), strings.Join(logs, ""))
+	})
+
+	t.Run("streamed output is dropped while status logs can't be sent", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var e Executor
+
+		// The output is too long to log.
+		e.Log = hclog.NewNullLogger()
+		hcl := `
+			group "test" {
+				action "launch" {
+					run {
+						command       = ["sh", "-c", "head -c 200000 /dev/zero | tr '\\0' x"]
+						stream_output = "stdout"
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		profile := profile.Profile{
+			OrganizationID: "test-org-id",
+			ProjectID:      "test-proj-id",
+		}
+
+		opInfo := models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "launch",
+			ActionRunID: "test-run-id",
+		}
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		// The first status log is held up until well after the command has
+		// written its output.
+		release := make(chan struct{})
+		time.AfterFunc(time.Second, func() { close(release) })
+
+		var (
+			sent    int
+			dropped bool
+		)
+
+		api.EXPECT().
+			WaypointServiceSendStatusLog2(mock.Anything, mock.Anything).
+			RunAndReturn(func(params *waypoint_service.WaypointServiceSendStatusLog2Params, _ runtime.ClientAuthInfoWriter, _ ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceSendStatusLog2OK, error) {
+				<-release
+
+				log := params.Body.StatusLog.Log
+				if strings.HasSuffix(log, "output was written faster than it could be sent") {
+					dropped = true
+				} else {
+					sent += len(log)
+				}
+
+				return &waypoint_service.WaypointServiceSendStatusLog2OK{}, nil
+			})
+
+		_, err = e.Execute(context.TODO(), api, &profile, &opInfo)
+		r.NoError(err)
+
+		r.True(dropped)
+		r.Less(sent, 200000)
+	})
+
+	t.Run("http operations send the configured request", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+
+			if req.Method != http.MethodPost ||
+				req.Header.Get("Authorization") != "Bearer abc" ||
+				string(body) != `{"app":"web"}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"data": {"id": "dep-1", "count": 2}}`))
+		}))
+		defer srv.Close()
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "notify" {
+					http {
+						url     = "` + srv.URL + `"
+						method  = "POST"
+						headers = { Authorization = "Bearer ${var.token}" }
+						body    = "{\"app\":\"${var.app}\"}"
+						capture = { id = "data.id", count = "data.count" }
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		data, err := json.Marshal(map[string]any{
+			"var.token": "abc",
+			"var.app":   "web",
+		})
+		r.NoError(err)
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "notify",
+			Body:  data,
+		})
+		r.NoError(err)
+
+		r.Equal(0, status.Code)
+		r.Equal("202", status.Values["status_code"])
+		r.Equal("dep-1", status.Values["id"])
+		r.Equal("2", status.Values["count"])
+	})
+
+	t.Run("http operations don't capture fields from oversized responses", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_, _ = w.Write([]byte(`{"id": "dep-1", "pad": "` + strings.Repeat("x", httpMaxCaptureBytes) + `"}`))
+		}))
+		defer srv.Close()
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "notify" {
+					http {
+						url     = "` + srv.URL + `"
+						capture = { id = "id" }
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "notify",
+		})
+		r.NoError(err)
+
+		r.Equal(1, status.Code)
+		r.Contains(status.Status, "response is larger than")
+		r.NotContains(status.Values, "id")
+	})
+
+	t.Run("http operations fail on unexpected status codes after retrying", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var attempts atomic.Int32
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "notify" {
+					http {
+						url     = "` + srv.URL + `"
+						retries = 1
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "notify",
+		})
+		r.NoError(err)
+
+		r.Equal(1, status.Code)
+		r.Equal("503", status.Values["status_code"])
+		r.Equal(int32(2), attempts.Load())
+	})
+
+	t.Run("http operations are cancelled with the context", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			<-req.Context().Done()
+		}))
+		defer srv.Close()
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "notify" {
+					http {
+						url = "` + srv.URL + `"
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err = e.Execute(ctx, nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "notify",
+		})
+		r.ErrorIs(err, context.DeadlineExceeded)
+	})
+
+	t.Run("compound operations stop at the first failed step", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "deploy" {
+					operation {
+						name = "build"
+						run {
+							command = ["sh", "-c", "echo built"]
+						}
+					}
+
+					operation {
+						name = "migrate"
+						run {
+							command = ["sh", "-c", "echo migration failed; exit 3"]
+						}
+					}
+
+					operation {
+						run {
+							command = ["touch", "` + dir + `/released"]
+						}
+					}
+
+					on_failure {
+						run {
+							command = ["touch", "` + dir + `/rolled-back"]
+						}
+					}
+
+					finally {
+						run {
+							command = ["touch", "` + dir + `/cleaned-up"]
+						}
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "deploy",
+		})
+		r.NoError(err)
+
+		r.Equal(3, status.Code)
+		r.Equal("migrate", status.Values["failed_step"])
+		r.Equal("step migrate failed: output: migration failed", status.Status)
+
+		r.NoFileExists(filepath.Join(dir, "released"))
+		r.FileExists(filepath.Join(dir, "rolled-back"))
+		r.FileExists(filepath.Join(dir, "cleaned-up"))
+	})
+
+	t.Run("failure handlers run once the operation is cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "deploy" {
+					run {
+						command = ["sleep", "30"]
+					}
+
+					on_failure {
+						run {
+							command = ["touch", "` + dir + `/rolled-back"]
+						}
+					}
+
+					finally {
+						run {
+							command = ["touch", "` + dir + `/cleaned-up"]
+						}
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err = e.Execute(ctx, nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "deploy",
+		})
+		r.ErrorIs(err, context.DeadlineExceeded)
+
+		r.FileExists(filepath.Join(dir, "rolled-back"))
+		r.FileExists(filepath.Join(dir, "cleaned-up"))
+	})
+
+	t.Run("operations can time out", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "hang" {
+					timeout = "50ms"
+
+					run {
+						command = ["sleep", "30"]
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "hang",
+		})
+		r.NoError(err)
+
+		r.Equal(statusCodeTimeout, status.Code)
+		r.Equal("timed out after 50ms", status.Status)
+	})
+
+	t.Run("operations can be retried", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		counter := filepath.Join(t.TempDir(), "attempts")
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "flaky" {
+					retry {
+						attempts = 3
+						backoff  = "1ms"
+					}
+
+					run {
+						command = ["sh", "-c", "echo x >> ` + counter + `; [ $(wc -l < ` + counter + `) -ge 2 ]"]
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "flaky",
+		})
+		r.NoError(err)
+		r.Equal(0, status.Code)
+
+		data, err := os.ReadFile(counter)
+		r.NoError(err)
+		r.Equal("x\nx\n", string(data))
+	})
+
+	t.Run("inputs are validated before running", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "deploy" {
+					input "version" {
+						type     = string
+						required = true
+					}
+
+					input "replicas" {
+						type = number
+					}
+
+					run {
+						command = ["touch", "` + dir + `/ran"]
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		data, err := json.Marshal(map[string]any{
+			"var.replicas": "lots",
+		})
+		r.NoError(err)
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "deploy",
+			Body:  data,
+		})
+		r.NoError(err)
+
+		r.Equal(statusCodeInvalidInput, status.Code)
+		r.Contains(status.Status, "version is required")
+		r.Contains(status.Status, "replicas must be number")
+		r.NoFileExists(filepath.Join(dir, "ran"))
+	})
+
+	t.Run("inputs convert lists, objects and defaults", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "deploy" {
+					input "regions" {
+						type = list(string)
+					}
+
+					input "app" {
+						type = object({ name = string, port = number })
+					}
+
+					input "channel" {
+						type    = string
+						default = "stable"
+					}
+
+					status {
+						message = "deploying"
+						values = {
+							regions = join(",", var.regions)
+							app     = "${var.app.name}:${var.app.port}"
+							channel = var.channel
+						}
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		data, err := json.Marshal(map[string]any{
+			"var.regions": []any{"us-east-1", "eu-west-1"},
+			"var.app":     map[string]any{"name": "web", "port": 8080},
+		})
+		r.NoError(err)
+
+		profile := profile.Profile{
+			OrganizationID: "test-org-id",
+			ProjectID:      "test-proj-id",
+		}
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		api.
+			On(
+				"WaypointServiceSendStatusLog2",
+				mock.MatchedBy(func(params *waypoint_service.WaypointServiceSendStatusLog2Params) bool {
+					md := params.Body.StatusLog.Metadata
+					return md["regions"] == "us-east-1,eu-west-1" &&
+						md["app"] == "web:8080" &&
+						md["channel"] == "stable"
+				}),
+				mock.Anything, // authInfo
+			).
+			Return(&waypoint_service.WaypointServiceSendStatusLog2OK{}, nil)
+
+		_, err = e.Execute(context.TODO(), api, &profile, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "deploy",
+			ActionRunID: "test-run-id",
+			Body:        data,
+		})
+		r.NoError(err)
+	})
+
+	t.Run("shell operations keep the end of their output", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var e Executor
+
+		e.Log = log
+		hcl := `
+			group "test" {
+				action "noisy" {
+					run {
+						command = ["sh", "-c", "for i in $(seq 1 2000); do echo line $i; done"]
+					}
+				}
+			}
+		`
+
+		cfg, err := ParseConfig(hcl)
+		r.NoError(err)
+
+		e.Config = cfg
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "noisy",
+		})
+		r.NoError(err)
+
+		r.LessOrEqual(len(status.Output), OutputTailBytes)
+		r.True(strings.HasPrefix(status.Output, "line "))
+		r.True(strings.HasSuffix(status.Output, "line 1999\nline 2000"))
+	})
+
+	t.Run("secrets and sensitive values are masked", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir := t.TempDir()
+		r.NoError(os.WriteFile(filepath.Join(dir, "token"), []byte("s3cr3t-token"), 0o600))
+
+		path := filepath.Join(dir, "agent.hcl")
+		r.NoError(os.WriteFile(path, []byte(`
+		secret "token" {
+			file = "token"
+		}
+
+		group "test" {
+			action "deploy" {
+				input "password" {
+					sensitive = true
+				}
+
+				operation {
+					status {
+						message = "logging in with ${var.password}"
+						values = { token = secret.token }
+					}
+				}
+
+				operation {
+					run {
+						command       = ["sh", "-c", "echo $TOKEN ${var.password} $API_KEY; exit 3"]
+						env           = { TOKEN = secret.token }
+						sensitive_env = { API_KEY = "k3y-value" }
+						stream_output = "stdout"
+					}
+				}
+			}
+		}
+`), 0o644))
+
+		cfg, err := ParseConfigFile(path)
+		r.NoError(err)
+		r.NoError(cfg.LoadSecrets())
+
+		e := Executor{Log: log, Config: cfg}
+
+		profile := profile.Profile{
+			OrganizationID: "test-org-id",
+			ProjectID:      "test-proj-id",
+		}
+
+		opInfo := models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "deploy",
+			ActionRunID: "test-run-id",
+			Body:        []byte(`{"var.password": "pa55word"}`),
+		}
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		var logs []*models.HashicorpCloudWaypointV20241122StatusLog
+
+		api.EXPECT().
+			WaypointServiceSendStatusLog2(mock.Anything, mock.Anything).
+			RunAndReturn(func(params *waypoint_service.WaypointServiceSendStatusLog2Params, _ runtime.ClientAuthInfoWriter, _ ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceSendStatusLog2OK, error) {
+				logs = append(logs, params.Body.StatusLog)
+				return &waypoint_service.WaypointServiceSendStatusLog2OK{}, nil
+			})
+
+		status, err := e.Execute(context.TODO(), api, &profile, &opInfo)
+		r.NoError(err)
+		r.Equal(3, status.Code)
+
+		masked := RedactedValue + " " + RedactedValue + " " + RedactedValue
+		r.Equal(masked, status.Output)
+
+		r.Len(logs, 2)
+		r.Equal("logging in with "+RedactedValue, logs[0].Log)
+		r.Equal(map[string]string{"token": RedactedValue}, logs[0].Metadata)
+		r.Equal(masked, logs[1].Log)
+	})
+
+	t.Run("sensitive inputs are masked in errors resolving the action", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+			group "test" {
+				action "deploy" {
+					input "timeout" {
+						sensitive = true
+					}
+
+					operation {
+						run {
+							command = ["./deploy.sh"]
+						}
+
+						timeout = var.timeout
+					}
+				}
+			}
+		`)
+		r.NoError(err)
+
+		e := Executor{Log: log, Config: cfg}
+
+		_, err = e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "deploy",
+			Body:  []byte(`{"var.timeout": "s3cr3t"}`),
+		})
+		r.ErrorContains(err, "invalid timeout")
+		r.NotContains(err.Error(), "s3cr3t")
+		r.Contains(err.Error(), RedactedValue)
+	})
+
+	t.Run("masked errors can still be inspected", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		m := NewMasker("s3cr3t")
+
+		err := m.MaskError(hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid value",
+			Detail:   "s3cr3t is not valid",
+		}})
+		r.EqualError(err, "<nil>: Invalid value; "+RedactedValue+" is not valid")
+
+		var diags hcl.Diagnostics
+		r.ErrorAs(err, &diags)
+		r.Len(diags, 1)
+	})
+
+	t.Run("parallel operations run their steps at the same time", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+			group "test" {
+				action "fan-out" {
+					parallel {
+						operation {
+							name = "east"
+							run {
+								command = ["sh", "-c", "sleep 0.5; echo east"]
+							}
+						}
+
+						operation {
+							name = "west"
+							run {
+								command = ["sh", "-c", "sleep 0.5; echo west"]
+							}
+						}
+					}
+				}
+			}
+		`)
+		r.NoError(err)
+
+		e := Executor{Log: log, Config: cfg}
+
+		start := time.Now()
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "fan-out",
+		})
+		r.NoError(err)
+
+		r.Less(time.Since(start), 900*time.Millisecond)
+		r.Equal(0, status.Code)
+		r.Equal("2 steps succeeded", status.Status)
+		r.Equal("step east:\neast\nstep west:\nwest", status.Output)
+	})
+
+	t.Run("parallel operations cancel the other steps on failure", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+			group "test" {
+				action "fan-out" {
+					parallel {
+						operation {
+							name = "broken"
+							run {
+								command = ["sh", "-c", "exit 4"]
+							}
+						}
+
+						operation {
+							name = "slow"
+							run {
+								command = ["sleep", "30"]
+							}
+						}
+					}
+				}
+			}
+		`)
+		r.NoError(err)
+
+		e := Executor{Log: log, Config: cfg}
+
+		start := time.Now()
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "fan-out",
+		})
+		r.NoError(err)
+
+		r.Less(time.Since(start), 10*time.Second)
+		r.Equal(4, status.Code)
+		r.True(strings.HasPrefix(status.Status, "step broken failed:"))
+		r.True(strings.HasSuffix(status.Status, "(cancelled 1 other steps)"))
+		r.Equal("broken", status.Values["failed_step"])
+		r.Equal("slow", status.Values["cancelled_steps"])
+	})
+
+	t.Run("parallel operations can wait for every step", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+			group "test" {
+				action "fan-out" {
+					parallel {
+						failure_policy = "wait_all"
+
+						operation {
+							run {
+								command = ["sh", "-c", "echo one; exit 3"]
+							}
+						}
+
+						operation {
+							run {
+								command = ["sh", "-c", "sleep 0.2; echo two"]
+							}
+						}
+
+						operation {
+							run {
+								command = ["sh", "-c", "sleep 0.4; exit 5"]
+							}
+						}
+					}
+				}
+			}
+		`)
+		r.NoError(err)
+
+		e := Executor{Log: log, Config: cfg}
+
+		status, err := e.Execute(context.TODO(), nil, nil, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "fan-out",
+		})
+		r.NoError(err)
+
+		r.Equal(3, status.Code)
+		r.Equal("2 of 3 steps failed, first step 1: output: one", status.Status)
+		r.Equal("1", status.Values["failed_step"])
+		r.Equal("1,3", status.Values["failed_steps"])
+		r.NotContains(status.Values, "cancelled_steps")
+	})
+
+	t.Run("action operations run other actions and wait for them", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+			group "test" {
+				action "release" {
+					input "version" {}
+
+					input "token" {
+						sensitive = true
+					}
+
+					action {
+						name          = "deploy"
+						application   = "web"
+						wait          = true
+						poll_interval = "10ms"
+						inputs = {
+							"var.version" = "v${var.version}"
+							"var.token"   = var.token
+						}
+					}
+				}
+			}
+		`)
+		r.NoError(err)
+
+		e := Executor{Log: log, Config: cfg}
+
+		profile := profile.Profile{
+			OrganizationID: "test-org-id",
+			ProjectID:      "test-proj-id",
+		}
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		api.EXPECT().
+			WaypointServiceRunAction(mock.MatchedBy(func(params *waypoint_service.WaypointServiceRunActionParams) bool {
+				overrides := params.Body.VariableOverrides
+
+				return params.NamespaceLocationOrganizationID == profile.OrganizationID &&
+					params.Body.ActionRef.Name == "deploy" &&
+					params.Body.Scope.Application.Name == "web" &&
+					len(overrides) == 2 &&
+					overrides[0].Key == "var.token" && overrides[0].Value == "hunter2" && overrides[0].Sensitive &&
+					overrides[1].Key == "var.version" && overrides[1].Value == "v1.2" && !overrides[1].Sensitive
+			}), mock.Anything).
+			Return(&waypoint_service.WaypointServiceRunActionOK{
+				Payload: &models.HashicorpCloudWaypointV20241122RunActionResponse{
+					ActionRun: &models.HashicorpCloudWaypointV20241122ActionRun{
+						ID:       "child-run",
+						Sequence: "7",
+					},
+				},
+			}, nil).
+			Once()
+
+		running := models.HashicorpCloudWaypointV20241122ActionRunResponseStatusNONE
+		success := models.HashicorpCloudWaypointV20241122ActionRunResponseStatusSUCCESS
+
+		isRun := mock.MatchedBy(func(params *waypoint_service.WaypointServiceGetActionRunParams) bool {
+			return *params.ActionName == "deploy" && *params.Sequence == "7"
+		})
+
+		api.EXPECT().
+			WaypointServiceGetActionRun(isRun, mock.Anything).
+			Return(&waypoint_service.WaypointServiceGetActionRunOK{
+				Payload: &models.HashicorpCloudWaypointV20241122GetActionRunResponse{
+					ActionRun: &models.HashicorpCloudWaypointV20241122ActionRun{ID: "child-run", Sequence: "7", ResponseStatus: &running},
+				},
+			}, nil).
+			Once()
+
+		api.EXPECT().
+			WaypointServiceGetActionRun(isRun, mock.Anything).
+			Return(&waypoint_service.WaypointServiceGetActionRunOK{
+				Payload: &models.HashicorpCloudWaypointV20241122GetActionRunResponse{
+					ActionRun: &models.HashicorpCloudWaypointV20241122ActionRun{ID: "child-run", Sequence: "7", ResponseStatus: &success},
+				},
+			}, nil).
+			Once()
+
+		status, err := e.Execute(context.TODO(), api, &profile, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "release",
+			ActionRunID: "parent-run",
+			Body:        []byte(`{"var.version": "1.2", "var.token": "hunter2"}`),
+		})
+		r.NoError(err)
+
+		r.Equal(0, status.Code)
+		r.Equal("action deploy run 7 finished: SUCCESS", status.Status)
+		r.Equal(map[string]string{
+			"action_run_id":       "child-run",
+			"action_run_sequence": "7",
+			"action_run_result":   "SUCCESS",
+		}, status.Values)
+	})
+
+	t.Run("action operations fail when the other action does", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+			group "test" {
+				action "release" {
+					action {
+						name          = "deploy"
+						wait          = true
+						poll_interval = "10ms"
+					}
+				}
+			}
+		`)
+		r.NoError(err)
+
+		e := Executor{Log: log, Config: cfg}
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		api.EXPECT().
+			WaypointServiceRunAction(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceRunActionOK{
+				Payload: &models.HashicorpCloudWaypointV20241122RunActionResponse{
+					ActionRun: &models.HashicorpCloudWaypointV20241122ActionRun{ID: "child-run", Sequence: "3"},
+				},
+			}, nil).
+			Once()
+
+		failed := models.HashicorpCloudWaypointV20241122ActionRunResponseStatusERROR
+
+		api.EXPECT().
+			WaypointServiceGetActionRun(mock.Anything, mock.Anything).
+			Return(&waypoint_service.WaypointServiceGetActionRunOK{
+				Payload: &models.HashicorpCloudWaypointV20241122GetActionRunResponse{
+					ActionRun: &models.HashicorpCloudWaypointV20241122ActionRun{ID: "child-run", Sequence: "3", ResponseStatus: &failed},
+				},
+			}, nil).
+			Once()
+
+		status, err := e.Execute(context.TODO(), api, &profile.Profile{}, &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group: "test",
+			ID:    "release",
+		})
+		r.NoError(err)
+
+		r.Equal(1, status.Code)
+		r.Equal("ERROR", status.Values["action_run_result"])
+		r.Equal("child-run", status.Values["action_run_id"])
+	})
+
+	t.Run("shell outputs are sent as status logs and can be used by later steps", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+			group "test" {
+				action "release" {
+					operation {
+						name = "build"
+						run {
+							command = ["sh", "-c", "echo image=web:1.2 >> $WAYPOINT_OUTPUT; echo '# done' >> $WAYPOINT_OUTPUT"]
+						}
+					}
+
+					operation {
+						name = "tag"
+						run {
+							command = ["sh", "-c", "echo '{\"tag\": \"stable\", \"replicas\": 3}' > $WAYPOINT_OUTPUT"]
+						}
+					}
+
+					operation {
+						run {
+							command = ["echo", "deploying ${step.build.values.image} as ${step.tag.values.tag} x${step.tag.values.replicas}, build ${step.build.code}"]
+						}
+					}
+				}
+			}
+		`)
+		r.NoError(err)
+
+		e := Executor{Log: log, Config: cfg}
+
+		opInfo := &models.HashicorpCloudWaypointV20241122AgentOperation{
+			Group:       "test",
+			ID:          "release",
+			ActionRunID: "test-run-id",
+		}
+
+		api := mock_waypoint_service.NewMockClientService(t)
+
+		var logs []*models.HashicorpCloudWaypointV20241122StatusLog
+
+		api.EXPECT().
+			WaypointServiceSendStatusLog2(mock.Anything, mock.Anything).
+			RunAndReturn(func(params *waypoint_service.WaypointServiceSendStatusLog2Params, _ runtime.ClientAuthInfoWriter, _ ...waypoint_service.ClientOption) (*waypoint_service.WaypointServiceSendStatusLog2OK, error) {
+				logs = append(logs, params.Body.StatusLog)
+				return &waypoint_service.WaypointServiceSendStatusLog2OK{}, nil
+			}).
+			Times(2)
+
+		status, err := e.Execute(context.TODO(), api, &profile.Profile{}, opInfo)
+		r.NoError(err)
+
+		r.Equal(0, status.Code)
+		r.Equal("output: deploying web:1.2 as stable x3, build 0", status.Status)
+
+		r.Len(logs, 2)
+		r.Equal("outputs: image", logs[0].Log)
+		r.Equal(map[string]string{"image": "web:1.2"}, logs[0].Metadata)
+		r.Equal("outputs: replicas, tag", logs[1].Log)
+		r.Equal(map[string]string{"replicas": "3", "tag": "stable"}, logs[1].Metadata)
+	})
+
+	t.Run("steps can only refer to steps that ran before them", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		cfg, err := ParseConfig(`
+			group "test" {
+				action "release" {
+					operation {
+						run {
+							command = ["echo", step.deploy.status]
+						}
+					}
+
+					operation {
+						name = "deploy"
+						run {
+							command = ["true"]
+						}
+					}
+				}
+			}
+		`)
+		r.NoError(err)
+
+		_, err = cfg.Action("test", "release", nil)
+		r.ErrorContains(err, "deploy")
+
+		r.Len(cfg.Validate(), 1)
+	})
 }
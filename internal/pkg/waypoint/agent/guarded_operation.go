@@ -0,0 +1,79 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+// guardHandlerTimeout bounds each OnFailure and Finally operation, which run
+// even once the context of the operation they guard is done.
+const guardHandlerTimeout = 5 * time.Minute
+
+// GuardedOperation runs OnFailure if an operation fails and Finally after it,
+// regardless of the result. The status of the guarded operation is returned
+// unchanged.
+type GuardedOperation struct {
+	Operation Operation
+	OnFailure Operation
+	Finally   Operation
+}
+
+func (g *GuardedOperation) Run(
+	ctx context.Context,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (OperationStatus, error) {
+	status, err := g.Operation.Run(ctx, log, api, profile, opInfo)
+
+	if g.OnFailure != nil && failed(status, err) {
+		log.Info("running on_failure operation", "status", status.Status, "status-code", status.Code, "error", err)
+
+		fs, ferr := runHandler(ctx, g.OnFailure, log, api, profile, opInfo)
+		if failed(fs, ferr) {
+			log.Error("on_failure operation failed", "status", fs.Status, "status-code", fs.Code, "error", ferr)
+		}
+	}
+
+	if g.Finally != nil {
+		fs, ferr := runHandler(ctx, g.Finally, log, api, profile, opInfo)
+		if failed(fs, ferr) {
+			log.Error("finally operation failed", "status", fs.Status, "status-code", fs.Code, "error", ferr)
+		}
+	}
+
+	return status, err
+}
+
+// runHandler runs op as a handler of a guarded operation. Rollback and cleanup
+// matter most when the guarded operation was cancelled, by a timeout around it
+// or the agent shutting down, so op isn't cancelled with ctx, only when it
+// runs past guardHandlerTimeout.
+func runHandler(
+	ctx context.Context,
+	op Operation,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (OperationStatus, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), guardHandlerTimeout)
+	defer cancel()
+
+	return op.Run(ctx, log, api, profile, opInfo)
+}
+
+// failed reports whether an operation failed, either by returning an error or
+// a non-zero code.
+func failed(status OperationStatus, err error) bool {
+	return err != nil || status.Code != 0
+}
@@ -5,7 +5,17 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
@@ -13,8 +23,44 @@ import (
 	"github.com/hashicorp/hcp/internal/pkg/profile"
 )
 
+const (
+	defaultHTTPTimeout = 30 * time.Second
+
+	// httpRetryBackoff is the wait before the first retry of a request. It
+	// doubles with each attempt.
+	httpRetryBackoff = time.Second
+
+	// httpMaxCaptureBytes limits how much of a response is read to capture
+	// fields from it. Fields can't be captured from larger responses.
+	httpMaxCaptureBytes = 1 << 20
+)
+
 type HTTPOperation struct {
-	URL string
+	URL     string
+	Method  string
+	Headers map[string]string
+	Body    string
+	Timeout time.Duration
+	TLS     *HTTPTLSOptions
+
+	// ExpectedStatus lists the response codes that count as success. If
+	// empty, any 2xx code does.
+	ExpectedStatus []int
+
+	// Retries is how many more times the request is attempted when it fails
+	// to send or the server responds with a 5xx code.
+	Retries int
+
+	// Capture maps the name of a value to report to a dot separated path of
+	// a field in the JSON response, such as "data.items.0.id".
+	Capture map[string]string
+}
+
+type HTTPTLSOptions struct {
+	// CAFile is resolved from the directory of the config if relative.
+	CAFile             string `hcl:"ca_file,optional"`
+	InsecureSkipVerify bool   `hcl:"insecure_skip_verify,optional"`
+	ServerName         string `hcl:"server_name,optional"`
 }
 
 func (h *HTTPOperation) Run(
@@ -24,12 +70,211 @@ func (h *HTTPOperation) Run(
 	profile *profile.Profile,
 	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
 ) (OperationStatus, error) {
-	resp, err := http.Get(h.URL)
+	client, err := h.client()
+	if err != nil {
+		return errStatus, err
+	}
+
+	var (
+		resp *http.Response
+		body []byte
+	)
+
+	backoff := httpRetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		resp, body, err = h.do(ctx, client)
+
+		retryable := err != nil || (resp.StatusCode >= 500 && !h.expected(resp.StatusCode))
+		if !retryable || attempt >= h.Retries || ctx.Err() != nil {
+			break
+		}
+
+		log.Warn("http operation failed, retrying", "url", h.URL, "attempt", attempt+1, "error", err, "status", statusOf(resp))
+
+		select {
+		case <-ctx.Done():
+			return errStatus, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+
 	if err != nil {
 		return errStatus, err
 	}
 
+	status := OperationStatus{
+		Status: "response: " + resp.Status,
+		Values: map[string]string{
+			"status_code": strconv.Itoa(resp.StatusCode),
+		},
+	}
+
+	if !h.expected(resp.StatusCode) {
+		status.Code = 1
+		status.Status = "unexpected response: " + resp.Status
+		return status, nil
+	}
+
+	if len(h.Capture) > 0 {
+		if len(body) > httpMaxCaptureBytes {
+			status.Code = 1
+			status.Status = fmt.Sprintf("unable to capture fields, response is larger than %d bytes", httpMaxCaptureBytes)
+			return status, nil
+		}
+
+		var doc any
+
+		if err := json.Unmarshal(body, &doc); err != nil {
+			status.Code = 1
+			status.Status = "unable to capture fields, response is not json: " + err.Error()
+			return status, nil
+		}
+
+		for name, path := range h.Capture {
+			val, err := lookupJSONPath(doc, path)
+			if err != nil {
+				status.Code = 1
+				status.Status = fmt.Sprintf("unable to capture %s: %s", name, err)
+				return status, nil
+			}
+
+			status.Values[name] = val
+		}
+	}
+
+	return status, nil
+}
+
+func (h *HTTPOperation) do(ctx context.Context, client *http.Client) (*http.Response, []byte, error) {
+	method := h.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var reqBody io.Reader
+	if h.Body != "" {
+		reqBody = strings.NewReader(h.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, h.URL, reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	defer func() { _ = resp.Body.Close() }()
 
-	return cleanStatus, nil
+	// Read one byte past the limit so larger responses can be told apart.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, httpMaxCaptureBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, body, nil
+}
+
+func (h *HTTPOperation) client() (*http.Client, error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+	}
+
+	if h.TLS == nil {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: h.TLS.InsecureSkipVerify,
+		ServerName:         h.TLS.ServerName,
+	}
+
+	if h.TLS.CAFile != "" {
+		data, err := os.ReadFile(h.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ca_file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in ca_file %s", h.TLS.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client.Transport = transport
+
+	return client, nil
+}
+
+func (h *HTTPOperation) expected(code int) bool {
+	if len(h.ExpectedStatus) == 0 {
+		return code >= 200 && code < 300
+	}
+
+	return slices.Contains(h.ExpectedStatus, code)
+}
+
+func statusOf(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+
+	return resp.Status
+}
+
+// lookupJSONPath walks a decoded JSON document following a dot separated path
+// and returns the value found as a string. Strings are returned as is, other
+// values are returned as JSON.
+func lookupJSONPath(doc any, path string) (string, error) {
+	cur := doc
+
+	for _, part := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]any:
+			next, ok := v[part]
+			if !ok {
+				return "", fmt.Errorf("field %q not found", path)
+			}
+
+			cur = next
+		case []any:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return "", fmt.Errorf("invalid index %q in %q", part, path)
+			}
+
+			cur = v[idx]
+		default:
+			return "", fmt.Errorf("field %q not found", path)
+		}
+	}
+
+	if str, ok := cur.(string); ok {
+		return str, nil
+	}
+
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
 }
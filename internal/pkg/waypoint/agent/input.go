@@ -0,0 +1,222 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// statusCodeInvalidInput is reported when an operation's body does not match
+// the inputs its action declares. It matches EX_USAGE from sysexits.h.
+const statusCodeInvalidInput = 64
+
+// Input is a value an action expects in the body of the operations that run
+// it. Inputs are available to the action as var.<name>.
+type Input struct {
+	Name        string
+	Description string
+	Type        cty.Type
+	Default     cty.Value
+	Required    bool
+	Sensitive   bool
+}
+
+type hclInput struct {
+	Name        string         `hcl:",label"`
+	Type        hcl.Expression `hcl:"type,optional"`
+	Default     hcl.Expression `hcl:"default,optional"`
+	Required    bool           `hcl:"required,optional"`
+	Description string         `hcl:"description,optional"`
+	Sensitive   bool           `hcl:"sensitive,optional"`
+}
+
+func convertInputs(hinputs []*hclInput) ([]*Input, error) {
+	var inputs []*Input
+
+	seen := make(map[string]bool)
+
+	for _, hi := range hinputs {
+		if seen[hi.Name] {
+			return nil, fmt.Errorf("input %q is declared more than once", hi.Name)
+		}
+
+		seen[hi.Name] = true
+
+		in := &Input{
+			Name:        hi.Name,
+			Description: hi.Description,
+			Type:        cty.DynamicPseudoType,
+			Required:    hi.Required,
+			Sensitive:   hi.Sensitive,
+		}
+
+		if hi.Type != nil && !isNullExpr(hi.Type) {
+			ty, diag := typeexpr.TypeConstraint(hi.Type)
+			if diag.HasErrors() {
+				return nil, diag
+			}
+
+			in.Type = ty
+		}
+
+		in.Default = cty.NullVal(in.Type)
+
+		if hi.Default != nil {
+			val, diag := hi.Default.Value(nil)
+			if diag.HasErrors() {
+				return nil, diag
+			}
+
+			if !val.IsNull() {
+				val, err := convert.Convert(val, in.Type)
+				if err != nil {
+					return nil, fmt.Errorf("input %q: invalid default: %w", hi.Name, err)
+				}
+
+				in.Default = val
+			}
+		}
+
+		inputs = append(inputs, in)
+	}
+
+	return inputs, nil
+}
+
+// RedactedValue replaces the values of sensitive inputs in anything the agent
+// records.
+const RedactedValue = "(sensitive)"
+
+// RedactedBody returns the variables in the body of an operation for an action,
+// with the values of the inputs it declares sensitive replaced by
+// RedactedValue.
+func (c *Config) RedactedBody(group, id string, body []byte) (map[string]any, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	var rawInput map[string]any
+
+	if err := json.Unmarshal(body, &rawInput); err != nil {
+		return nil, err
+	}
+
+	vars, err := buildVariableMap(rawInput)
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := vars["var"].(map[string]any); ok {
+		for _, in := range c.Inputs(group, id) {
+			if _, set := v[in.Name]; set && in.Sensitive {
+				v[in.Name] = RedactedValue
+			}
+		}
+	}
+
+	return vars, nil
+}
+
+// sensitiveValues returns the values in the body of an operation for an action
+// of the inputs it declares sensitive, as they were given.
+func (c *Config) sensitiveValues(group, id string, body []byte) []string {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var rawInput map[string]any
+
+	if err := json.Unmarshal(body, &rawInput); err != nil {
+		return nil
+	}
+
+	vars, err := buildVariableMap(rawInput)
+	if err != nil {
+		return nil
+	}
+
+	_, ctyMap := anyToCty(vars)
+
+	return sensitiveStrings(c.Inputs(group, id), ctyMap["var"])
+}
+
+// sensitiveStrings returns the values in vars of the sensitive inputs, as
+// text.
+func sensitiveStrings(inputs []*Input, vars cty.Value) []string {
+	if vars == cty.NilVal || vars.IsNull() || !vars.Type().IsObjectType() {
+		return nil
+	}
+
+	var ret []string
+
+	for _, in := range inputs {
+		if in.Sensitive && vars.Type().HasAttribute(in.Name) {
+			ret = append(ret, ctyStrings(vars.GetAttr(in.Name))...)
+		}
+	}
+
+	return ret
+}
+
+// isNullExpr reports whether expr is the placeholder gohcl uses for an
+// attribute that was not set.
+func isNullExpr(expr hcl.Expression) bool {
+	if len(expr.Variables()) > 0 {
+		return false
+	}
+
+	val, diag := expr.Value(nil)
+	return !diag.HasErrors() && val.IsNull()
+}
+
+// validateInputs checks the var object passed to an action against the inputs
+// it declares. Defaults are applied and values are converted to the declared
+// types. Variables that are not declared are passed through unchanged.
+func validateInputs(inputs []*Input, vars cty.Value) (cty.Value, error) {
+	given := make(map[string]cty.Value)
+
+	if vars != cty.NilVal && !vars.IsNull() && vars.CanIterateElements() {
+		for k, v := range vars.AsValueMap() {
+			given[k] = v
+		}
+	}
+
+	var problems []string
+
+	for _, in := range inputs {
+		val, ok := given[in.Name]
+
+		if !ok || val.IsNull() {
+			if in.Required {
+				problems = append(problems, fmt.Sprintf("%s is required", in.Name))
+				continue
+			}
+
+			given[in.Name] = in.Default
+			continue
+		}
+
+		conv, err := convert.Convert(val, in.Type)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s must be %s: %s", in.Name, typeexpr.TypeString(in.Type), err))
+			continue
+		}
+
+		given[in.Name] = conv
+	}
+
+	if len(problems) > 0 {
+		return cty.NilVal, errors.New(strings.Join(problems, "; "))
+	}
+
+	return cty.ObjectVal(given), nil
+}
@@ -0,0 +1,207 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Masker replaces sensitive values in text with RedactedValue. It is safe for
+// concurrent use.
+type Masker struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// NewMasker returns a Masker for values.
+func NewMasker(values ...string) *Masker {
+	m := &Masker{}
+	m.Add(values...)
+
+	return m
+}
+
+// Add adds values to be masked. Empty values are ignored. Each line of a
+// multi-line value is also masked, since output is often handled line by
+// line.
+func (m *Masker) Add(values ...string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range values {
+		parts := []string{v}
+		if strings.Contains(v, "\n") {
+			parts = append(parts, strings.Split(v, "\n")...)
+		}
+
+		for _, p := range parts {
+			p = strings.TrimSpace(p)
+			if p != "" && !slices.Contains(m.values, p) {
+				m.values = append(m.values, p)
+			}
+		}
+	}
+
+	// Replace longer values first, so a value that contains another is
+	// masked whole.
+	slices.SortFunc(m.values, func(a, b string) int {
+		return len(b) - len(a)
+	})
+}
+
+// Mask returns s with every sensitive value replaced.
+func (m *Masker) Mask(s string) string {
+	if m == nil {
+		return s
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, v := range m.values {
+		s = strings.ReplaceAll(s, v, RedactedValue)
+	}
+
+	return s
+}
+
+// MaskValues returns a copy of values with every sensitive value masked.
+func (m *Masker) MaskValues(values map[string]string) map[string]string {
+	if m == nil || values == nil {
+		return values
+	}
+
+	ret := maps.Clone(values)
+	for k, v := range ret {
+		ret[k] = m.Mask(v)
+	}
+
+	return ret
+}
+
+// MaskStatus returns status with every sensitive value masked.
+func (m *Masker) MaskStatus(status OperationStatus) OperationStatus {
+	status.Status = m.Mask(status.Status)
+	status.Output = m.Mask(status.Output)
+	status.Values = m.MaskValues(status.Values)
+
+	return status
+}
+
+// MaskError returns err with every sensitive value masked in its message. The
+// returned error wraps err, so it can still be inspected with errors.Is and
+// errors.As. If nothing needs masking, err is returned unchanged.
+func (m *Masker) MaskError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if msg := m.Mask(err.Error()); msg != err.Error() {
+		return &maskedError{msg: msg, err: err}
+	}
+
+	return err
+}
+
+// maskedError is an error whose message has had sensitive values masked.
+type maskedError struct {
+	msg string
+	err error
+}
+
+func (e *maskedError) Error() string {
+	return e.msg
+}
+
+func (e *maskedError) Unwrap() error {
+	return e.err
+}
+
+type maskerKey struct{}
+
+// WithMasker returns a context carrying m, which operations use to mask the
+// sensitive values they come across.
+func WithMasker(ctx context.Context, m *Masker) context.Context {
+	return context.WithValue(ctx, maskerKey{}, m)
+}
+
+// MaskerFrom returns the Masker carried by ctx, or nil if there is none. A nil
+// Masker masks nothing.
+func MaskerFrom(ctx context.Context) *Masker {
+	m, _ := ctx.Value(maskerKey{}).(*Masker)
+	return m
+}
+
+// maskedLogger masks sensitive values in log messages and their arguments.
+type maskedLogger struct {
+	hclog.Logger
+	m *Masker
+}
+
+func newMaskedLogger(log hclog.Logger, m *Masker) hclog.Logger {
+	return &maskedLogger{Logger: log, m: m}
+}
+
+func (l *maskedLogger) maskArgs(args []any) []any {
+	ret := make([]any, len(args))
+
+	for i, a := range args {
+		switch v := a.(type) {
+		case string:
+			ret[i] = l.m.Mask(v)
+		case error:
+			ret[i] = l.m.MaskError(v)
+		default:
+			ret[i] = a
+		}
+	}
+
+	return ret
+}
+
+func (l *maskedLogger) Log(level hclog.Level, msg string, args ...any) {
+	l.Logger.Log(level, l.m.Mask(msg), l.maskArgs(args)...)
+}
+
+func (l *maskedLogger) Trace(msg string, args ...any) {
+	l.Logger.Trace(l.m.Mask(msg), l.maskArgs(args)...)
+}
+
+func (l *maskedLogger) Debug(msg string, args ...any) {
+	l.Logger.Debug(l.m.Mask(msg), l.maskArgs(args)...)
+}
+
+func (l *maskedLogger) Info(msg string, args ...any) {
+	l.Logger.Info(l.m.Mask(msg), l.maskArgs(args)...)
+}
+
+func (l *maskedLogger) Warn(msg string, args ...any) {
+	l.Logger.Warn(l.m.Mask(msg), l.maskArgs(args)...)
+}
+
+func (l *maskedLogger) Error(msg string, args ...any) {
+	l.Logger.Error(l.m.Mask(msg), l.maskArgs(args)...)
+}
+
+func (l *maskedLogger) With(args ...any) hclog.Logger {
+	return &maskedLogger{Logger: l.Logger.With(l.maskArgs(args)...), m: l.m}
+}
+
+func (l *maskedLogger) Named(name string) hclog.Logger {
+	return &maskedLogger{Logger: l.Logger.Named(name), m: l.m}
+}
+
+func (l *maskedLogger) ResetNamed(name string) hclog.Logger {
+	return &maskedLogger{Logger: l.Logger.ResetNamed(name), m: l.m}
+}
@@ -0,0 +1,156 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OutputFileEnv is the environment variable that holds the path of a file the
+// command of a shell operation can write outputs to, as key=value lines or a
+// JSON object. The outputs become values of the operation's status.
+const OutputFileEnv = "WAYPOINT_OUTPUT"
+
+const (
+	// dockerOutputPath is where the output file is mounted in containers.
+	dockerOutputPath = "/run/waypoint/output"
+
+	// maxOutputBytes is the largest output file the agent reads.
+	maxOutputBytes = 1 << 20
+)
+
+// outputFile is the file a command writes its outputs to. It is kept in a
+// directory of its own that only the agent and the command can read.
+type outputFile struct {
+	dir  string
+	path string
+}
+
+// newOutputFile creates an empty output file. If the command runs as another
+// user, the file is given to that user. Files for containers can be written
+// by any user, as the container's user isn't known, but are only reachable
+// through the mount.
+func newOutputFile(process *ProcessOptions, docker bool) (*outputFile, error) {
+	dir, err := os.MkdirTemp("", "waypoint-output-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create output file: %w", err)
+	}
+
+	o := &outputFile{
+		dir:  dir,
+		path: filepath.Join(dir, "output"),
+	}
+
+	mode := os.FileMode(0o600)
+	if docker {
+		mode = 0o666
+	}
+
+	err = os.WriteFile(o.path, nil, mode)
+	if err == nil {
+		// WriteFile's mode is subject to the umask.
+		err = os.Chmod(o.path, mode)
+	}
+
+	if err == nil && process != nil && (process.UID != nil || process.GID != nil) {
+		uid, gid := -1, -1
+		if process.UID != nil {
+			uid = int(*process.UID)
+		}
+		if process.GID != nil {
+			gid = int(*process.GID)
+		}
+
+		err = errors.Join(os.Chown(dir, uid, gid), os.Chown(o.path, uid, gid))
+	}
+
+	if err != nil {
+		_ = o.Close()
+		return nil, fmt.Errorf("unable to create output file: %w", err)
+	}
+
+	return o, nil
+}
+
+// Close removes the output file.
+func (o *outputFile) Close() error {
+	return os.RemoveAll(o.dir)
+}
+
+// read returns the outputs written to the file.
+func (o *outputFile) read() (map[string]string, error) {
+	f, err := os.Open(o.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxOutputBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxOutputBytes {
+		return nil, fmt.Errorf("outputs are larger than %d bytes", maxOutputBytes)
+	}
+
+	return parseOutputs(data)
+}
+
+// parseOutputs parses outputs written as a JSON object or as key=value lines.
+// In a JSON object, values that aren't strings are kept as JSON. Blank lines
+// and lines starting with # are skipped, and a key written more than once
+// keeps its last value.
+func parseOutputs(data []byte) (map[string]string, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	outputs := make(map[string]string)
+
+	if trimmed[0] == '{' {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &obj); err != nil {
+			return nil, fmt.Errorf("invalid JSON outputs: %w", err)
+		}
+
+		for k, raw := range obj {
+			var str string
+			if err := json.Unmarshal(raw, &str); err == nil {
+				outputs[k] = str
+			} else {
+				outputs[k] = string(raw)
+			}
+		}
+
+		return outputs, nil
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if t := strings.TrimSpace(line); t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+
+		if !ok || k == "" {
+			return nil, fmt.Errorf("line %d: expected key=value", i+1)
+		}
+
+		outputs[k] = v
+	}
+
+	return outputs, nil
+}
@@ -0,0 +1,169 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+// FailurePolicy controls what a ParallelOperation does when one of its steps
+// fails.
+type FailurePolicy string
+
+const (
+	// FailFast cancels the other steps as soon as one fails.
+	FailFast FailurePolicy = "fail_fast"
+
+	// WaitAll lets every step finish before reporting the failures.
+	WaitAll FailurePolicy = "wait_all"
+)
+
+// ParallelOperation runs its steps at the same time. It succeeds if every step
+// succeeds, in which case the values of the steps are merged in order. If steps
+// fail, the code of the first to fail is returned and the failed steps are
+// listed in its values.
+type ParallelOperation struct {
+	Operations []Operation
+
+	// Names holds a name for each operation, used to report which steps
+	// failed.
+	Names []string
+
+	FailurePolicy FailurePolicy
+}
+
+type parallelResult struct {
+	status OperationStatus
+	err    error
+}
+
+func (p *ParallelOperation) Run(
+	ctx context.Context,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (OperationStatus, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make([]parallelResult, len(p.Operations))
+
+		// failures holds the indexes of the failed steps, in the order
+		// they failed. Steps that fail after being cancelled because another
+		// failed are in cancelled instead.
+		failures  []int
+		cancelled []int
+	)
+
+	for i, op := range p.Operations {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			status, err := op.Run(ctx, log.With("step", p.name(i)), api, profile, opInfo)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[i] = parallelResult{status: status, err: err}
+
+			switch {
+			case !failed(status, err):
+			case p.FailurePolicy == WaitAll:
+				failures = append(failures, i)
+			case len(failures) > 0:
+				cancelled = append(cancelled, i)
+			default:
+				failures = append(failures, i)
+
+				log.Info("step failed, cancelling the other steps", "step", p.name(i))
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(failures) == 0 {
+		status := cleanStatus
+		status.Status = fmt.Sprintf("%d steps succeeded", len(p.Operations))
+
+		var outputs []string
+
+		for i, res := range results {
+			if len(res.status.Values) > 0 {
+				if status.Values == nil {
+					status.Values = make(map[string]string)
+				}
+
+				maps.Copy(status.Values, res.status.Values)
+			}
+
+			if res.status.Output != "" {
+				outputs = append(outputs, fmt.Sprintf("step %s:\n%s", p.name(i), res.status.Output))
+			}
+		}
+
+		status.Output = strings.Join(outputs, "\n")
+
+		return status, nil
+	}
+
+	first := failures[0]
+	res := results[first]
+
+	if res.err != nil {
+		return res.status, fmt.Errorf("step %s: %w", p.name(first), res.err)
+	}
+
+	var names []string
+	for _, i := range failures {
+		names = append(names, p.name(i))
+	}
+
+	status := res.status
+	status.Values = maps.Clone(status.Values)
+	if status.Values == nil {
+		status.Values = make(map[string]string)
+	}
+
+	status.Values["failed_step"] = p.name(first)
+	status.Values["failed_steps"] = strings.Join(names, ",")
+
+	if len(failures) == 1 {
+		status.Status = fmt.Sprintf("step %s failed: %s", p.name(first), status.Status)
+	} else {
+		status.Status = fmt.Sprintf("%d of %d steps failed, first step %s: %s", len(failures), len(p.Operations), p.name(first), status.Status)
+	}
+
+	if len(cancelled) > 0 {
+		names = nil
+		for _, i := range cancelled {
+			names = append(names, p.name(i))
+		}
+
+		status.Values["cancelled_steps"] = strings.Join(names, ",")
+		status.Status += fmt.Sprintf(" (cancelled %d other steps)", len(cancelled))
+	}
+
+	return status, nil
+}
+
+func (p *ParallelOperation) name(i int) string {
+	return stepName(p.Names, i)
+}
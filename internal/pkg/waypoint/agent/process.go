@@ -0,0 +1,235 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+)
+
+// EnvPolicy controls which of the agent's environment variables the processes
+// started by shell operations inherit.
+type EnvPolicy string
+
+const (
+	// EnvPolicyInherit passes the agent's whole environment.
+	EnvPolicyInherit EnvPolicy = "inherit"
+
+	// EnvPolicyAllowlist passes only the variables named in EnvAllow.
+	EnvPolicyAllowlist EnvPolicy = "allowlist"
+
+	// EnvPolicyEmpty passes none of the agent's environment.
+	EnvPolicyEmpty EnvPolicy = "empty"
+)
+
+// limitNames are the resource limits a process block can set. They are named
+// as they are for prlimit(1).
+var limitNames = []string{"as", "core", "cpu", "data", "fsize", "memlock", "nofile", "nproc", "stack"}
+
+// LimitExitCode is the exit code when the limits of a process can't be
+// applied or its command can't be run, as a shell reports commands it can't
+// execute.
+const LimitExitCode = 126
+
+// ProcessOptions control the environment and privileges of the processes
+// started by shell operations. Variables set by an operation's env and
+// sensitive_env are added whatever the policy. For operations run in a
+// container, the policy applies to the variables passed to the container
+// rather than to the docker command, and the other options can't be set.
+type ProcessOptions struct {
+	EnvPolicy EnvPolicy
+
+	// EnvAllow names the variables kept by EnvPolicyAllowlist. A name ending
+	// in * keeps every variable with that prefix.
+	EnvAllow []string
+
+	// WorkDir is the directory the process runs in. Empty uses the agent's.
+	WorkDir string
+
+	// UID and GID are the user and group the process runs as, if set. They
+	// are only supported on Linux.
+	UID *uint32
+	GID *uint32
+
+	// Limits are resource limits, by prlimit(1) name, applied to the process
+	// before it runs the command. They are only supported on Linux.
+	Limits map[string]uint64
+}
+
+// hclProcess is a process block. It can appear at the top level of the
+// config, where it applies to every action, and in actions and their nested
+// blocks, where the attributes it sets override the enclosing ones.
+type hclProcess struct {
+	EnvPolicy *string           `hcl:"env_policy,optional"`
+	EnvAllow  []string          `hcl:"env_allow,optional"`
+	WorkDir   *string           `hcl:"workdir,optional"`
+	UID       *uint32           `hcl:"uid,optional"`
+	GID       *uint32           `hcl:"gid,optional"`
+	Limits    map[string]uint64 `hcl:"limits,optional"`
+}
+
+// merge returns p with the attributes set by hp overriding its own. Relative
+// working directories are resolved from baseDir.
+func (p *ProcessOptions) merge(hp *hclProcess, baseDir string) (*ProcessOptions, error) {
+	ret := &ProcessOptions{}
+	if p != nil {
+		*ret = *p
+	}
+
+	if hp.EnvPolicy != nil {
+		switch policy := EnvPolicy(*hp.EnvPolicy); policy {
+		case EnvPolicyInherit, EnvPolicyAllowlist, EnvPolicyEmpty:
+			ret.EnvPolicy = policy
+		default:
+			return nil, fmt.Errorf("env_policy must be one of %q, %q or %q", EnvPolicyInherit, EnvPolicyAllowlist, EnvPolicyEmpty)
+		}
+	}
+
+	if hp.EnvAllow != nil {
+		ret.EnvAllow = hp.EnvAllow
+	}
+
+	if ret.EnvPolicy == EnvPolicyAllowlist && len(ret.EnvAllow) == 0 {
+		return nil, fmt.Errorf("env_allow must be set when env_policy is %q", EnvPolicyAllowlist)
+	}
+
+	if hp.WorkDir != nil {
+		ret.WorkDir = *hp.WorkDir
+		if ret.WorkDir != "" && !filepath.IsAbs(ret.WorkDir) {
+			ret.WorkDir = filepath.Join(baseDir, ret.WorkDir)
+		}
+	}
+
+	if hp.UID != nil {
+		ret.UID = hp.UID
+	}
+
+	if hp.GID != nil {
+		ret.GID = hp.GID
+	}
+
+	if len(hp.Limits) > 0 {
+		limits := maps.Clone(ret.Limits)
+		if limits == nil {
+			limits = make(map[string]uint64)
+		}
+
+		for name, v := range hp.Limits {
+			if !slices.Contains(limitNames, name) {
+				return nil, fmt.Errorf("unknown limit %q, must be one of %s", name, strings.Join(limitNames, ", "))
+			}
+
+			limits[name] = v
+		}
+
+		ret.Limits = limits
+	}
+
+	return ret, nil
+}
+
+// decodeProcess decodes a process block and merges it over p.
+func (p *ProcessOptions) decodeProcess(hctx *hcl.EvalContext, blk *hcl.Block, baseDir string) (*ProcessOptions, error) {
+	var hp hclProcess
+
+	diag := gohcl.DecodeBody(blk.Body, hctx, &hp)
+	if diag.HasErrors() {
+		return nil, diag
+	}
+
+	ret, err := p.merge(&hp, baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	return ret, nil
+}
+
+// environ returns the variables of env, in os.Environ form, that the policy
+// passes on.
+func (p *ProcessOptions) environ(env []string) []string {
+	if p == nil {
+		return slices.Clone(env)
+	}
+
+	switch p.EnvPolicy {
+	case EnvPolicyEmpty:
+		return nil
+	case EnvPolicyAllowlist:
+		var ret []string
+
+		for _, kv := range env {
+			name, _, _ := strings.Cut(kv, "=")
+			if p.allowed(name) {
+				ret = append(ret, kv)
+			}
+		}
+
+		return ret
+	default:
+		return slices.Clone(env)
+	}
+}
+
+// containerEnv returns the names of the variables of env, in os.Environ
+// form, passed on to containers. Containers have the environment of their
+// image, so they only get the agent's variables an allowlist names.
+func (p *ProcessOptions) containerEnv(env []string) []string {
+	if p == nil || p.EnvPolicy != EnvPolicyAllowlist {
+		return nil
+	}
+
+	var names []string
+
+	for _, kv := range p.environ(env) {
+		name, _, _ := strings.Cut(kv, "=")
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+func (p *ProcessOptions) allowed(name string) bool {
+	for _, allow := range p.EnvAllow {
+		if prefix, ok := strings.CutSuffix(allow, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == allow {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply sets the working directory, credentials and resource limits of c.
+func (p *ProcessOptions) apply(c *exec.Cmd) error {
+	if p == nil {
+		return nil
+	}
+
+	c.Dir = p.WorkDir
+
+	// Limits are applied by the agent itself before it runs the command, so
+	// it takes on the credentials too.
+	if len(p.Limits) > 0 {
+		return setLimits(c, p.Limits, p.UID, p.GID)
+	}
+
+	if p.UID != nil || p.GID != nil {
+		return setCredential(c, p.UID, p.GID)
+	}
+
+	return nil
+}
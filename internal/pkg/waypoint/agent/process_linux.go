@@ -0,0 +1,170 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var limitResources = map[string]int{
+	"as":      unix.RLIMIT_AS,
+	"core":    unix.RLIMIT_CORE,
+	"cpu":     unix.RLIMIT_CPU,
+	"data":    unix.RLIMIT_DATA,
+	"fsize":   unix.RLIMIT_FSIZE,
+	"memlock": unix.RLIMIT_MEMLOCK,
+	"nofile":  unix.RLIMIT_NOFILE,
+	"nproc":   unix.RLIMIT_NPROC,
+	"stack":   unix.RLIMIT_STACK,
+}
+
+// setCredential runs c as uid and gid. Whichever is not set stays the
+// agent's own. Supplementary groups are dropped.
+func setCredential(c *exec.Cmd, uid, gid *uint32) error {
+	cred := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+
+	if uid != nil {
+		cred.Uid = *uid
+	}
+
+	if gid != nil {
+		cred.Gid = *gid
+	}
+
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	c.SysProcAttr.Credential = cred
+
+	return nil
+}
+
+// execLimitedCommand is the hidden agent command that applies limits and
+// credentials to itself and then runs a command in its place.
+var execLimitedCommand = []string{"waypoint", "agent", "exec-limited"}
+
+// setLimits makes c apply limits, both soft and hard, before it runs its
+// command. The agent's own executable is run in its place, with its hidden
+// exec-limited command, to apply them to itself and then exec the command, so
+// the command never runs without them. The uid and gid, if set, are switched
+// to only once the limits are applied, since the agent's own credentials may
+// be needed to raise them.
+func setLimits(c *exec.Cmd, limits map[string]uint64, uid, gid *uint32) error {
+	if c.Err != nil {
+		return c.Err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to find the agent's executable to apply limits with: %w", err)
+	}
+
+	args := append([]string{self}, execLimitedCommand...)
+
+	for _, name := range slices.Sorted(maps.Keys(limits)) {
+		args = append(args, "--limit", name+"="+strconv.FormatUint(limits[name], 10))
+	}
+
+	if uid != nil || gid != nil {
+		u, g := uint32(os.Getuid()), uint32(os.Getgid())
+		if uid != nil {
+			u = *uid
+		}
+
+		if gid != nil {
+			g = *gid
+		}
+
+		args = append(args, "--user", fmt.Sprintf("%d:%d", u, g))
+	}
+
+	c.Args = append(append(args, "--", c.Path), c.Args...)
+	c.Path = self
+
+	return nil
+}
+
+// ExecLimited applies limits, given as name=value, to the agent and switches
+// to user, given as uid:gid, if set. It then runs the command at path in its
+// place, with args starting with the command's name. It only returns if the
+// command can't be run.
+func ExecLimited(limits []string, user string, path string, args []string) error {
+	for _, pair := range limits {
+		name, value, _ := strings.Cut(pair, "=")
+
+		resource, ok := limitResources[name]
+		if !ok {
+			return fmt.Errorf("unknown limit %q", name)
+		}
+
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s limit: %w", name, err)
+		}
+
+		// syscall.Setrlimit, unlike unix.Setrlimit, keeps the runtime from
+		// restoring its own nofile limit when it execs.
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: v, Max: v}); err != nil {
+			return fmt.Errorf("unable to set %s limit: %w", name, err)
+		}
+	}
+
+	if user != "" {
+		if err := setUser(user); err != nil {
+			return err
+		}
+	}
+
+	err := syscall.Exec(path, args, os.Environ())
+	return fmt.Errorf("unable to run %s: %w", path, err)
+}
+
+// setUser switches the agent to user, given as uid:gid, dropping its
+// supplementary groups.
+func setUser(user string) error {
+	u, g, ok := strings.Cut(user, ":")
+	if !ok {
+		return fmt.Errorf("invalid user %q, must be uid:gid", user)
+	}
+
+	uid, err := strconv.Atoi(u)
+	if err != nil {
+		return fmt.Errorf("invalid uid: %w", err)
+	}
+
+	gid, err := strconv.Atoi(g)
+	if err != nil {
+		return fmt.Errorf("invalid gid: %w", err)
+	}
+
+	// The groups go first, since they can't be changed once the uid has.
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("unable to drop supplementary groups: %w", err)
+	}
+
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("unable to set gid: %w", err)
+	}
+
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("unable to set uid: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain lets the test binary stand in for the agent's executable when it
+// runs commands with limits, parsing the arguments of its hidden exec-limited
+// command.
+func TestMain(m *testing.M) {
+	n := len(execLimitedCommand) + 1
+	if len(os.Args) < n || !slices.Equal(os.Args[1:n], execLimitedCommand) {
+		os.Exit(m.Run())
+	}
+
+	var limits []string
+	var user string
+
+	args := os.Args[n:]
+	for len(args) > 1 && args[0] != "--" {
+		switch args[0] {
+		case "--limit":
+			limits = append(limits, args[1])
+		case "--user":
+			user = args[1]
+		}
+
+		args = args[2:]
+	}
+
+	err := ExecLimited(limits, user, args[1], args[2:])
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(LimitExitCode)
+}
+
+func TestProcessLinux(t *testing.T) {
+	t.Parallel()
+
+	log := hclog.NewNullLogger()
+
+	t.Run("applies resource limits", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		// The limits are in place before the command runs.
+		op := &ShellOperation{
+			Arguments: []string{"sh", "-c", "ulimit -n; ulimit -Hn"},
+			Process: &ProcessOptions{
+				Limits: map[string]uint64{"nofile": 64, "core": 0},
+			},
+		}
+
+		status, err := op.Run(context.Background(), log, nil, nil, nil)
+		r.NoError(err)
+		r.Equal(0, status.Code)
+		r.Equal("64\n64", strings.TrimSpace(status.Output))
+	})
+
+	t.Run("reports commands it can't run with limits", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		op := &ShellOperation{
+			Arguments: []string{"/nonexistent/command"},
+			Process: &ProcessOptions{
+				Limits: map[string]uint64{"nofile": 64},
+			},
+		}
+
+		status, err := op.Run(context.Background(), log, nil, nil, nil)
+		r.NoError(err)
+		r.Equal(LimitExitCode, status.Code)
+		r.Contains(status.Output, "unable to run /nonexistent/command")
+	})
+
+	t.Run("rejects invalid limits and users", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		r.EqualError(ExecLimited([]string{"bogus=1"}, "", "/bin/true", []string{"true"}), `unknown limit "bogus"`)
+		r.ErrorContains(ExecLimited([]string{"nofile=many"}, "", "/bin/true", []string{"true"}), "invalid nofile limit")
+		r.EqualError(ExecLimited(nil, "65534", "/bin/true", []string{"true"}), `invalid user "65534", must be uid:gid`)
+	})
+
+	t.Run("runs as another user", func(t *testing.T) {
+		t.Parallel()
+
+		if os.Getuid() != 0 {
+			t.Skip("changing user requires root")
+		}
+
+		r := require.New(t)
+
+		uid, gid := uint32(65534), uint32(65534)
+
+		op := &ShellOperation{
+			Arguments: []string{"id", "-u"},
+			Process: &ProcessOptions{
+				UID: &uid,
+				GID: &gid,
+			},
+		}
+
+		status, err := op.Run(context.Background(), log, nil, nil, nil)
+		r.NoError(err)
+		r.Equal(0, status.Code)
+		r.Equal("65534", status.Output)
+	})
+
+	t.Run("runs as another user with limits", func(t *testing.T) {
+		t.Parallel()
+
+		if os.Getuid() != 0 {
+			t.Skip("changing user requires root")
+		}
+
+		r := require.New(t)
+
+		uid := uint32(65534)
+
+		op := &ShellOperation{
+			Arguments: []string{"sh", "-c", "id -u; id -G; ulimit -Hn"},
+			Process: &ProcessOptions{
+				UID:    &uid,
+				Limits: map[string]uint64{"nofile": 64},
+			},
+		}
+
+		status, err := op.Run(context.Background(), log, nil, nil, nil)
+		r.NoError(err)
+		r.Equal(0, status.Code, status.Output)
+		r.Equal(fmt.Sprintf("65534\n%d\n64", os.Getgid()), strings.TrimSpace(status.Output))
+	})
+}
@@ -0,0 +1,23 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux
+
+package agent
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func setCredential(c *exec.Cmd, uid, gid *uint32) error {
+	return errors.New("running as another uid or gid is only supported on Linux")
+}
+
+func setLimits(c *exec.Cmd, limits map[string]uint64, uid, gid *uint32) error {
+	return errors.New("resource limits are only supported on Linux")
+}
+
+func ExecLimited(limits []string, user string, path string, args []string) error {
+	return errors.New("resource limits are only supported on Linux")
+}
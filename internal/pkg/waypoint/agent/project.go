@@ -0,0 +1,82 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Project is an HCP project the agent polls for operations. When the config
+// declares no projects, the agent polls the project of its profile for every
+// group.
+type Project struct {
+	// Name identifies the project in the agent's logs and history.
+	Name string
+
+	OrganizationID string
+	ProjectID      string
+
+	// Groups are the groups of the config the agent polls the project for.
+	Groups []string
+
+	// CredFile is the credential file used to reach the project. If empty,
+	// the agent's own credentials are used.
+	CredFile string
+}
+
+// hclProject is a project block.
+type hclProject struct {
+	Name           string   `hcl:",label"`
+	OrganizationID string   `hcl:"organization_id"`
+	ProjectID      string   `hcl:"project_id"`
+	Groups         []string `hcl:"groups"`
+	CredFile       string   `hcl:"cred_file,optional"`
+}
+
+// loadProjects checks the project blocks against the groups of the config.
+// Relative credential file paths are resolved from baseDir.
+func loadProjects(hprojects []*hclProject, groups map[string]*hclGroup, baseDir string) ([]*Project, error) {
+	var projects []*Project
+
+	for _, hp := range hprojects {
+		if slices.ContainsFunc(projects, func(p *Project) bool { return p.Name == hp.Name }) {
+			return nil, fmt.Errorf("project %q is declared more than once", hp.Name)
+		}
+
+		if hp.OrganizationID == "" || hp.ProjectID == "" {
+			return nil, fmt.Errorf("project %q: organization_id and project_id must not be empty", hp.Name)
+		}
+
+		if len(hp.Groups) == 0 {
+			return nil, fmt.Errorf("project %q: groups must not be empty", hp.Name)
+		}
+
+		for _, g := range hp.Groups {
+			if _, ok := groups[g]; !ok {
+				return nil, fmt.Errorf("project %q: group %q is not declared in the config", hp.Name, g)
+			}
+		}
+
+		credFile := hp.CredFile
+		if credFile != "" && !filepath.IsAbs(credFile) && !strings.HasPrefix(credFile, "~") {
+			credFile = filepath.Join(baseDir, credFile)
+		}
+
+		groupNames := slices.Clone(hp.Groups)
+		slices.Sort(groupNames)
+
+		projects = append(projects, &Project{
+			Name:           hp.Name,
+			OrganizationID: hp.OrganizationID,
+			ProjectID:      hp.ProjectID,
+			Groups:         slices.Compact(groupNames),
+			CredFile:       credFile,
+		})
+	}
+
+	return projects, nil
+}
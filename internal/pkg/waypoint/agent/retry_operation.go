@@ -0,0 +1,50 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+// RetryOperation runs an operation up to Attempts times, waiting Backoff
+// between attempts, until it succeeds.
+type RetryOperation struct {
+	Operation Operation
+	Attempts  int
+	Backoff   time.Duration
+}
+
+func (r *RetryOperation) Run(
+	ctx context.Context,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (OperationStatus, error) {
+	var (
+		status OperationStatus
+		err    error
+	)
+
+	for attempt := 1; ; attempt++ {
+		status, err = r.Operation.Run(ctx, log, api, profile, opInfo)
+		if !failed(status, err) || attempt >= r.Attempts || ctx.Err() != nil {
+			return status, err
+		}
+
+		log.Warn("operation failed, retrying", "attempt", attempt, "attempts", r.Attempts, "status", status.Status, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return status, err
+		case <-time.After(r.Backoff):
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+// defaultActionPollInterval is how often a RunActionOperation checks whether
+// the run it started has finished, if no interval is configured.
+const defaultActionPollInterval = 5 * time.Second
+
+// RunActionOperation starts a run of a Waypoint action and optionally waits
+// for it to finish. The ID and sequence of the run are reported in the
+// values action_run_id and action_run_sequence, and its result in
+// action_run_result once it has finished.
+type RunActionOperation struct {
+	// Action is the name of the action to run.
+	Action string
+
+	// Application is the name of the application to scope the run to. If
+	// empty, the action runs globally.
+	Application string
+
+	// Inputs override variables of the action, keyed as the action refers
+	// to them, such as "var.version".
+	Inputs map[string]string
+
+	// Wait makes the operation wait for the run to finish, succeeding only
+	// if the run does. PollInterval is how often the run is checked.
+	Wait         bool
+	PollInterval time.Duration
+}
+
+func (a *RunActionOperation) Run(
+	ctx context.Context,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (OperationStatus, error) {
+	if api == nil {
+		return errStatus, fmt.Errorf("the action operation requires an API client and none was provided")
+	}
+	if profile == nil {
+		return errStatus, fmt.Errorf("the action operation requires a profile and none was provided")
+	}
+
+	m := MaskerFrom(ctx)
+
+	body := &models.HashicorpCloudWaypointV20241122WaypointServiceRunActionBody{
+		ActionRef: &models.HashicorpCloudWaypointV20241122ActionCfgRef{
+			Name: a.Action,
+		},
+	}
+
+	if a.Application != "" {
+		body.Scope = &models.HashicorpCloudWaypointV20241122ActionRunScope{
+			Application: &models.HashicorpCloudWaypointV20241122RefApplication{
+				Name: a.Application,
+			},
+		}
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(a.Inputs)) {
+		v := a.Inputs[k]
+
+		body.VariableOverrides = append(body.VariableOverrides, &models.HashicorpCloudWaypointV20241122RunActionRequestVariableOverride{
+			Key:   k,
+			Value: v,
+
+			// Values that would be masked in the agent's own output hold
+			// secrets or sensitive inputs, so keep them hidden in HCP too.
+			Sensitive: m.Mask(v) != v,
+		})
+	}
+
+	resp, err := api.WaypointServiceRunAction(&waypoint_service.WaypointServiceRunActionParams{
+		NamespaceLocationOrganizationID: profile.OrganizationID,
+		NamespaceLocationProjectID:      profile.ProjectID,
+		Body:                            body,
+		Context:                         ctx,
+	}, nil)
+	if err != nil {
+		return errStatus, fmt.Errorf("unable to run action %s: %w", a.Action, err)
+	}
+
+	if resp.Payload == nil || resp.Payload.ActionRun == nil {
+		return errStatus, fmt.Errorf("unable to run action %s: no action run returned", a.Action)
+	}
+
+	run := resp.Payload.ActionRun
+
+	status := OperationStatus{
+		Status: fmt.Sprintf("started action %s, run %s", a.Action, run.Sequence),
+		Values: map[string]string{
+			"action_run_id":       run.ID,
+			"action_run_sequence": run.Sequence,
+		},
+	}
+
+	log.Info("started action run", "action", a.Action, "child-action-run-id", run.ID, "sequence", run.Sequence)
+
+	if !a.Wait {
+		return status, nil
+	}
+
+	run, err = a.wait(ctx, log, api, profile, run.Sequence)
+	if err != nil {
+		return errStatus, err
+	}
+
+	result := ""
+	if run.ResponseStatus != nil {
+		result = string(*run.ResponseStatus)
+	}
+
+	status.Values["action_run_result"] = result
+	status.Status = fmt.Sprintf("action %s run %s finished: %s", a.Action, run.Sequence, result)
+
+	if result != string(models.HashicorpCloudWaypointV20241122ActionRunResponseStatusSUCCESS) {
+		status.Code = 1
+	}
+
+	return status, nil
+}
+
+// wait polls the run with sequence until it has finished. Errors reading the
+// run are retried, unless HCP rejected the request.
+func (a *RunActionOperation) wait(
+	ctx context.Context,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	sequence string,
+) (*models.HashicorpCloudWaypointV20241122ActionRun, error) {
+	interval := a.PollInterval
+	if interval <= 0 {
+		interval = defaultActionPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		resp, err := api.WaypointServiceGetActionRun(&waypoint_service.WaypointServiceGetActionRunParams{
+			NamespaceLocationOrganizationID: profile.OrganizationID,
+			NamespaceLocationProjectID:      profile.ProjectID,
+			ActionName:                      &a.Action,
+			Sequence:                        &sequence,
+			Context:                         ctx,
+		}, nil)
+		if err != nil {
+			var respErr runtime.ClientResponseStatus
+			if errors.As(err, &respErr) && respErr.IsClientError() {
+				return nil, fmt.Errorf("unable to read run %s of action %s: %w", sequence, a.Action, err)
+			}
+
+			log.Warn("unable to read action run, retrying", "action", a.Action, "sequence", sequence, "error", err)
+			continue
+		}
+
+		if resp.Payload == nil || resp.Payload.ActionRun == nil {
+			continue
+		}
+
+		if run := resp.Payload.ActionRun; ActionRunFinished(run) {
+			return run, nil
+		}
+	}
+}
+
+// ActionRunFinished reports whether an action run has finished.
+func ActionRunFinished(run *models.HashicorpCloudWaypointV20241122ActionRun) bool {
+	if !time.Time(run.CompletedAt).IsZero() {
+		return true
+	}
+
+	if run.ResponseStatus == nil {
+		return false
+	}
+
+	switch *run.ResponseStatus {
+	case models.HashicorpCloudWaypointV20241122ActionRunResponseStatusSUCCESS,
+		models.HashicorpCloudWaypointV20241122ActionRunResponseStatusERROR:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// hclSecret is a value read when the agent starts, from a file or an
+// environment variable, that actions can use as secret.<name>. Secrets are
+// masked wherever the agent logs or reports them.
+type hclSecret struct {
+	Name string `hcl:",label"`
+	File string `hcl:"file,optional"`
+	Env  string `hcl:"env,optional"`
+}
+
+// checkSecrets checks the secret blocks without reading their values.
+func checkSecrets(hsecrets []*hclSecret) error {
+	seen := make(map[string]bool)
+
+	for _, hs := range hsecrets {
+		if seen[hs.Name] {
+			return fmt.Errorf("secret %q is declared more than once", hs.Name)
+		}
+
+		seen[hs.Name] = true
+
+		switch {
+		case hs.File != "" && hs.Env != "":
+			return fmt.Errorf("secret %q: only one of file or env can be set", hs.Name)
+		case hs.File == "" && hs.Env == "":
+			return fmt.Errorf("secret %q: one of file or env must be set", hs.Name)
+		}
+	}
+
+	return nil
+}
+
+// loadSecrets reads the value of each secret. Relative file paths are resolved
+// from baseDir. A trailing newline in a file is not part of the secret.
+func loadSecrets(hsecrets []*hclSecret, baseDir string) (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	for _, hs := range hsecrets {
+		if hs.File != "" {
+			path := hs.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("secret %q: %w", hs.Name, err)
+			}
+
+			secrets[hs.Name] = strings.TrimRight(string(data), "\r\n")
+			continue
+		}
+
+		val, ok := os.LookupEnv(hs.Env)
+		if !ok {
+			return nil, fmt.Errorf("secret %q: environment variable %s is not set", hs.Name, hs.Env)
+		}
+
+		secrets[hs.Name] = val
+	}
+
+	return secrets, nil
+}
+
+// secretsObject returns the secrets as the object actions refer to as secret.
+// Secrets that haven't been loaded are RedactedValue.
+func secretsObject(hsecrets []*hclSecret, secrets map[string]string) cty.Value {
+	vals := make(map[string]cty.Value, len(hsecrets))
+	for _, hs := range hsecrets {
+		v, ok := secrets[hs.Name]
+		if !ok {
+			v = RedactedValue
+		}
+
+		vals[hs.Name] = cty.StringVal(v)
+	}
+
+	return cty.ObjectVal(vals)
+}
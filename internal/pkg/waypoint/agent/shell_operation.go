@@ -6,9 +6,18 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
@@ -20,12 +29,44 @@ type ShellOperation struct {
 	Arguments     []string
 	Environment   map[string]string
 	DockerOptions *DockerOptions
+
+	// SensitiveEnv names the variables in Environment whose values are
+	// masked in logs, statuses and streamed output.
+	SensitiveEnv []string
+
+	// Process controls the environment, working directory, credentials and
+	// resource limits of the command. Nil inherits the agent's.
+	Process *ProcessOptions
+
+	// StreamOutput controls which output is sent to the action run as status
+	// logs while the command runs. StreamMaxBytes caps the total sent.
+	StreamOutput   OutputStream
+	StreamMaxBytes int
+
+	// dockerCLI overrides the docker command, for tests.
+	dockerCLI string
 }
 
 type DockerOptions struct {
-	Image string `hcl:"image"`
+	Image   string   `hcl:"image"`
+	Volumes []string `hcl:"volumes,optional"`
+	WorkDir string   `hcl:"workdir,optional"`
+	Network string   `hcl:"network,optional"`
+	User    string   `hcl:"user,optional"`
+
+	// Pull is the image pull policy, one of "always", "missing" or "never".
+	Pull string `hcl:"pull,optional"`
+
+	CPUs   string `hcl:"cpus,optional"`
+	Memory string `hcl:"memory,optional"`
 }
 
+// shellWaitDelay is how long to wait for a cancelled command's output to close.
+const shellWaitDelay = 5 * time.Second
+
+// defaultDockerCLI is the command used to run containers.
+const defaultDockerCLI = "docker"
+
 func (s *ShellOperation) Run(
 	ctx context.Context,
 	log hclog.Logger,
@@ -33,29 +74,159 @@ func (s *ShellOperation) Run(
 	profile *profile.Profile,
 	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
 ) (OperationStatus, error) {
+	if len(s.Arguments) == 0 {
+		return errStatus, errors.New("no command to run")
+	}
+
+	m := MaskerFrom(ctx)
+	for _, k := range s.SensitiveEnv {
+		m.Add(s.Environment[k])
+	}
+
+	var streamer *statusLogStreamer
+
+	if s.StreamOutput != "" && s.StreamOutput != OutputStreamOff {
+		if api != nil && profile != nil && opInfo != nil && opInfo.ActionRunID != "" {
+			// Output written just before the operation is cancelled is still
+			// worth sending, so don't tie the status logs to ctx.
+			streamer = newStatusLogStreamer(context.WithoutCancel(ctx), log, api, profile, opInfo.ActionRunID, s.StreamMaxBytes, m)
+		} else {
+			log.Debug("not streaming shell operation output, no action run to stream to")
+		}
+	}
+
+	of, err := newOutputFile(s.Process, s.DockerOptions != nil)
+	if err != nil {
+		return errStatus, err
+	}
+
+	defer func() {
+		if err := of.Close(); err != nil {
+			log.Warn("unable to remove output file", "path", of.path, "error", err)
+		}
+	}()
+
+	var status OperationStatus
+
 	if s.DockerOptions != nil {
-		return s.runUnderDocker(ctx, log)
+		status, err = s.runUnderDocker(ctx, log, of.path, streamer)
+	} else {
+		status, err = s.exec(ctx, log, s.Arguments, map[string]string{OutputFileEnv: of.path}, streamer)
+	}
+	if err != nil {
+		return status, err
+	}
+
+	// The command's own status is kept if its outputs can't be read, with
+	// the reason added to it.
+	outputs, err := of.read()
+	if err != nil {
+		log.Warn("unable to read outputs", "error", err)
+		status.Status += fmt.Sprintf(" (unable to read outputs written to %s: %s)", OutputFileEnv, err)
+
+		return status, nil
+	}
+
+	if len(outputs) == 0 {
+		return status, nil
 	}
 
-	return s.exec(ctx, log, s.Arguments)
+	status.Values = outputs
+
+	if api != nil && profile != nil && opInfo != nil && opInfo.ActionRunID != "" {
+		so := &StatusOperation{
+			Message: "outputs: " + strings.Join(slices.Sorted(maps.Keys(outputs)), ", "),
+			Values:  outputs,
+		}
+
+		// The outputs are still in the status, so the operation doesn't fail
+		// if they can't be sent.
+		if _, err := so.Run(context.WithoutCancel(ctx), log, api, profile, opInfo); err != nil {
+			log.Warn("unable to send outputs as a status log", "error", err)
+		}
+	}
+
+	return status, nil
 }
 
-func (s *ShellOperation) exec(ctx context.Context, log hclog.Logger, cmd []string) (OperationStatus, error) {
-	c := exec.CommandContext(ctx, s.Arguments[0], s.Arguments[1:]...)
+// exec runs cmd with the operation's environment and env added to it.
+func (s *ShellOperation) exec(ctx context.Context, log hclog.Logger, cmd []string, env map[string]string, streamer *statusLogStreamer) (OperationStatus, error) {
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
 
-	c.Env = slices.Clone(os.Environ())
+	// Don't wait forever on output from processes the command left behind
+	// once it has been cancelled.
+	c.WaitDelay = shellWaitDelay
+
+	// The policy applies to what the container is given, not the docker
+	// command, which needs the agent's environment to reach the daemon.
+	if s.DockerOptions != nil {
+		c.Env = os.Environ()
+	} else {
+		c.Env = s.Process.environ(os.Environ())
+	}
 
 	for k, v := range s.Environment {
 		c.Env = append(c.Env, k+"="+v)
 	}
 
+	for k, v := range env {
+		c.Env = append(c.Env, k+"="+v)
+	}
+
+	if err := s.Process.apply(c); err != nil {
+		return errStatus, err
+	}
+
 	var out bytes.Buffer
 
 	c.Stdout = &out
 	c.Stderr = &out
 
-	err := c.Run()
-	log.Debug("output from shell operation", "command", s.Arguments[0], "output", out.String(), "error", err)
+	var writers []*lineWriter
+
+	if streamer != nil {
+		// Stdout and stderr are copied on separate goroutines once they are
+		// no longer the same writer, so guard the shared buffer.
+		shared := &syncWriter{w: &out}
+
+		stdout := streamer.Writer("stdout")
+		writers = append(writers, stdout)
+
+		c.Stdout = io.MultiWriter(shared, stdout)
+		c.Stderr = shared
+
+		if s.StreamOutput == OutputStreamBoth {
+			stderr := streamer.Writer("stderr")
+			writers = append(writers, stderr)
+
+			c.Stderr = io.MultiWriter(shared, stderr)
+		}
+	}
+
+	// Record whether the command was killed because ctx was cancelled, so
+	// that isn't mistaken for the command failing on its own.
+	var cancelled atomic.Bool
+
+	c.Cancel = func() error {
+		cancelled.Store(true)
+		return c.Process.Kill()
+	}
+
+	if err := c.Start(); err != nil {
+		return errStatus, fmt.Errorf("unable to start %s: %w", cmd[0], err)
+	}
+
+	err := c.Wait()
+
+	if streamer != nil {
+		for _, w := range writers {
+			w.Flush()
+		}
+
+		streamer.Close()
+	}
+
+	log.Debug("output from shell operation", "command", cmd[0], "output", out.String(), "error", err)
 
 	status := OperationStatus{
 		Code: c.ProcessState.ExitCode(),
@@ -63,6 +234,8 @@ func (s *ShellOperation) exec(ctx context.Context, log hclog.Logger, cmd []strin
 
 	data := bytes.TrimSpace(out.Bytes())
 
+	status.Output = outputTail(data, OutputTailBytes)
+
 	// Only return last line of output for security reasons.
 	// Users can use the API to send more output as a status log, if desired.
 	if idx := bytes.LastIndexByte(data, '\n'); idx != -1 {
@@ -71,13 +244,124 @@ func (s *ShellOperation) exec(ctx context.Context, log hclog.Logger, cmd []strin
 		status.Status = "output: " + string(data)
 	}
 
+	if cancelled.Load() && !c.ProcessState.Success() {
+		return status, fmt.Errorf("%s cancelled: %w", cmd[0], ctx.Err())
+	}
+
 	return status, nil
 }
 
-func (s *ShellOperation) runUnderDocker(ctx context.Context, log hclog.Logger) (OperationStatus, error) {
-	args := append([]string{
-		"run", "--rm", s.DockerOptions.Image,
-	}, s.Arguments...)
+// OutputTailBytes is how much of the end of a command's output is kept in
+// OperationStatus.Output.
+const OutputTailBytes = 4096
+
+// outputTail returns the last n bytes of data, starting at a line boundary
+// where there is one.
+func outputTail(data []byte, n int) string {
+	if len(data) <= n {
+		return string(data)
+	}
+
+	tail := data[len(data)-n:]
+	if idx := bytes.IndexByte(tail, '\n'); idx != -1 && idx < len(tail)-1 {
+		tail = tail[idx+1:]
+	}
+
+	return string(tail)
+}
+
+// runUnderDocker runs the command in a container, with the output file at
+// outputPath mounted into it.
+func (s *ShellOperation) runUnderDocker(ctx context.Context, log hclog.Logger, outputPath string, streamer *statusLogStreamer) (OperationStatus, error) {
+	name, err := containerName()
+	if err != nil {
+		return errStatus, err
+	}
+
+	docker := s.dockerCLI
+	if docker == "" {
+		docker = defaultDockerCLI
+	}
+
+	args := []string{docker, "run", "--rm", "--name", name}
+
+	// Only pass the names of variables, the values are read from the
+	// environment of the docker command so they don't show up in a process
+	// listing.
+	for _, k := range slices.Sorted(maps.Keys(s.Environment)) {
+		args = append(args, "--env", k)
+	}
+
+	for _, k := range s.Process.containerEnv(os.Environ()) {
+		if _, ok := s.Environment[k]; !ok {
+			args = append(args, "--env", k)
+		}
+	}
+
+	args = append(args, "--env", OutputFileEnv, "--volume", outputPath+":"+dockerOutputPath)
+
+	do := s.DockerOptions
+
+	for _, v := range do.Volumes {
+		args = append(args, "--volume", v)
+	}
+
+	if do.WorkDir != "" {
+		args = append(args, "--workdir", do.WorkDir)
+	}
+
+	if do.Network != "" {
+		args = append(args, "--network", do.Network)
+	}
+
+	if do.User != "" {
+		args = append(args, "--user", do.User)
+	}
+
+	if do.Pull != "" {
+		args = append(args, "--pull", do.Pull)
+	}
+
+	if do.CPUs != "" {
+		args = append(args, "--cpus", do.CPUs)
+	}
+
+	if do.Memory != "" {
+		args = append(args, "--memory", do.Memory)
+	}
+
+	args = append(args, do.Image)
+	args = append(args, s.Arguments...)
+
+	status, err := s.exec(ctx, log, args, map[string]string{OutputFileEnv: dockerOutputPath}, streamer)
+
+	// Killing the docker command does not stop the container, so remove it
+	// if the operation was cancelled.
+	if ctx.Err() != nil {
+		rmCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dockerRemoveTimeout)
+		defer cancel()
+
+		out, rmErr := exec.CommandContext(rmCtx, docker, "rm", "--force", name).CombinedOutput()
+		if rmErr != nil {
+			log.Error("unable to remove cancelled container", "container", name, "error", rmErr, "output", string(out))
+		}
+	}
+
+	return status, err
+}
+
+// dockerRemoveTimeout bounds cleaning up a container after its operation was
+// cancelled.
+const dockerRemoveTimeout = 30 * time.Second
+
+func containerName() (string, error) {
+	var buf [8]byte
 
-	return s.exec(ctx, log, args)
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+
+	return "hcp-waypoint-agent-" + hex.EncodeToString(buf[:]), nil
 }
+
+var dockerPullPolicies = []string{"always", "missing", "never"}
@@ -0,0 +1,334 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDocker writes a script that records its arguments and stands in for the
+// docker command. It returns the path to the script and to the recorded
+// arguments. The environment of the last command is recorded in env next to
+// the arguments.
+func fakeDocker(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "docker")
+	args := filepath.Join(dir, "args")
+
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" >> "`+args+`"
+export -p > "`+filepath.Join(dir, "env")+`"
+if [ "$1" = "run" ]; then
+  echo "token=$DEPLOY_TOKEN"
+  case "$*" in
+    *sleep*) exec sleep 30 ;;
+  esac
+fi
+`), 0o755)
+	require.NoError(t, err)
+
+	return script, args
+}
+
+func TestShellOperationDocker(t *testing.T) {
+	t.Parallel()
+
+	log := hclog.New(&hclog.LoggerOptions{
+		Name:  "agent-shell",
+		Level: hclog.Trace,
+	})
+
+	t.Run("runs the command in a container", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		docker, argsPath := fakeDocker(t)
+
+		op := &ShellOperation{
+			Arguments:   []string{"./deploy.sh", "--env", "prod"},
+			Environment: map[string]string{"DEPLOY_TOKEN": "s3cr3t"},
+			DockerOptions: &DockerOptions{
+				Image:   "ubuntu",
+				Volumes: []string{"/srv:/srv"},
+				WorkDir: "/srv",
+				Network: "host",
+				User:    "1000",
+				Pull:    "never",
+				CPUs:    "1",
+				Memory:  "64m",
+			},
+			dockerCLI: docker,
+		}
+
+		status, err := op.Run(context.Background(), log, nil, nil, nil)
+		r.NoError(err)
+
+		r.Equal(0, status.Code)
+		r.Equal("output: token=s3cr3t", status.Status)
+
+		data, err := os.ReadFile(argsPath)
+		r.NoError(err)
+
+		args := strings.Fields(string(data))
+		r.Equal("run", args[0])
+		r.Contains(args, "--rm")
+		r.Equal("DEPLOY_TOKEN", args[slices.Index(args, "--env")+1])
+		r.NotContains(string(data), "s3cr3t")
+
+		// The output file is mounted ahead of the configured options.
+		out := slices.Index(args, OutputFileEnv)
+		r.Equal("--env", args[out-1])
+		r.Equal("--volume", args[out+1])
+		r.True(strings.HasSuffix(args[out+2], ":"+dockerOutputPath), args[out+2])
+
+		r.Equal(
+			[]string{"--volume", "/srv:/srv", "--workdir", "/srv", "--network", "host", "--user", "1000",
+				"--pull", "never", "--cpus", "1", "--memory", "64m", "ubuntu", "./deploy.sh", "--env", "prod"},
+			args[slices.Index(args, "/srv:/srv")-1:],
+		)
+	})
+
+	t.Run("applies the env policy to the container", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		docker, argsPath := fakeDocker(t)
+
+		op := &ShellOperation{
+			Arguments:     []string{"true"},
+			DockerOptions: &DockerOptions{Image: "ubuntu"},
+			Process:       &ProcessOptions{EnvPolicy: EnvPolicyEmpty},
+			dockerCLI:     docker,
+		}
+
+		_, err := op.Run(context.Background(), log, nil, nil, nil)
+		r.NoError(err)
+
+		// The docker command still has the agent's environment.
+		env, err := os.ReadFile(filepath.Join(filepath.Dir(argsPath), "env"))
+		r.NoError(err)
+		r.Contains(string(env), "PATH=")
+
+		data, err := os.ReadFile(argsPath)
+		r.NoError(err)
+		r.NotContains(string(data), "--env PATH")
+
+		op.Process = &ProcessOptions{EnvPolicy: EnvPolicyAllowlist, EnvAllow: []string{"PATH"}}
+
+		_, err = op.Run(context.Background(), log, nil, nil, nil)
+		r.NoError(err)
+
+		data, err = os.ReadFile(argsPath)
+		r.NoError(err)
+
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		r.Contains(lines[1], "--env PATH ")
+	})
+
+	t.Run("removes the container when cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		docker, argsPath := fakeDocker(t)
+
+		op := &ShellOperation{
+			Arguments:     []string{"sleep"},
+			DockerOptions: &DockerOptions{Image: "ubuntu"},
+			dockerCLI:     docker,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err := op.Run(ctx, log, nil, nil, nil)
+		r.ErrorIs(err, context.DeadlineExceeded)
+
+		data, err := os.ReadFile(argsPath)
+		r.NoError(err)
+
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		r.Len(lines, 2)
+
+		run := strings.Fields(lines[0])
+		name := run[slices.Index(run, "--name")+1]
+
+		r.Equal("rm --force "+name, lines[1])
+	})
+}
+
+func TestShellOperationProcess(t *testing.T) {
+	t.Parallel()
+
+	// The output holds the agent's environment, so don't log it.
+	log := hclog.NewNullLogger()
+
+	env := func(t *testing.T, process *ProcessOptions) []string {
+		t.Helper()
+
+		op := &ShellOperation{
+			Arguments:   []string{"/usr/bin/env"},
+			Environment: map[string]string{"DECLARED": "yes"},
+			Process:     process,
+		}
+
+		status, err := op.Run(context.Background(), log, nil, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, 0, status.Code)
+
+		var names []string
+		for _, line := range strings.Split(status.Output, "\n") {
+			name, _, _ := strings.Cut(line, "=")
+			names = append(names, name)
+		}
+
+		return names
+	}
+
+	t.Run("inherits the agent's environment by default", func(t *testing.T) {
+		t.Parallel()
+
+		names := env(t, nil)
+		require.Contains(t, names, "PATH")
+		require.Contains(t, names, "DECLARED")
+	})
+
+	t.Run("passes only allowed variables", func(t *testing.T) {
+		t.Parallel()
+
+		names := env(t, &ProcessOptions{
+			EnvPolicy: EnvPolicyAllowlist,
+			EnvAllow:  []string{"PA*"},
+		})
+
+		for _, name := range names {
+			if name != "DECLARED" && name != OutputFileEnv {
+				require.True(t, strings.HasPrefix(name, "PA"), name)
+			}
+		}
+
+		require.Contains(t, names, "PATH")
+		require.Contains(t, names, "DECLARED")
+	})
+
+	t.Run("passes only declared variables when empty", func(t *testing.T) {
+		t.Parallel()
+
+		names := env(t, &ProcessOptions{EnvPolicy: EnvPolicyEmpty})
+		require.Equal(t, []string{"DECLARED", OutputFileEnv}, names)
+	})
+
+	t.Run("runs in the working directory", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		dir, err := filepath.EvalSymlinks(t.TempDir())
+		r.NoError(err)
+
+		op := &ShellOperation{
+			Arguments: []string{"pwd"},
+			Process:   &ProcessOptions{WorkDir: dir},
+		}
+
+		status, err := op.Run(context.Background(), log, nil, nil, nil)
+		r.NoError(err)
+		r.Equal(dir, status.Output)
+	})
+}
+
+func TestShellOperationRun(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fails without a command", func(t *testing.T) {
+		t.Parallel()
+
+		op := &ShellOperation{}
+
+		_, err := op.Run(context.Background(), hclog.NewNullLogger(), nil, nil, nil)
+		require.ErrorContains(t, err, "no command to run")
+	})
+}
+
+func TestShellOperationOutputs(t *testing.T) {
+	t.Parallel()
+
+	t.Run("keeps the command's status if outputs can't be read", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		op := &ShellOperation{
+			Arguments: []string{"sh", "-c", "echo '{bad' > $WAYPOINT_OUTPUT; echo failed; exit 3"},
+		}
+
+		status, err := op.Run(context.Background(), hclog.NewNullLogger(), nil, nil, nil)
+		r.NoError(err)
+		r.Equal(3, status.Code)
+		r.Contains(status.Status, "output: failed")
+		r.Contains(status.Status, "unable to read outputs written to "+OutputFileEnv)
+		r.Empty(status.Values)
+	})
+}
+
+func TestParseOutputs(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  map[string]string
+		err   string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "key value lines",
+			input: "# comment\nimage=web:1\n\nurl = https://example.com/?a=b\nimage=web:2\r\n",
+			want:  map[string]string{"image": "web:2", "url": " https://example.com/?a=b"},
+		},
+		{
+			name:  "json object",
+			input: `{"image": "web:1", "replicas": 3, "tags": ["a"], "ready": true}`,
+			want:  map[string]string{"image": "web:1", "replicas": "3", "tags": `["a"]`, "ready": "true"},
+		},
+		{
+			name:  "line without a key",
+			input: "image=web\noops\n",
+			err:   "line 2: expected key=value",
+		},
+		{
+			name:  "invalid json",
+			input: `{"image": `,
+			err:   "invalid JSON outputs",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseOutputs([]byte(tc.input))
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+// OutputStream controls which output of a shell operation is streamed to the
+// action run as status logs.
+type OutputStream string
+
+const (
+	OutputStreamOff    OutputStream = "off"
+	OutputStreamStdout OutputStream = "stdout"
+	OutputStreamBoth   OutputStream = "both"
+)
+
+const (
+	// statusLogBatchInterval and statusLogBatchBytes control how often
+	// streamed output is sent. Lines are sent together once either is reached.
+	statusLogBatchInterval = 2 * time.Second
+	statusLogBatchBytes    = 4096
+
+	// statusLogMaxLineBytes is the longest line streamed. Longer output,
+	// such as a progress bar that never writes a newline, is split.
+	statusLogMaxLineBytes = 4096
+
+	// statusLogMaxPendingBytes bounds the output waiting to be sent. Lines
+	// written while it is full are dropped, so a slow HCP never holds up the
+	// command or fills the agent's memory.
+	statusLogMaxPendingBytes = 64 * 1024
+
+	// DefaultStreamMaxBytes is the total amount of output a single operation
+	// streams if the config does not specify a limit.
+	DefaultStreamMaxBytes = 1 << 20
+)
+
+// statusLogStreamer batches lines of output and sends them to an action run as
+// status logs. Once maxBytes of output have been sent, further output is
+// dropped. Lines are sent by a goroutine of their own, so writing them never
+// waits on HCP.
+type statusLogStreamer struct {
+	ctx     context.Context
+	log     hclog.Logger
+	api     waypoint_service.ClientService
+	profile *profile.Profile
+	runID   string
+	masker  *Masker
+
+	mu        sync.Mutex
+	pending   []streamLine
+	size      int
+	remaining int
+	truncated bool
+
+	// dropped counts the lines dropped because too much output was pending,
+	// and droppedStream is the stream of the last of them.
+	dropped       int
+	droppedStream string
+
+	// full is signaled when a batch is ready to send before the interval.
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// streamLine is a line of output and the stream it was written to.
+type streamLine struct {
+	stream string
+	text   string
+}
+
+func newStatusLogStreamer(
+	ctx context.Context,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	runID string,
+	maxBytes int,
+	masker *Masker,
+) *statusLogStreamer {
+	if maxBytes <= 0 {
+		maxBytes = DefaultStreamMaxBytes
+	}
+
+	s := &statusLogStreamer{
+		ctx:       ctx,
+		log:       log,
+		api:       api,
+		profile:   profile,
+		runID:     runID,
+		masker:    masker,
+		remaining: maxBytes,
+		full:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go s.sendPeriodically()
+
+	return s
+}
+
+// Writer returns a writer that streams each line written to it, labeled with
+// the name of the stream.
+func (s *statusLogStreamer) Writer(stream string) *lineWriter {
+	return &lineWriter{stream: stream, s: s}
+}
+
+// Close sends any remaining output and stops sending.
+func (s *statusLogStreamer) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *statusLogStreamer) sendPeriodically() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(statusLogBatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			s.flush()
+			return
+		case <-ticker.C:
+			s.flush()
+		case <-s.full:
+			s.flush()
+		}
+	}
+}
+
+func (s *statusLogStreamer) add(stream, line string) {
+	line = s.masker.Mask(line)
+
+	s.mu.Lock()
+
+	if s.truncated {
+		s.mu.Unlock()
+		return
+	}
+
+	switch {
+	case len(line) > s.remaining:
+		s.truncated = true
+		s.pending = append(s.pending, streamLine{stream: stream, text: "output truncated, limit reached"})
+	case s.size+len(line) > statusLogMaxPendingBytes:
+		s.dropped++
+		s.droppedStream = stream
+	default:
+		s.remaining -= len(line)
+		s.pending = append(s.pending, streamLine{stream: stream, text: line})
+		s.size += len(line)
+	}
+
+	full := s.size >= statusLogBatchBytes || s.truncated
+
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flush sends the pending lines.
+func (s *statusLogStreamer) flush() {
+	s.mu.Lock()
+
+	lines := s.pending
+	s.pending = nil
+	s.size = 0
+
+	if s.dropped > 0 {
+		lines = append(lines, streamLine{
+			stream: s.droppedStream,
+			text:   fmt.Sprintf("%d lines dropped, output was written faster than it could be sent", s.dropped),
+		})
+		s.dropped = 0
+	}
+
+	s.mu.Unlock()
+
+	// Batches only contain lines from one stream so they can be labeled.
+	var (
+		batch []string
+		size  int
+	)
+
+	for i, l := range lines {
+		batch = append(batch, l.text)
+		size += len(l.text)
+
+		if i == len(lines)-1 || lines[i+1].stream != l.stream || size >= statusLogBatchBytes {
+			s.send(l.stream, batch)
+			batch, size = nil, 0
+		}
+	}
+}
+
+func (s *statusLogStreamer) send(stream string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+
+	_, err := s.api.WaypointServiceSendStatusLog2(&waypoint_service.WaypointServiceSendStatusLog2Params{
+		NamespaceLocationOrganizationID: s.profile.OrganizationID,
+		NamespaceLocationProjectID:      s.profile.ProjectID,
+
+		ActionRunID: s.runID,
+
+		Body: &models.HashicorpCloudWaypointV20241122WaypointServiceSendStatusLogBody{
+			StatusLog: &models.HashicorpCloudWaypointV20241122StatusLog{
+				EmittedAt: strfmt.DateTime(time.Now()),
+				Log:       strings.Join(lines, "\n"),
+				Metadata:  map[string]string{"stream": stream},
+			},
+		},
+		Context: s.ctx,
+	}, nil)
+	if err != nil {
+		s.log.Error("error streaming output as status log", "error", err)
+	}
+}
+
+// lineWriter splits the output written to it into lines for a
+// statusLogStreamer. Lines longer than statusLogMaxLineBytes are split.
+type lineWriter struct {
+	stream  string
+	s       *statusLogStreamer
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+
+	for {
+		idx := bytes.IndexByte(w.partial, '\n')
+
+		if idx == -1 || idx > statusLogMaxLineBytes {
+			if len(w.partial) <= statusLogMaxLineBytes {
+				break
+			}
+
+			// Split at the start of a character, so it isn't broken in two.
+			cut := statusLogMaxLineBytes
+			for cut > 0 && !utf8.RuneStart(w.partial[cut]) {
+				cut--
+			}
+
+			if cut == 0 {
+				cut = statusLogMaxLineBytes
+			}
+
+			w.s.add(w.stream, string(w.partial[:cut]))
+			w.partial = w.partial[cut:]
+
+			continue
+		}
+
+		w.s.add(w.stream, string(bytes.TrimRight(w.partial[:idx], "\r")))
+		w.partial = w.partial[idx+1:]
+	}
+
+	// Don't keep the whole output alive through the backing array.
+	w.partial = bytes.Clone(w.partial)
+
+	return len(p), nil
+}
+
+// Flush streams any output that did not end in a newline.
+func (w *lineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.s.add(w.stream, string(w.partial))
+		w.partial = nil
+	}
+}
+
+// syncWriter serializes writes from the stdout and stderr copiers of a command
+// into a shared writer.
+type syncWriter struct {
+	mu sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(p)
+}
+
+func parseOutputStream(str string) (OutputStream, error) {
+	switch stream := OutputStream(str); stream {
+	case OutputStreamOff, OutputStreamStdout, OutputStreamBoth:
+		return stream, nil
+	default:
+		return "", fmt.Errorf("stream_output must be one of %q, %q or %q", OutputStreamOff, OutputStreamStdout, OutputStreamBoth)
+	}
+}
@@ -38,6 +38,8 @@ func (s *StatusOperation) Run(
 		return OperationStatus{}, fmt.Errorf("the status operation requires a run ID and none was provided")
 	}
 
+	m := MaskerFrom(ctx)
+
 	ret, err := api.WaypointServiceSendStatusLog2(&waypoint_service.WaypointServiceSendStatusLog2Params{
 		NamespaceLocationOrganizationID: profile.OrganizationID,
 		NamespaceLocationProjectID:      profile.ProjectID,
@@ -47,8 +49,8 @@ func (s *StatusOperation) Run(
 		Body: &models.HashicorpCloudWaypointV20241122WaypointServiceSendStatusLogBody{
 			StatusLog: &models.HashicorpCloudWaypointV20241122StatusLog{
 				EmittedAt: strfmt.DateTime(time.Now()),
-				Log:       s.Message,
-				Metadata:  s.Values,
+				Log:       m.Mask(s.Message),
+				Metadata:  m.MaskValues(s.Values),
 			},
 		},
 	}, nil)
@@ -0,0 +1,51 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/client/waypoint_service"
+	"github.com/hashicorp/hcp-sdk-go/clients/cloud-waypoint-service/preview/2024-11-22/models"
+	"github.com/hashicorp/hcp/internal/pkg/profile"
+)
+
+// statusCodeTimeout is reported for operations that ran out of time. It
+// matches the exit code of the timeout(1) command.
+const statusCodeTimeout = 124
+
+// TimeoutOperation cancels an operation that runs longer than Timeout.
+type TimeoutOperation struct {
+	Operation Operation
+	Timeout   time.Duration
+}
+
+func (t *TimeoutOperation) Run(
+	ctx context.Context,
+	log hclog.Logger,
+	api waypoint_service.ClientService,
+	profile *profile.Profile,
+	opInfo *models.HashicorpCloudWaypointV20241122AgentOperation,
+) (OperationStatus, error) {
+	opCtx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	status, err := t.Operation.Run(opCtx, log, api, profile, opInfo)
+
+	// Only report a timeout when our deadline fired, not when the parent
+	// context was cancelled.
+	if ctx.Err() == nil && errors.Is(opCtx.Err(), context.DeadlineExceeded) {
+		log.Warn("operation timed out", "timeout", t.Timeout)
+
+		return OperationStatus{
+			Status: "timed out after " + t.Timeout.String(),
+			Code:   statusCodeTimeout,
+		}, nil
+	}
+
+	return status, err
+}
@@ -0,0 +1,286 @@
+// Copyright IBM Corp. 2024, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// placeholder is the value given to inputs that an action uses but does not
+// declare a type or default for when it is validated.
+const placeholder = "placeholder"
+
+// Diagnostic is a problem with an action found by Validate.
+type Diagnostic struct {
+	Group      string
+	Action     string
+	Diagnostic *hcl.Diagnostic
+}
+
+// Validate converts every action in the config to find problems that would
+// otherwise only show up when the action is run. Actions are converted with
+// placeholder inputs: declared inputs use their default, or a value of their
+// type, and any other variable the action refers to is a string.
+func (c *Config) Validate() []Diagnostic {
+	var ret []Diagnostic
+
+	for _, name := range c.groupNames {
+		grp := c.groups[name]
+		seen := make(map[string]bool)
+
+		for _, act := range grp.Actions {
+			rng := act.Body.MissingItemRange()
+
+			if seen[act.Name] {
+				ret = append(ret, Diagnostic{
+					Group:  name,
+					Action: act.Name,
+					Diagnostic: &hcl.Diagnostic{
+						Severity: hcl.DiagError,
+						Summary:  "Duplicate action",
+						Detail:   fmt.Sprintf("Action %q is declared more than once in group %q. Only the first is used.", act.Name, name),
+						Subject:  &rng,
+					},
+				})
+
+				continue
+			}
+
+			seen[act.Name] = true
+
+			hctx := &hcl.EvalContext{
+				Variables: map[string]cty.Value{
+					"var": placeholderVars(act),
+					"waypoint": cty.ObjectVal(map[string]cty.Value{
+						"run_id": cty.StringVal(placeholder),
+					}),
+				},
+			}
+
+			op, err := c.Action(name, act.Name, hctx)
+			if err == nil {
+				err = checkOperations(op)
+			}
+
+			if err == nil {
+				continue
+			}
+
+			for _, diag := range toDiagnostics(err, rng) {
+				ret = append(ret, Diagnostic{
+					Group:      name,
+					Action:     act.Name,
+					Diagnostic: diag,
+				})
+			}
+		}
+	}
+
+	return ret
+}
+
+// checkOperations returns an error for operations within op that would fail
+// as soon as they run.
+func checkOperations(op Operation) error {
+	switch o := op.(type) {
+	case *NoopWrapper:
+		return checkOperations(o.Operation)
+	case *DeferredOperation:
+		return checkOperations(o.Preview)
+	case *ShellOperation:
+		if len(o.Arguments) == 0 || o.Arguments[0] == "" {
+			return errors.New("run operation has no command to run")
+		}
+	}
+
+	for _, c := range children(op) {
+		if err := checkOperations(c.op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GroupRange returns the position of a group in the config file.
+func (c *Config) GroupRange(name string) hcl.Range {
+	grp, ok := c.groups[name]
+	if !ok || grp.Body == nil {
+		return hcl.Range{}
+	}
+
+	return grp.Body.MissingItemRange()
+}
+
+// toDiagnostics returns the HCL diagnostics in err, or a single diagnostic
+// positioned at subject if it has none.
+func toDiagnostics(err error, subject hcl.Range) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if errors.As(err, &diags) {
+		return diags
+	}
+
+	return hcl.Diagnostics{
+		{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid action",
+			Detail:   err.Error(),
+			Subject:  &subject,
+		},
+	}
+}
+
+// placeholderVars returns a var object for validating an action, with a value
+// for every input it declares and every variable its expressions refer to.
+func placeholderVars(act *hclAction) cty.Value {
+	used := make(map[string]any)
+
+	for _, tr := range variablesOf(act.Body, "var") {
+		addPlaceholder(used, tr[1:])
+	}
+
+	_, vals := anyToCty(used)
+
+	for _, in := range act.inputs {
+		if !in.Default.IsNull() {
+			vals[in.Name] = in.Default
+		} else {
+			vals[in.Name] = placeholderValue(in.Type)
+		}
+	}
+
+	return cty.ObjectVal(vals)
+}
+
+// variablesOf returns the traversals of the variable root that the
+// expressions in body refer to, including those in nested blocks. Only native
+// syntax bodies can be searched.
+func variablesOf(body hcl.Body, root string) []hcl.Traversal {
+	var ret []hcl.Traversal
+
+	if body, ok := body.(*hclsyntax.Body); ok {
+		_ = hclsyntax.VisitAll(body, func(n hclsyntax.Node) hcl.Diagnostics {
+			if attr, ok := n.(*hclsyntax.Attribute); ok {
+				for _, tr := range attr.Expr.Variables() {
+					if tr.RootName() == root {
+						ret = append(ret, tr)
+					}
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return ret
+}
+
+// addPlaceholder adds a placeholder string to vars at the path traversed by
+// rel, creating objects and lists along the way.
+func addPlaceholder(vars map[string]any, rel hcl.Traversal) {
+	var (
+		parent = vars
+		key    string
+	)
+
+	for i, step := range rel {
+		switch s := step.(type) {
+		case hcl.TraverseAttr:
+			if i > 0 {
+				parent = child(parent, key)
+			}
+			key = s.Name
+		case hcl.TraverseIndex:
+			if i == 0 {
+				return
+			}
+
+			if s.Key.Type() == cty.String {
+				parent = child(parent, key)
+				key = s.Key.AsString()
+				continue
+			}
+
+			// Numeric indexes are given a list long enough to contain them,
+			// and the traversal stops there.
+			if s.Key.Type() == cty.Number {
+				if idx, acc := s.Key.AsBigFloat().Int64(); acc == 0 && idx >= 0 && idx < 100 {
+					list := make([]any, idx+1)
+					for j := range list {
+						list[j] = placeholder
+					}
+					parent[key] = list
+				}
+			}
+
+			return
+		default:
+			return
+		}
+	}
+
+	if key == "" {
+		return
+	}
+
+	if _, ok := parent[key]; !ok {
+		parent[key] = placeholder
+	}
+}
+
+// child returns the object at key in m, replacing any placeholder already
+// there.
+func child(m map[string]any, key string) map[string]any {
+	if c, ok := m[key].(map[string]any); ok {
+		return c
+	}
+
+	c := make(map[string]any)
+	m[key] = c
+
+	return c
+}
+
+// placeholderValue returns a known value of type ty.
+func placeholderValue(ty cty.Type) cty.Value {
+	switch {
+	case ty == cty.String || ty == cty.DynamicPseudoType:
+		return cty.StringVal(placeholder)
+	case ty == cty.Number:
+		return cty.Zero
+	case ty == cty.Bool:
+		return cty.False
+	case ty.IsListType():
+		return cty.ListValEmpty(ty.ElementType())
+	case ty.IsSetType():
+		return cty.SetValEmpty(ty.ElementType())
+	case ty.IsMapType():
+		return cty.MapValEmpty(ty.ElementType())
+	case ty.IsTupleType():
+		var vals []cty.Value
+		for _, et := range ty.TupleElementTypes() {
+			vals = append(vals, placeholderValue(et))
+		}
+
+		if len(vals) == 0 {
+			return cty.EmptyTupleVal
+		}
+
+		return cty.TupleVal(vals)
+	case ty.IsObjectType():
+		vals := make(map[string]cty.Value)
+		for name, at := range ty.AttributeTypes() {
+			vals[name] = placeholderValue(at)
+		}
+
+		return cty.ObjectVal(vals)
+	default:
+		return cty.NullVal(ty)
+	}
+}
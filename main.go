@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -72,13 +74,6 @@ func realMain() int {
 		_, _ = fmt.Fprintf(os.Stderr, "failed to configure version checker: %v\n", err)
 	}
 
-	// Start checking for a new version as soon as possible
-	go func() {
-		if err := checker.Check(shutdownCtx); err != nil && !errors.Is(err, context.Canceled) {
-			_, _ = fmt.Fprintf(os.Stderr, "failed to check for new version: %v\n", err)
-		}
-	}()
-
 	// Load profile to get geography setting for HCP configuration
 	var geography string
 	profile, err := loadActiveProfile()
@@ -135,11 +130,24 @@ func realMain() int {
 	// Get the HCP Root command
 	hcpCmd := hcp.NewCmdHcp(cCtx)
 	cmdMap := cmd.ToCommandMap(hcpCmd)
+	hidden := cmd.HiddenCommands(hcpCmd)
+
+	// Hidden commands are run by hcp itself, with their output being that of
+	// the command they run, so only check for a new version for the others.
+	checkVersion := !runsHiddenCommand(args, hidden)
+	if checkVersion {
+		go func() {
+			if err := checker.Check(shutdownCtx); err != nil && !errors.Is(err, context.Canceled) {
+				_, _ = fmt.Fprintf(os.Stderr, "failed to check for new version: %v\n", err)
+			}
+		}()
+	}
 
 	c := cli.CLI{
 		Name:                       hcpCmd.Name,
 		Args:                       args,
 		Commands:                   cmdMap,
+		HiddenCommands:             hidden,
 		HelpFunc:                   cmd.RootHelpFunc(hcpCmd),
 		Autocomplete:               true,
 		AutocompleteNoDefaultFlags: true,
@@ -156,7 +164,7 @@ func realMain() int {
 	// Display the check results if we aren't being run in autocomplete. The
 	// check results will only be displayed if there is a new version and we
 	// haven't prompted recently.
-	if !isAutocomplete() {
+	if checkVersion && !isAutocomplete() {
 		checker.Display()
 	}
 
@@ -247,3 +255,16 @@ func loadProfile(ctx context.Context, iam iam_service.ClientService, tokenSource
 func isAutocomplete() bool {
 	return os.Getenv("COMP_LINE") != "" && os.Getenv("COMP_POINT") != ""
 }
+
+// runsHiddenCommand returns true if args run one of the hidden commands, given
+// by their path.
+func runsHiddenCommand(args, hidden []string) bool {
+	for _, h := range hidden {
+		path := strings.Fields(h)
+		if len(args) >= len(path) && slices.Equal(args[:len(path)], path) {
+			return true
+		}
+	}
+
+	return false
+}